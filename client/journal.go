@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// JournalEntry is an item of a sync stream as a Mirror applied it, and when,
+// in milliseconds since the journal started. the payload is kept as the JSON
+// it came in, so the journal reads as it is.
+type JournalEntry struct {
+	Time      int64
+	Type      string
+	Seq       uint64
+	Tick      uint64
+	Coalesced bool            `json:",omitempty"`
+	Payload   json.RawMessage `json:",omitempty"`
+}
+
+// Item is the entry as a sync stream had it, for Mirror.Apply.
+func (e *JournalEntry) Item() *share.SyncResponseObj {
+	return &share.SyncResponseObj{
+		Type:      e.Type,
+		Seq:       e.Seq,
+		Tick:      e.Tick,
+		Coalesced: e.Coalesced,
+		Payload:   e.Payload,
+	}
+}
+
+// Journal writes the items of a Mirror into a writer, a line of JSON each.
+// the snapshot after a resync is written too, so a replay starts over with
+// it like the mirror did. It is safe for concurrent use.
+type Journal struct {
+	lock  sync.Mutex
+	enc   *json.Encoder
+	start time.Time
+	err   error
+}
+
+func NewJournal(w io.Writer) *Journal {
+	return &Journal{enc: json.NewEncoder(w), start: time.Now()}
+}
+
+// Write adds item to the journal. once a write failed the journal keeps
+// the error and writes no more.
+func (j *Journal) Write(item *share.SyncResponseObj) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.err != nil {
+		return j.err
+	}
+	entry := JournalEntry{
+		Time:      int64(time.Since(j.start) / time.Millisecond),
+		Type:      item.Type,
+		Seq:       item.Seq,
+		Tick:      item.Tick,
+		Coalesced: item.Coalesced,
+		Payload:   item.Payload,
+	}
+	j.err = j.enc.Encode(&entry)
+	return j.err
+}
+
+// Err tells why the journal stopped writing, nil if it did not.
+func (j *Journal) Err() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.err
+}
+
+// JournalReader reads back what a Journal wrote.
+type JournalReader struct {
+	dec *json.Decoder
+}
+
+func NewJournalReader(r io.Reader) *JournalReader {
+	return &JournalReader{dec: json.NewDecoder(r)}
+}
+
+// Next returns the next entry, or io.EOF at the end of the journal.
+func (j *JournalReader) Next() (*JournalEntry, error) {
+	var entry JournalEntry
+	if err := j.dec.Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
@@ -0,0 +1,512 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// Mirror keeps a copy of a land up to date from a sync stream: the snapshot,
+// the events after it, and a new snapshot whenever it falls out of step. It is
+// safe for concurrent use.
+type Mirror struct {
+	client  *RPCClient
+	request share.SyncRequest
+
+	lock   sync.RWMutex
+	board  *share.GameBoard
+	synced bool
+	seq    uint64
+	tick   uint64
+	// journal, if set, gets every item the board took.
+	journal *Journal
+
+	listenLock sync.Mutex
+	listeners  []chan struct{}
+
+	streamLock sync.Mutex
+	stream     *SyncStream
+	closed     bool
+	// rescope is set when the stream was closed to sync another region.
+	rescope bool
+
+	doneCh chan struct{}
+	err    error
+}
+
+// NewMirror returns an empty mirror, fed by hand with Apply. Use MirrorCtx
+// for one that follows a server.
+func NewMirror() *Mirror {
+	m := Mirror{
+		board:  share.NewGameBoard(),
+		doneCh: make(chan struct{}),
+	}
+	return &m
+}
+
+// MirrorCtx syncs with the server and keeps the mirror up to date until Close
+// or until the client is closed. ctx only bounds the first sync.
+func (c *RPCClient) MirrorCtx(ctx context.Context, req *share.SyncRequest) (*Mirror, error) {
+	stream, err := c.SyncCtx(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMirror()
+	m.client = c
+	m.request = *req
+	m.stream = stream
+
+	go m.run(stream)
+	return m, nil
+}
+
+func (m *Mirror) run(stream *SyncStream) {
+	var err error
+	for {
+		var item *share.SyncResponseObj
+		item, err = stream.Next()
+		if err == ErrSlowConsumer || err == ErrStreamClosed && m.rescoped() {
+			stream, err = m.resync()
+		}
+		if err != nil {
+			break
+		}
+		if item == nil {
+			continue
+		}
+
+		err = m.Apply(item)
+		if err == ErrSeqGap {
+			log.Info(fmt.Sprintf("mirror missed events before seq %d, sync again", item.Seq))
+			stream, err = m.resync()
+			if err != nil {
+				break
+			}
+		} else if err != nil {
+			log.Error(fmt.Sprintf("mirror can not apply %s item: %s", item.Type, err))
+		}
+	}
+
+	log.Debug(fmt.Sprintf("mirror stopped: %s", err))
+	m.finish(err)
+}
+
+// resync replaces the stream with a new one, which starts with a snapshot.
+func (m *Mirror) resync() (*SyncStream, error) {
+	m.streamLock.Lock()
+	defer m.streamLock.Unlock()
+
+	if m.closed {
+		return nil, ErrStreamClosed
+	}
+	m.stream.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), m.client.timeout)
+	defer cancel()
+
+	stream, err := m.client.SyncCtx(ctx, &m.request)
+	if err != nil {
+		return nil, err
+	}
+	m.stream = stream
+	return stream, nil
+}
+
+func (m *Mirror) rescoped() bool {
+	m.streamLock.Lock()
+	defer m.streamLock.Unlock()
+
+	rescope := m.rescope
+	m.rescope = false
+	return rescope
+}
+
+// Watch scopes the mirror to the region in view, and as much again around
+// it, so the server does not send the still sprites of a whole big land. it
+// syncs again once view goes past what the mirror has. a mirror fed by hand
+// has everything it is given.
+func (m *Mirror) Watch(view share.Region) {
+	scope := share.Region{
+		X:      view.X - view.Width,
+		Y:      view.Y - view.Height,
+		Width:  3 * view.Width,
+		Height: 3 * view.Height,
+	}
+
+	m.lock.RLock()
+	land := share.Region{Height: len(m.board.Tiles)}
+	if land.Height > 0 {
+		land.Width = len(m.board.Tiles[0])
+	}
+	m.lock.RUnlock()
+
+	m.streamLock.Lock()
+	defer m.streamLock.Unlock()
+
+	if m.client == nil || m.closed || m.rescope {
+		return
+	}
+	if r := m.request.Region; r == nil {
+		// the whole land is here already, it is only worth scoping a land
+		// much bigger than the screen.
+		if scope.Covers(land) {
+			return
+		}
+	} else if r.Covers(view) {
+		return
+	}
+
+	log.Debug(fmt.Sprintf("mirror scoped to %d,%d %dx%d", scope.X, scope.Y, scope.Width, scope.Height))
+	m.request.Region = &scope
+	m.rescope = true
+	m.stream.Close()
+}
+
+func (m *Mirror) finish(err error) {
+	m.err = err
+	close(m.doneCh)
+
+	m.listenLock.Lock()
+	for _, ch := range m.listeners {
+		close(ch)
+	}
+	m.listeners = nil
+	m.listenLock.Unlock()
+}
+
+// Close stops following the server. Changes channels are closed once the
+// mirror has stopped.
+func (m *Mirror) Close() {
+	m.streamLock.Lock()
+	defer m.streamLock.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	if m.stream != nil {
+		m.stream.Close()
+	} else {
+		// fed by hand, there is no run to stop.
+		m.finish(ErrStreamClosed)
+	}
+}
+
+// Done is closed when the mirror stops, Err tells why.
+func (m *Mirror) Done() <-chan struct{} {
+	return m.doneCh
+}
+
+func (m *Mirror) Err() error {
+	<-m.doneCh
+	return m.err
+}
+
+// Changes returns a channel that gets a signal after the board changed.
+// signals are not queued, one signal may stand for many changes. the channel
+// is closed when the mirror stops.
+func (m *Mirror) Changes() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	m.listenLock.Lock()
+	defer m.listenLock.Unlock()
+
+	select {
+	case <-m.doneCh:
+		close(ch)
+	default:
+		m.listeners = append(m.listeners, ch)
+	}
+	return ch
+}
+
+func (m *Mirror) notify() {
+	m.listenLock.Lock()
+	defer m.listenLock.Unlock()
+
+	for _, ch := range m.listeners {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Board returns a copy of the board.
+func (m *Mirror) Board() *share.GameBoard {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.board.Clone()
+}
+
+// View calls f with the board itself and holds the board still meanwhile. f
+// must not keep the board or anything in it.
+func (m *Mirror) View(f func(board *share.GameBoard)) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	f(m.board)
+}
+
+// Synced tells if the board is a whole snapshot, with the events up to Seq
+// and Tick applied.
+func (m *Mirror) Synced() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.synced
+}
+
+func (m *Mirror) Seq() uint64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.seq
+}
+
+func (m *Mirror) Tick() uint64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.tick
+}
+
+// Apply puts one item of a sync stream on the board. It returns ErrSeqGap if
+// an event does not follow the last one, the board then needs a new
+// snapshot.
+func (m *Mirror) Apply(item *share.SyncResponseObj) error {
+	m.lock.Lock()
+	err := m.apply(item)
+	if err == nil && m.journal != nil {
+		if jerr := m.journal.Write(item); jerr != nil {
+			log.Debug(fmt.Sprintf("mirror can not write the journal: %s", jerr))
+		}
+	}
+	synced := m.synced
+	m.lock.Unlock()
+
+	// a half received snapshot is not worth looking at.
+	if err == nil && synced {
+		m.notify()
+	}
+	return err
+}
+
+// Record writes every item the mirror applies from now on into j, after
+// the board so far as a snapshot, so a replay of j starts where the mirror
+// is.
+func (m *Mirror) Record(j *Journal) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	items, err := boardItems(m.board)
+	if err != nil {
+		return err
+	}
+	if m.synced {
+		items = append(items, share.SyncResponseObj{Type: share.InfoItemTypeDone})
+	}
+	for _, item := range items {
+		item.Seq = m.seq
+		item.Tick = m.tick
+		if err := j.Write(&item); err != nil {
+			return err
+		}
+	}
+
+	m.journal = j
+	return nil
+}
+
+// boardItems are the snapshot items a sync stream would send of board.
+func boardItems(board *share.GameBoard) ([]share.SyncResponseObj, error) {
+	var items []share.SyncResponseObj
+	add := func(t string, v interface{}) error {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		items = append(items, share.SyncResponseObj{Type: t, Payload: payload})
+		return nil
+	}
+
+	var err error
+	if len(board.Tiles) > 0 {
+		err = add(share.InfoItemTypeTile, board.Tiles)
+	}
+	for i := 0; err == nil && i < len(board.Trees); i++ {
+		err = add(share.InfoItemTypeTree, board.Trees[i])
+	}
+	for i := 0; err == nil && i < len(board.Flowers); i++ {
+		err = add(share.InfoItemTypeFlower, board.Flowers[i])
+	}
+	for i := 0; err == nil && i < len(board.Grasses); i++ {
+		err = add(share.InfoItemTypeGrass, board.Grasses[i])
+	}
+	for i := 0; err == nil && i < len(board.Humans); i++ {
+		err = add(share.InfoItemTypeHuman, board.Humans[i])
+	}
+	for i := 0; err == nil && i < len(board.Animals); i++ {
+		err = add(share.InfoItemTypeAnimal, board.Animals[i])
+	}
+	for i := 0; err == nil && i < len(board.Portals); i++ {
+		err = add(share.InfoItemTypePortal, board.Portals[i])
+	}
+	return items, err
+}
+
+func (m *Mirror) apply(item *share.SyncResponseObj) error {
+	// a stream starts over with a new snapshot after a long disconnect.
+	if m.synced && (item.Type == share.InfoItemTypeTile || item.Type == share.InfoItemTypeTileRegion) {
+		m.board.Reset()
+		m.synced = false
+	}
+
+	if !m.synced {
+		if item.Type == share.InfoItemTypeDone {
+			m.synced = true
+			m.seq = item.Seq
+			m.tick = item.Tick
+			return nil
+		}
+		return applyInfoItem(m.board, item.Type, item.Payload)
+	}
+
+	if err := CheckSeq(m.seq, item.Seq, item.Coalesced); err != nil {
+		return err
+	}
+	m.seq = item.Seq
+	m.tick = item.Tick
+
+	return applyEventItem(m.board, item.Type, item.Payload)
+}
+
+func applyInfoItem(board *share.GameBoard, t string, p []byte) error {
+	switch t {
+	case share.InfoItemTypeTile:
+		tiles := [][]share.Tile{}
+		if err := json.Unmarshal(p, &tiles); err != nil {
+			return err
+		}
+		board.Tiles = tiles
+
+	case share.InfoItemTypeTileRegion:
+		tr := share.TileRegion{}
+		if err := json.Unmarshal(p, &tr); err != nil {
+			return err
+		}
+		// the tiles out of the region are left as ground.
+		board.Tiles = make([][]share.Tile, tr.Height)
+		for y := range board.Tiles {
+			board.Tiles[y] = make([]share.Tile, tr.Width)
+		}
+		for y, row := range tr.Tiles {
+			copy(board.Tiles[tr.Region.Y+y][tr.Region.X:], row)
+		}
+
+	case share.InfoItemTypeTree:
+		spr := share.Tree{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Trees = append(board.Trees, spr)
+
+	case share.InfoItemTypeFlower:
+		spr := share.Flower{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Flowers = append(board.Flowers, spr)
+
+	case share.InfoItemTypeGrass:
+		spr := share.Grass{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Grasses = append(board.Grasses, spr)
+
+	case share.InfoItemTypeHuman:
+		spr := share.Human{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Humans = append(board.Humans, spr)
+
+	case share.InfoItemTypeAnimal:
+		spr := share.Animal{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Animals = append(board.Animals, spr)
+
+	case share.InfoItemTypePortal:
+		spr := share.Portal{}
+		if err := json.Unmarshal(p, &spr); err != nil {
+			return err
+		}
+		board.Portals = append(board.Portals, spr)
+	}
+	return nil
+}
+
+// delete events carry nothing yet, they are left alone.
+func applyEventItem(board *share.GameBoard, t string, p []byte) error {
+	switch t {
+	case share.EventTypeMove:
+		event := share.SpriteMove{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyMove(event)
+
+	case share.EventTypeJump:
+		event := share.SpriteJump{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyJump(event)
+
+	case share.EventTypePosition:
+		event := share.SpritePosition{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyPosition(event)
+
+	case share.EventTypeLeave:
+		event := share.SpriteLeave{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyLeave(event)
+
+	case share.EventTypeEnter:
+		event := share.SpriteEnter{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyEnter(event)
+
+	case share.EventTypePortal:
+		event := share.Portal{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyPortal(event)
+
+	case share.EventTypeAdd:
+		event := share.SpriteAdd{}
+		if err := json.Unmarshal(p, &event); err != nil {
+			return err
+		}
+		board.ApplyAdd(event)
+	}
+	return nil
+}
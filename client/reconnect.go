@@ -0,0 +1,132 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var DefaultMinBackoff = 100 * time.Millisecond
+var DefaultMaxBackoff = 10 * time.Second
+
+type ConnState int
+
+const (
+	StateConnected ConnState = iota
+	// the connection is lost, a reconnecting client keeps dialing.
+	StateDisconnected
+	// the client is done, by Close or by an error without Reconnect.
+	StateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// resumable handlers are kept over a reconnect instead of failing.
+type resumable interface {
+	resumable() bool
+	resubscribe() error
+}
+
+func (c *RPCClient) setState(state ConnState) {
+	log.Debug(fmt.Sprintf("client %s: %s", c.addr, state))
+
+	if c.stateCh == nil {
+		return
+	}
+	select {
+	case c.stateCh <- state:
+	default:
+		log.Info("Dropping connection state, stateCh full.")
+	}
+}
+
+// reconnectLoop dials until it gets through or the client is closed. it runs
+// on the listen goroutine that lost the connection.
+func (c *RPCClient) reconnectLoop() {
+	backoff := c.minBackoff
+
+	for {
+		time.Sleep(jitter(backoff))
+
+		if c.isClosing() {
+			c.cleanup(true)
+			c.setState(StateClosed)
+			return
+		}
+
+		err := c.connect()
+		if err == nil {
+			break
+		}
+		log.Debug(fmt.Sprintf("can not reconnect to %s: %s", c.addr, err))
+
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+
+	// Close may have come while we were dialing.
+	if c.isClosing() {
+		c.writeLock.Lock()
+		c.conn.Close()
+		c.writeLock.Unlock()
+
+		c.cleanup(true)
+		c.setState(StateClosed)
+		return
+	}
+
+	c.setState(StateConnected)
+	go c.listen()
+
+	c.resubscribe()
+}
+
+// resubscribe opens every kept stream again on the new connection.
+func (c *RPCClient) resubscribe() {
+	var streams []resumable
+
+	c.dispatchLock.Lock()
+	for _, handler := range c.dispatch {
+		if r, ok := handler.(resumable); ok {
+			streams = append(streams, r)
+		}
+	}
+	c.dispatchLock.Unlock()
+
+	for _, r := range streams {
+		if err := r.resubscribe(); err != nil {
+			log.Error(fmt.Sprintf("can not resubscribe: %s", err))
+		}
+	}
+}
+
+func (c *RPCClient) isClosing() bool {
+	c.dispatchLock.Lock()
+	defer c.dispatchLock.Unlock()
+
+	return c.closing
+}
+
+// jitter spreads d over [d/2, d), so clients that lost the same server do not
+// all come back at once.
+func jitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
@@ -2,8 +2,11 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,49 +28,209 @@ func (c *RPCClient) getSeq() uint64 {
 type Config struct {
 	Addr    string
 	Timeout time.Duration
+
+	// Addrs are more addresses of the same service, like the stages of one
+	// cluster. they are dialed in turn after Addr when it can not be reached.
+	Addrs []string
+
+	// Resolve, if not nil, is asked for the addresses before every dial, for
+	// what moves between servers, like a land. Addr and Addrs are not used.
+	Resolve func() ([]string, error)
+
+	// Policy is asked of the server for every event stream of this client,
+	// one of the share.Policy* values. empty means the server default.
+	Policy string
+
+	// With Reconnect set a lost connection is dialed again, backing off from
+	// MinBackoff up to MaxBackoff, and open event and sync streams are
+	// resumed. Otherwise the client is closed on the first error.
+	Reconnect  bool
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// StateCh, if not nil, gets every change of the connection state.
+	StateCh chan<- ConnState
 }
 
 type RPCClient struct {
 	seq uint64
 
+	addr    string
+	addrs   []string
+	resolve func() ([]string, error)
 	timeout time.Duration
+	policy  string
 	conn    *net.TCPConn
 
+	reconnect  bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	stateCh    chan<- ConnState
+
 	reader *bufio.Reader
 	writer *bufio.Writer
 	dec    *codec.Decoder
 	enc    *codec.Encoder
 
-	dispatch map[uint64]seqHandler
+	// writeLock serializes requests on the shared writer, and guards the
+	// connection while it is replaced.
+	writeLock sync.Mutex
+
+	// dispatch is written by callers and read by listen. once the client is
+	// done shutdown is set and nothing can register. closing is set by
+	// Close, so listen does not reconnect.
+	dispatch     map[uint64]seqHandler
+	dispatchLock sync.Mutex
+	shutdown     bool
+	closing      bool
 }
 
+// ErrClosed is what pending and new requests get after the connection is
+// gone.
+var ErrClosed = errors.New("client closed")
+
 func ClientFromConfig(config *Config) (*RPCClient, error) {
 	if config.Timeout == 0 {
 		config.Timeout = 10 * time.Second
 	}
-	conn, err := net.DialTimeout("tcp", config.Addr, config.Timeout)
-	if err != nil {
-		return nil, err
+	if config.MinBackoff == 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = DefaultMaxBackoff
 	}
 	client := RPCClient{
-		seq:      1,
-		conn:     conn.(*net.TCPConn),
-		reader:   bufio.NewReader(conn),
-		writer:   bufio.NewWriter(conn),
-		timeout:  config.Timeout,
-		dispatch: make(map[uint64]seqHandler),
+		seq:        1,
+		addr:       config.Addr,
+		addrs:      append([]string{config.Addr}, config.Addrs...),
+		resolve:    config.Resolve,
+		timeout:    config.Timeout,
+		policy:     config.Policy,
+		reconnect:  config.Reconnect,
+		minBackoff: config.MinBackoff,
+		maxBackoff: config.MaxBackoff,
+		stateCh:    config.StateCh,
+		dispatch:   make(map[uint64]seqHandler),
 	}
 
-	client.dec = codec.NewDecoder(client.reader,
-		&codec.MsgpackHandle{RawToString: true, WriteExt: true})
-	client.enc = codec.NewEncoder(client.writer,
-		&codec.MsgpackHandle{RawToString: true, WriteExt: true})
+	if err := client.connect(); err != nil {
+		return nil, err
+	}
+	client.setState(StateConnected)
 
 	go client.listen()
 	return &client, nil
 }
 
+// connect dials the address used last, then the others in turn, until one
+// gets through.
+func (c *RPCClient) connect() error {
+	if c.resolve != nil {
+		addrs, err := c.resolve()
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 {
+			return errors.New("no address to connect to")
+		}
+		c.addrs = addrs
+	}
+
+	start := 0
+	for i, addr := range c.addrs {
+		if addr == c.addr {
+			start = i
+		}
+	}
+
+	var err error
+	for i := range c.addrs {
+		addr := c.addrs[(start+i)%len(c.addrs)]
+		if err = c.connectTo(addr); err == nil {
+			if c.addr != "" && addr != c.addr {
+				log.Info(fmt.Sprintf("client %s: failed over to %s", c.addr, addr))
+			}
+			c.addr = addr
+			return nil
+		}
+		log.Debug(fmt.Sprintf("can not connect to %s: %s", addr, err))
+	}
+	return err
+}
+
+// connectTo dials addr and runs the handshake, before anything else can be
+// sent on the new connection.
+func (c *RPCClient) connectTo(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	dec := codec.NewDecoder(reader,
+		&codec.MsgpackHandle{RawToString: true, WriteExt: true})
+	enc := codec.NewEncoder(writer,
+		&codec.MsgpackHandle{RawToString: true, WriteExt: true})
+
+	if err := c.handshake(conn, writer, dec, enc); err != nil {
+		conn.Close()
+		return err
+	}
+
+	c.writeLock.Lock()
+	c.conn = conn.(*net.TCPConn)
+	c.reader = reader
+	c.writer = writer
+	c.dec = dec
+	c.enc = enc
+	c.writeLock.Unlock()
+
+	return nil
+}
+
+// handshake runs before listen, so it reads its response by itself.
+func (c *RPCClient) handshake(conn net.Conn, writer *bufio.Writer, dec *codec.Decoder, enc *codec.Encoder) error {
+	header := share.RequestHeader{
+		Seq:     c.getSeq(),
+		Command: share.HandshakeCommand,
+	}
+	request := share.HandshakeRequest{
+		Version: share.ProtocolVersion,
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := enc.Encode(&header); err != nil {
+		return err
+	}
+	if err := enc.Encode(&request); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	var respHeader share.ResponseHeader
+	var resp share.HandshakeResponse
+	if err := dec.Decode(&respHeader); err != nil {
+		return err
+	}
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	return strToError(respHeader.Error)
+}
+
 func (c *RPCClient) send(header *share.RequestHeader, obj interface{}) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
 	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
 		return err
 	}
@@ -87,9 +250,79 @@ func (c *RPCClient) send(header *share.RequestHeader, obj interface{}) error {
 	return nil
 }
 
+// request registers handler for header.Seq and sends the request. handler
+// stays registered only if sending succeeds.
+func (c *RPCClient) request(header *share.RequestHeader, obj interface{}, handler seqHandler) error {
+	if err := c.register(header.Seq, handler); err != nil {
+		return err
+	}
+	if err := c.send(header, obj); err != nil {
+		c.deregister(header.Seq)
+		return err
+	}
+	return nil
+}
+
+// call sends a request and waits for its response to be decoded into resp.
+// the server side ResponseHeader.Error comes back as the error.
+func (c *RPCClient) call(ctx context.Context, command string, req, resp interface{}) error {
+	seq := c.getSeq()
+
+	header := share.RequestHeader{
+		Seq:     seq,
+		Command: command,
+	}
+
+	doneCh := make(chan error, 1)
+	handler := &respHandler{
+		client: c,
+		seq:    seq,
+		resp:   resp,
+		doneCh: doneCh,
+	}
+
+	if err := c.request(&header, req, handler); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-doneCh:
+		return err
+	case <-ctx.Done():
+		// a late response is skipped by listen.
+		c.deregister(seq)
+		return ctx.Err()
+	}
+}
+
+func (c *RPCClient) register(seq uint64, handler seqHandler) error {
+	c.dispatchLock.Lock()
+	defer c.dispatchLock.Unlock()
+
+	if c.shutdown {
+		return ErrClosed
+	}
+	c.dispatch[seq] = handler
+	return nil
+}
+
+func (c *RPCClient) deregister(seq uint64) {
+	c.dispatchLock.Lock()
+	defer c.dispatchLock.Unlock()
+
+	delete(c.dispatch, seq)
+}
+
+func (c *RPCClient) handlerFor(seq uint64) (seqHandler, bool) {
+	c.dispatchLock.Lock()
+	defer c.dispatchLock.Unlock()
+
+	handler, ok := c.dispatch[seq]
+	return handler, ok
+}
+
 func (c *RPCClient) listen() {
 	var respHeader share.ResponseHeader
-	defer c.Close()
 	for {
 		if err := c.dec.Decode(&respHeader); err != nil {
 			log.Error(err.Error())
@@ -97,22 +330,114 @@ func (c *RPCClient) listen() {
 		}
 		c.handleResponse(respHeader.Seq, &respHeader)
 	}
+
+	c.writeLock.Lock()
+	c.conn.Close()
+	c.writeLock.Unlock()
+
+	c.dispatchLock.Lock()
+	closing := c.closing
+	c.dispatchLock.Unlock()
+
+	if c.reconnect && !closing {
+		c.setState(StateDisconnected)
+		c.cleanup(false)
+		c.reconnectLoop()
+		return
+	}
+
+	c.cleanup(true)
+	c.setState(StateClosed)
 }
 
 func (c *RPCClient) handleResponse(seq uint64, respHeader *share.ResponseHeader) {
-	handler, ok := c.dispatch[seq]
+	handler, ok := c.handlerFor(seq)
 	if ok {
 		handler.Handle(respHeader)
+		return
+	}
+
+	// every header is followed by a body, skip it to stay in step.
+	var body interface{}
+	if err := c.dec.Decode(&body); err != nil {
+		log.Error(fmt.Sprintf("can not skip response body of seq %d: %s", seq, err))
+	}
+}
+
+// cleanup fails pending requests, all of them or those that can not be
+// resumed after a reconnect. it runs on the listen goroutine, so no handler
+// is in the middle of Handle.
+func (c *RPCClient) cleanup(all bool) {
+	var failed []seqHandler
+
+	c.dispatchLock.Lock()
+	if all {
+		c.shutdown = true
+	}
+	for seq, handler := range c.dispatch {
+		if r, ok := handler.(resumable); ok && !all && r.resumable() {
+			continue
+		}
+		failed = append(failed, handler)
+		delete(c.dispatch, seq)
+	}
+	c.dispatchLock.Unlock()
+
+	for _, handler := range failed {
+		handler.Cleanup(ErrClosed)
 	}
 }
 
+// Close closes the connection for good. every pending request fails with
+// ErrClosed.
 func (c *RPCClient) Close() {
+	c.dispatchLock.Lock()
+	c.closing = true
+	c.dispatchLock.Unlock()
+
+	c.writeLock.Lock()
 	c.conn.Close()
+	c.writeLock.Unlock()
 }
 
 func strToError(s string) error {
-	if s != "" {
-		return fmt.Errorf(s)
+	switch s {
+	case "":
+		return nil
+	case share.ErrSeqTooOld:
+		return ErrSeqTooOld
+	case share.ErrSlowConsumer:
+		return ErrSlowConsumer
+	case share.ErrNotPossessed:
+		return ErrNotPossessed
+	}
+	return errors.New(s)
+}
+
+// ErrSeqTooOld ends a resumed stream when the server no longer keeps the
+// events asked for, or the land started over since.
+var ErrSeqTooOld = errors.New(share.ErrSeqTooOld)
+
+// ErrSeqGap means some events between two stream items never arrived, the
+// receiver is out of date and should sync again.
+var ErrSeqGap = errors.New("gap in event seq, sync again")
+
+// ErrSlowConsumer is the end of a stream the server closed because we did not
+// read it fast enough.
+var ErrSlowConsumer = errors.New(share.ErrSlowConsumer)
+
+// ErrNotPossessed is returned by Move for a character this client does not
+// steer, or no longer after a reconnect.
+var ErrNotPossessed = errors.New(share.ErrNotPossessed)
+
+// CheckSeq checks that an event with seq directly follows the last one seen.
+// A coalesced event only has to come after it.
+func CheckSeq(last, seq uint64, coalesced bool) error {
+	if coalesced && seq > last {
+		return nil
+	}
+	if seq != last+1 {
+		return ErrSeqGap
 	}
 	return nil
 }
@@ -1,89 +1,210 @@
 package client
 
 import (
+	"context"
+
 	"github.com/nickelchen/wonder/share"
 )
 
-func (c *RPCClient) Plant(what, color string, number int, respCh chan<- string) error {
-	seq := c.getSeq()
+// PlantCtx plants and waits for the result, at most until ctx is done.
+func (c *RPCClient) PlantCtx(ctx context.Context, req *share.PlantRequest) (*share.PlantResponse, error) {
+	var resp share.PlantResponse
+	if err := c.call(ctx, share.PlantCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	header := share.RequestHeader{
-		Seq:     seq,
-		Command: share.PlantCommand,
+// PingCtx asks the server how far its land got, at most until ctx is done.
+func (c *RPCClient) PingCtx(ctx context.Context) (*share.PingResponse, error) {
+	var resp share.PingResponse
+	if err := c.call(ctx, share.PingCommand, &share.PingRequest{}, &resp); err != nil {
+		return nil, err
 	}
-	request := share.PlantRequest{
-		What:   share.PlantType(what),
-		Color:  color,
-		Number: number,
+	return &resp, nil
+}
+
+// HostLandCtx asks the server to host a land for the stage, at most until
+// ctx is done.
+func (c *RPCClient) HostLandCtx(ctx context.Context, req *share.HostLandRequest) (*share.HostLandResponse, error) {
+	var resp share.HostLandResponse
+	if err := c.call(ctx, share.HostLandCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	c.dispatch[seq] = &plantHandler{
-		client: c,
-		seq:    seq,
-		respCh: respCh,
+// DropLandCtx asks the server to stop a land it hosts, at most until ctx is
+// done.
+func (c *RPCClient) DropLandCtx(ctx context.Context, req *share.DropLandRequest) (*share.DropLandResponse, error) {
+	var resp share.DropLandResponse
+	if err := c.call(ctx, share.DropLandCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	return c.send(&header, &request)
+// PossessCtx takes a character away from the land, it moves only with
+// MoveCtx of this client from now on. at most until ctx is done.
+func (c *RPCClient) PossessCtx(ctx context.Context, req *share.PossessRequest) (*share.PossessResponse, error) {
+	var resp share.PossessResponse
+	if err := c.call(ctx, share.PossessCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
 }
 
-func (c *RPCClient) Info(respCh chan<- share.InfoResponseObj) error {
-	seq := c.getSeq()
+// ReleaseCtx gives a possessed character back to the land, at most until ctx
+// is done.
+func (c *RPCClient) ReleaseCtx(ctx context.Context, req *share.ReleaseRequest) (*share.ReleaseResponse, error) {
+	var resp share.ReleaseResponse
+	if err := c.call(ctx, share.ReleaseCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	header := share.RequestHeader{
-		Seq:     seq,
-		Command: share.InfoCommand,
+// MoveCtx moves a possessed character one step, at most until ctx is done.
+// it returns ErrNotPossessed if this client does not steer it, for example
+// after a reconnect.
+func (c *RPCClient) MoveCtx(ctx context.Context, req *share.MoveRequest) (*share.MoveResponse, error) {
+	var resp share.MoveResponse
+	if err := c.call(ctx, share.MoveCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	request := share.InfoRequest{}
+// AddPortalCtx puts a portal in a land of the server, at most until ctx is
+// done.
+func (c *RPCClient) AddPortalCtx(ctx context.Context, req *share.AddPortalRequest) (*share.AddPortalResponse, error) {
+	var resp share.AddPortalResponse
+	if err := c.call(ctx, share.AddPortalCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	initCh := make(chan error, 1)
-	c.dispatch[seq] = &infoHandler{
-		client: c,
-		seq:    seq,
-		init:   false,
-		initCh: initCh,
-		respCh: respCh,
+// HandoffCtx hands a sprite to a land of the server, at most until ctx is
+// done.
+func (c *RPCClient) HandoffCtx(ctx context.Context, req *share.HandoffRequest) (*share.HandoffResponse, error) {
+	var resp share.HandoffResponse
+	if err := c.call(ctx, share.HandoffCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	if err := c.send(&header, &request); err != nil {
-		delete(c.dispatch, seq)
-		return err
+// InfoCtx opens a stream of the whole land. ctx only bounds the opening.
+func (c *RPCClient) InfoCtx(ctx context.Context, req *share.InfoRequest) (*InfoStream, error) {
+	s := newStream(c, c.getSeq())
+	s.initBody = func() interface{} { return &share.InfoResponse{} }
+	s.newItem = func() interface{} { return &share.InfoResponseObj{} }
+	s.lastItem = func(item interface{}) bool {
+		return item.(*share.InfoResponseObj).Type == share.InfoItemTypeDone
 	}
 
-	// wait for first response
-	select {
-	case err := <-initCh:
-		return err
+	if err := c.open(ctx, share.InfoCommand, req, s); err != nil {
+		return nil, err
 	}
+	return &InfoStream{s: s}, nil
 }
 
-func (c *RPCClient) Subscribe(respCh chan<- share.EventResponseObj) error {
-	seq := c.getSeq()
+// SubscribeCtx opens an event stream. ctx only bounds the opening. An empty
+// req.Policy is filled with the one of the client config. A reconnecting
+// client resumes the stream after the last event, the stream ends with
+// ErrSeqTooOld if the server no longer has the events in between.
+func (c *RPCClient) SubscribeCtx(ctx context.Context, req *share.SubscribeRequest) (*EventStream, error) {
+	request := *req
+	if request.Policy == "" {
+		request.Policy = c.policy
+	}
+
+	s := newStream(c, c.getSeq())
+	s.initBody = func() interface{} { return &share.SubscribeResponse{} }
+	s.newItem = func() interface{} { return &share.EventResponseObj{} }
+
+	var landID string
+	s.accept = func(body interface{}) {
+		landID = body.(*share.SubscribeResponse).LandID
+	}
+	seen := false
+	var lastSeq uint64
+	s.track = func(item interface{}) {
+		seen = true
+		lastSeq = item.(*share.EventResponseObj).Seq
+	}
+	s.reopen = func() (string, interface{}) {
+		resume := request
+		if seen {
+			resume.Resume = true
+			resume.LastSeq = lastSeq
+			resume.LandID = landID
+		}
+		return share.SubscribeCommand, &resume
+	}
+
+	if err := c.open(ctx, share.SubscribeCommand, &request, s); err != nil {
+		return nil, err
+	}
+	return &EventStream{s: s}, nil
+}
 
-	header := share.RequestHeader{
-		Seq:     seq,
-		Command: share.SubscribeCommand,
+// SyncCtx opens a sync stream. ctx only bounds the opening. An empty
+// req.Policy is filled with the one of the client config. A reconnecting
+// client resumes the stream, or starts over with a new snapshot if the events
+// in between are gone. one of a req.Region always starts over, the server
+// can not tell what of the events it missed the region saw.
+func (c *RPCClient) SyncCtx(ctx context.Context, req *share.SyncRequest) (*SyncStream, error) {
+	request := *req
+	if request.Policy == "" {
+		request.Policy = c.policy
 	}
 
-	request := share.SubscribeRequest{}
+	s := newStream(c, c.getSeq())
+	// a resumed one answers with a SubscribeResponse, it has the same
+	// fields.
+	s.initBody = func() interface{} { return &share.SyncResponse{} }
+	s.newItem = func() interface{} { return &share.SyncResponseObj{} }
 
-	initCh := make(chan error, 1)
-	c.dispatch[seq] = &eventHandler{
-		client: c,
-		seq:    seq,
-		init:   false,
-		initCh: initCh,
-		respCh: respCh,
+	var landID string
+	s.accept = func(body interface{}) {
+		landID = body.(*share.SyncResponse).LandID
 	}
 
-	if err := c.send(&header, &request); err != nil {
-		delete(c.dispatch, seq)
-		return err
+	synced := false
+	var lastSeq uint64
+	s.track = func(item interface{}) {
+		obj := item.(*share.SyncResponseObj)
+		if synced || obj.Type == share.InfoItemTypeDone {
+			synced = true
+			lastSeq = obj.Seq
+		}
+	}
+	// once the snapshot is through, a sync stream is an event stream. the
+	// event items decode fine as SyncResponseObj, they have the same fields.
+	s.reopen = func() (string, interface{}) {
+		if !synced || request.Region != nil {
+			synced = false
+			return share.SyncCommand, &request
+		}
+		resume := share.SubscribeRequest{
+			Land:    request.Land,
+			Resume:  true,
+			LastSeq: lastSeq,
+			LandID:  landID,
+			Policy:  request.Policy,
+		}
+		return share.SubscribeCommand, &resume
+	}
+	// the reader sees a new snapshot start with its tiles item.
+	s.fallback = func() (string, interface{}) {
+		synced = false
+		return share.SyncCommand, &request
 	}
 
-	// wait for first response
-	select {
-	case err := <-initCh:
-		return err
+	if err := c.open(ctx, share.SyncCommand, &request, s); err != nil {
+		return nil, err
 	}
+	return &SyncStream{s: s}, nil
 }
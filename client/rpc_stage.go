@@ -1,43 +1,102 @@
 package client
 
 import (
+	"context"
+
 	"github.com/nickelchen/wonder/share"
 )
 
-func (c *RPCClient) ServerAlive(serverAddr string, respCh chan<- string) error {
-	seq := c.getSeq()
+// ServerAliveCtx reports to the stage and waits for its answer, at most until
+// ctx is done.
+func (c *RPCClient) ServerAliveCtx(ctx context.Context, req *share.ServerAliveRequest) (*share.ServerAliveResponse, error) {
+	var resp share.ServerAliveResponse
+	if err := c.call(ctx, share.ServerAliveCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	header := share.RequestHeader{
-		Seq:     seq,
-		Command: share.ServerAliveCommand,
+// ListServersCtx asks the stage for its members, at most until ctx is done.
+func (c *RPCClient) ListServersCtx(ctx context.Context, req *share.ListServersRequest) (*share.ListServersResponse, error) {
+	var resp share.ListServersResponse
+	if err := c.call(ctx, share.ListServersCommand, req, &resp); err != nil {
+		return nil, err
 	}
-	request := share.ServerAliveRequest{
-		ServerAddr: serverAddr,
+	return &resp, nil
+}
+
+// ServerLeaveCtx tells the stage the server is going away, at most until ctx
+// is done.
+func (c *RPCClient) ServerLeaveCtx(ctx context.Context, req *share.ServerLeaveRequest) (*share.ServerLeaveResponse, error) {
+	var resp share.ServerLeaveResponse
+	if err := c.call(ctx, share.ServerLeaveCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	c.dispatch[seq] = &serverAliveHandler{
-		client: c,
-		seq:    seq,
-		respCh: respCh,
+// SaveLandCtx hands the stage a snapshot of a land hosted for it, at most
+// until ctx is done.
+func (c *RPCClient) SaveLandCtx(ctx context.Context, req *share.SaveLandRequest) (*share.SaveLandResponse, error) {
+	var resp share.SaveLandResponse
+	if err := c.call(ctx, share.SaveLandCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	return c.send(&header, &request)
+// LocateLandCtx asks the stage which server has a land, at most until ctx is
+// done.
+func (c *RPCClient) LocateLandCtx(ctx context.Context, req *share.LocateLandRequest) (*share.LocateLandResponse, error) {
+	var resp share.LocateLandResponse
+	if err := c.call(ctx, share.LocateLandCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
 }
 
-func (c *RPCClient) ListServers(respCh chan<- []string) error {
-	seq := c.getSeq()
+// CreatePortalCtx asks the stage for a portal from one land to another, at
+// most until ctx is done.
+func (c *RPCClient) CreatePortalCtx(ctx context.Context, req *share.CreatePortalRequest) (*share.CreatePortalResponse, error) {
+	var resp share.CreatePortalResponse
+	if err := c.call(ctx, share.CreatePortalCommand, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
 
-	header := share.RequestHeader{
-		Seq:     seq,
-		Command: share.ListServersCommand,
+// WorldCtx asks the stage for its world map, at most until ctx is done.
+func (c *RPCClient) WorldCtx(ctx context.Context) (*share.WorldResponse, error) {
+	var resp share.WorldResponse
+	if err := c.call(ctx, share.WorldCommand, &share.WorldRequest{}, &resp); err != nil {
+		return nil, err
 	}
-	request := share.ListServersRequest{}
+	return &resp, nil
+}
 
-	c.dispatch[seq] = &listServersHandler{
-		client: c,
-		seq:    seq,
-		respCh: respCh,
+// ReplicateCtx hands the membership table of a stage to one of its peers, at
+// most until ctx is done.
+func (c *RPCClient) ReplicateCtx(ctx context.Context, req *share.ReplicateRequest) (*share.ReplicateResponse, error) {
+	var resp share.ReplicateResponse
+	if err := c.call(ctx, share.ReplicateCommand, req, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
+}
 
-	return c.send(&header, &request)
+// SubscribeMembersCtx opens a stream of membership changes on the stage. ctx
+// only bounds the opening. A reconnecting client subscribes again, changes
+// while it was away are not sent, list the members to catch up.
+func (c *RPCClient) SubscribeMembersCtx(ctx context.Context) (*MemberStream, error) {
+	s := newStream(c, c.getSeq())
+	s.initBody = func() interface{} { return &share.SubscribeMembersResponse{} }
+	s.newItem = func() interface{} { return &share.MemberEvent{} }
+	s.reopen = func() (string, interface{}) {
+		return share.SubscribeMembersCommand, &share.SubscribeMembersRequest{}
+	}
+
+	if err := c.open(ctx, share.SubscribeMembersCommand, &share.SubscribeMembersRequest{}, s); err != nil {
+		return nil, err
+	}
+	return &MemberStream{s: s}, nil
 }
@@ -0,0 +1,131 @@
+package client_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+const waitTime = 5 * time.Second
+
+func start(t *testing.T) (*wondertest.Cluster, *client.RPCClient) {
+	t.Helper()
+
+	cluster, err := wondertest.Start(&wondertest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	cl, err := cluster.Client(&client.Config{Timeout: waitTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cl.Close)
+	return cluster, cl
+}
+
+// run calls f from n goroutines at once, and waits for all of them.
+func run(t *testing.T, n int, f func(i int)) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(2 * waitTime):
+		t.Fatal("calls still pending")
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	cluster, cl := start(t)
+	// the server has no members, the stage is not asked here.
+	members := share.ListServersResponse{Members: []share.Member{{Name: "canned"}}}
+	cluster.Respond(share.ListServersCommand, &members)
+
+	ctx := context.Background()
+	run(t, 16, func(i int) {
+		for k := 0; k < 20; k++ {
+			if (i+k)%2 == 0 {
+				resp, err := cl.PlantCtx(ctx, &share.PlantRequest{What: share.PlantFlower, Color: "red", Number: 1})
+				if err != nil {
+					t.Errorf("plant: %s", err)
+					return
+				}
+				if resp.Succ+resp.Fail != 1 {
+					t.Errorf("plant answered %d of 1", resp.Succ+resp.Fail)
+				}
+				continue
+			}
+
+			resp, err := cl.ListServersCtx(ctx, &share.ListServersRequest{})
+			if err != nil {
+				t.Errorf("list servers: %s", err)
+				return
+			}
+			if len(resp.Members) != 1 || resp.Members[0].Name != "canned" {
+				t.Errorf("list servers answered %v", resp.Members)
+			}
+		}
+	})
+}
+
+func TestDisconnectFailsPendingCalls(t *testing.T) {
+	cluster, cl := start(t)
+	// nothing is answered before the connection drops.
+	cluster.SlowStreams(waitTime)
+
+	var lock sync.Mutex
+	var errs []error
+	var sent sync.WaitGroup
+	sent.Add(16)
+
+	go func() {
+		// the calls are on their way, or the server has them.
+		sent.Wait()
+		time.Sleep(100 * time.Millisecond)
+		cluster.Disconnect()
+	}()
+
+	ctx := context.Background()
+	run(t, 16, func(i int) {
+		var err error
+		sent.Done()
+		if i%2 == 0 {
+			_, err = cl.PlantCtx(ctx, &share.PlantRequest{What: share.PlantTree, Number: 1})
+		} else {
+			_, err = cl.PingCtx(ctx)
+		}
+
+		lock.Lock()
+		errs = append(errs, err)
+		lock.Unlock()
+	})
+
+	for _, err := range errs {
+		if err != client.ErrClosed {
+			t.Errorf("pending call got %v, want ErrClosed", err)
+		}
+	}
+	if _, err := cl.PingCtx(ctx); err != client.ErrClosed {
+		t.Errorf("call after the disconnect got %v, want ErrClosed", err)
+	}
+}
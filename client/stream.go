@@ -0,0 +1,337 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// DefaultStreamBuffer is how many items a stream holds for its reader. Once
+// it is full the connection waits for the reader, which in turn makes the
+// server apply the subscriber policy.
+var DefaultStreamBuffer = 512
+
+// ErrStreamClosed is returned by Next after Close.
+var ErrStreamClosed = errors.New("stream closed")
+
+// stream is the seqHandler behind InfoStream, EventStream, SyncStream and
+// MemberStream.
+type stream struct {
+	client *RPCClient
+	seq    uint64
+
+	// initLock guards init, resuming and opened. resubscribe sets them on
+	// the reconnect goroutine while Handle reads them on the new listen one.
+	initLock sync.Mutex
+	init     bool
+	initCh   chan error
+	initBody func() interface{}
+	newItem  func() interface{}
+	lastItem func(interface{}) bool
+	// accept, if set, gets the init body of every open the server took.
+	accept func(interface{})
+
+	// track remembers how far the stream got, reopen builds the request
+	// that picks up from there after a reconnect, and fallback the one to
+	// use when the server no longer has those events. a stream without
+	// reopen fails on reconnect.
+	track    func(interface{})
+	reopen   func() (string, interface{})
+	fallback func() (string, interface{})
+	opened   bool
+	resuming bool
+
+	itemCh    chan interface{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	endOnce   sync.Once
+
+	// err is why the stream ended, it is set before itemCh is closed.
+	err error
+}
+
+func newStream(c *RPCClient, seq uint64) *stream {
+	s := stream{
+		client:  c,
+		seq:     seq,
+		initCh:  make(chan error, 1),
+		itemCh:  make(chan interface{}, DefaultStreamBuffer),
+		closeCh: make(chan struct{}),
+	}
+	return &s
+}
+
+func (s *stream) Handle(respHeader *share.ResponseHeader) {
+	s.initLock.Lock()
+	first := !s.init
+	s.init = true
+	resuming := s.resuming
+	s.initLock.Unlock()
+
+	if first {
+		body := s.initBody()
+		if err := s.client.dec.Decode(body); err != nil {
+			if !resuming {
+				s.end(err)
+			}
+			s.initCh <- err
+			return
+		}
+		// a refused resubscribe is dealt with by resubscribe.
+		err := strToError(respHeader.Error)
+		if err != nil && !resuming {
+			s.end(err)
+		}
+		if err == nil {
+			s.initLock.Lock()
+			s.opened = true
+			s.initLock.Unlock()
+			if s.accept != nil {
+				s.accept(body)
+			}
+		}
+		s.initCh <- err
+		return
+	}
+
+	// the server ended the stream.
+	if respHeader.Error != "" {
+		s.client.dec.Decode(s.initBody())
+		s.end(strToError(respHeader.Error))
+		return
+	}
+
+	item := s.newItem()
+	if err := s.client.dec.Decode(item); err != nil {
+		log.Error(fmt.Sprintf("can not decode stream item of seq %d: %s", s.seq, err))
+		s.end(err)
+		return
+	}
+
+	if s.track != nil {
+		s.track(item)
+	}
+
+	select {
+	case s.itemCh <- item:
+	case <-s.closeCh:
+		return
+	}
+
+	if s.lastItem != nil && s.lastItem(item) {
+		s.end(io.EOF)
+	}
+}
+
+func (s *stream) Cleanup(err error) {
+	s.initLock.Lock()
+	first := !s.init
+	s.init = true
+	s.initLock.Unlock()
+
+	if first {
+		s.initCh <- err
+	}
+	s.end(err)
+}
+
+// end is called from the listen goroutine, or by resubscribe when nothing
+// more comes for this seq.
+func (s *stream) end(err error) {
+	s.endOnce.Do(func() {
+		s.client.deregister(s.seq)
+		s.err = err
+		close(s.itemCh)
+	})
+}
+
+// a stream still being opened by the caller fails like any request.
+func (s *stream) resumable() bool {
+	s.initLock.Lock()
+	defer s.initLock.Unlock()
+
+	return s.reopen != nil && s.opened
+}
+
+// resubscribe opens the stream again on a new connection, with the same seq.
+// it only gives up on the stream when the server refuses it, a connection
+// lost again is left to the next reconnect.
+func (s *stream) resubscribe() error {
+	command, req := s.reopen()
+	err, refused := s.resubscribeWith(command, req)
+	if err == ErrSeqTooOld && s.fallback != nil {
+		command, req = s.fallback()
+		err, refused = s.resubscribeWith(command, req)
+	}
+	if err != nil && refused {
+		s.end(err)
+	}
+	return err
+}
+
+func (s *stream) resubscribeWith(command string, req interface{}) (err error, refused bool) {
+	header := share.RequestHeader{
+		Seq:     s.seq,
+		Command: command,
+	}
+
+	// a late answer to an earlier try.
+	select {
+	case <-s.initCh:
+	default:
+	}
+
+	s.initLock.Lock()
+	s.init = false
+	s.resuming = true
+	s.initLock.Unlock()
+	if err := s.client.request(&header, req, s); err != nil {
+		return err, false
+	}
+
+	select {
+	case err := <-s.initCh:
+		return err, err != nil
+	case <-s.closeCh:
+		return ErrStreamClosed, false
+	case <-time.After(s.client.timeout):
+		return fmt.Errorf("no answer to resubscribe of stream %d", s.seq), false
+	}
+}
+
+func (s *stream) next() (interface{}, error) {
+	select {
+	case item, ok := <-s.itemCh:
+		if !ok {
+			return nil, s.err
+		}
+		return item, nil
+	case <-s.closeCh:
+		return nil, ErrStreamClosed
+	}
+}
+
+// Close stops the stream here and on the server.
+func (s *stream) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+		s.client.deregister(s.seq)
+		s.client.stop(s.seq)
+	})
+}
+
+// open sends the request of the stream and waits for the server to accept it.
+func (c *RPCClient) open(ctx context.Context, command string, req interface{}, s *stream) error {
+	header := share.RequestHeader{
+		Seq:     s.seq,
+		Command: command,
+	}
+
+	if err := c.request(&header, req, s); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-s.initCh:
+		return err
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// stop asks the server to end the stream opened with seq, without waiting.
+func (c *RPCClient) stop(seq uint64) {
+	header := share.RequestHeader{
+		Seq:     c.getSeq(),
+		Command: share.StopCommand,
+	}
+	handler := &respHandler{
+		client: c,
+		seq:    header.Seq,
+		resp:   &share.StopResponse{},
+		doneCh: make(chan error, 1),
+	}
+
+	if err := c.request(&header, &share.StopRequest{Seq: seq}, handler); err != nil {
+		log.Debug(fmt.Sprintf("can not stop stream %d: %s", seq, err))
+	}
+}
+
+// InfoStream yields the land item by item. Next returns io.EOF after the
+// InfoItemTypeDone item.
+type InfoStream struct {
+	s *stream
+}
+
+func (i *InfoStream) Next() (*share.InfoResponseObj, error) {
+	item, err := i.s.next()
+	if err != nil {
+		return nil, err
+	}
+	return item.(*share.InfoResponseObj), nil
+}
+
+func (i *InfoStream) Close() {
+	i.s.Close()
+}
+
+// EventStream yields events until the server ends it, then Next returns the
+// reason, for example ErrSlowConsumer.
+type EventStream struct {
+	s *stream
+}
+
+func (e *EventStream) Next() (*share.EventResponseObj, error) {
+	item, err := e.s.next()
+	if err != nil {
+		return nil, err
+	}
+	return item.(*share.EventResponseObj), nil
+}
+
+func (e *EventStream) Close() {
+	e.s.Close()
+}
+
+// SyncStream yields the snapshot items and then the events, see
+// share.SyncResponseObj.
+type SyncStream struct {
+	s *stream
+}
+
+func (y *SyncStream) Next() (*share.SyncResponseObj, error) {
+	item, err := y.s.next()
+	if err != nil {
+		return nil, err
+	}
+	return item.(*share.SyncResponseObj), nil
+}
+
+func (y *SyncStream) Close() {
+	y.s.Close()
+}
+
+// MemberStream yields membership changes until the stage ends it.
+type MemberStream struct {
+	s *stream
+}
+
+func (m *MemberStream) Next() (*share.MemberEvent, error) {
+	item, err := m.s.next()
+	if err != nil {
+		return nil, err
+	}
+	return item.(*share.MemberEvent), nil
+}
+
+func (m *MemberStream) Close() {
+	m.s.Close()
+}
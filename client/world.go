@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// World follows every land of the world map of a stage, one mirror each, and
+// shows them as one board with each land at its place on the map. It is safe
+// for concurrent use.
+type World struct {
+	lands   []share.LandPlacement
+	clients []*RPCClient
+	mirrors []*Mirror
+
+	// the world is in lands of width by height tiles, the one at minX, minY
+	// is drawn at 0, 0.
+	width, height int
+	minX, minY    int
+	cols, rows    int
+}
+
+// WorldCtx asks stage for its world map and syncs with the server of every
+// land, with a copy of config each. the lands are looked up with stage again
+// when their servers go away. ctx only bounds the first sync.
+func WorldCtx(ctx context.Context, stage *RPCClient, config Config) (*World, error) {
+	resp, err := stage.WorldCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Lands) == 0 {
+		return nil, errors.New("the stage has no world map")
+	}
+
+	w := World{
+		lands:  resp.Lands,
+		width:  resp.Lands[0].Width,
+		height: resp.Lands[0].Height,
+		minX:   resp.Lands[0].X,
+		minY:   resp.Lands[0].Y,
+	}
+	maxX, maxY := w.minX, w.minY
+	for _, land := range w.lands {
+		if land.X < w.minX {
+			w.minX = land.X
+		}
+		if land.Y < w.minY {
+			w.minY = land.Y
+		}
+		if land.X > maxX {
+			maxX = land.X
+		}
+		if land.Y > maxY {
+			maxY = land.Y
+		}
+	}
+	w.cols, w.rows = maxX-w.minX+1, maxY-w.minY+1
+
+	for _, land := range w.lands {
+		name := land.Name
+		landConfig := config
+		landConfig.Addr = land.ServerAddr
+		landConfig.Resolve = func() ([]string, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), stage.timeout)
+			defer cancel()
+
+			resp, err := stage.LocateLandCtx(ctx, &share.LocateLandRequest{Name: name})
+			if err != nil {
+				return nil, err
+			}
+			return []string{resp.Land.ServerAddr}, nil
+		}
+
+		cl, err := ClientFromConfig(&landConfig)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.clients = append(w.clients, cl)
+
+		mirror, err := cl.MirrorCtx(ctx, &share.SyncRequest{Land: name})
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		w.mirrors = append(w.mirrors, mirror)
+	}
+	return &w, nil
+}
+
+// Size is how many tiles the whole world is across and down.
+func (w *World) Size() (int, int) {
+	return w.cols * w.width, w.rows * w.height
+}
+
+// Lands are the names of the lands of the world.
+func (w *World) Lands() []string {
+	var names []string
+	for _, land := range w.lands {
+		names = append(names, land.Name)
+	}
+	return names
+}
+
+// Synced tells if every land has a whole snapshot.
+func (w *World) Synced() bool {
+	for _, m := range w.mirrors {
+		if !m.Synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Tick is the tick of the land that is furthest on.
+func (w *World) Tick() uint64 {
+	var tick uint64
+	for _, m := range w.mirrors {
+		if t := m.Tick(); t > tick {
+			tick = t
+		}
+	}
+	return tick
+}
+
+// Close stops following every land.
+func (w *World) Close() {
+	for _, m := range w.mirrors {
+		m.Close()
+	}
+	for _, cl := range w.clients {
+		cl.Close()
+	}
+}
+
+// Err waits until every land stopped and tells why the first one did.
+func (w *World) Err() error {
+	var err error
+	for _, m := range w.mirrors {
+		if e := m.Err(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Changes returns a channel that gets a signal after any land changed, like
+// Mirror.Changes. it is closed when every land stopped.
+func (w *World) Changes() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	var wg sync.WaitGroup
+	for _, m := range w.mirrors {
+		wg.Add(1)
+		go func(changes <-chan struct{}) {
+			defer wg.Done()
+			for range changes {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}(m.Changes())
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// View calls f with the board of the whole world, put together from every
+// land. f must not keep the board or anything in it.
+func (w *World) View(f func(board *share.GameBoard)) {
+	cols, rows := w.Size()
+	board := share.NewGameBoard()
+	board.Tiles = make([][]share.Tile, rows)
+	for y := range board.Tiles {
+		board.Tiles[y] = make([]share.Tile, cols)
+	}
+
+	for i, m := range w.mirrors {
+		offX := (w.lands[i].X - w.minX) * w.width
+		offY := (w.lands[i].Y - w.minY) * w.height
+		m.View(func(b *share.GameBoard) {
+			stitch(board, b, offX, offY)
+		})
+	}
+
+	f(board)
+}
+
+// Watch scopes every land to the part of view, of the whole world, on it.
+func (w *World) Watch(view share.Region) {
+	for i, m := range w.mirrors {
+		offX := (w.lands[i].X - w.minX) * w.width
+		offY := (w.lands[i].Y - w.minY) * w.height
+		m.Watch(share.Region{
+			X:      view.X - offX,
+			Y:      view.Y - offY,
+			Width:  view.Width,
+			Height: view.Height,
+		})
+	}
+}
+
+// stitch copies the land b onto the world board at offX, offY.
+func stitch(board, b *share.GameBoard, offX, offY int) {
+	at := func(p share.Point) share.Point {
+		return share.Point{X: p.X + offX, Y: p.Y + offY}
+	}
+
+	for y, row := range b.Tiles {
+		if y+offY >= len(board.Tiles) {
+			break
+		}
+		worldRow := board.Tiles[y+offY]
+		for x, t := range row {
+			if x+offX < len(worldRow) {
+				worldRow[x+offX] = t
+			}
+		}
+	}
+
+	for _, s := range b.Trees {
+		s.PutPoint(at(s.P))
+		board.Trees = append(board.Trees, s)
+	}
+	for _, s := range b.Flowers {
+		s.PutPoint(at(s.P))
+		board.Flowers = append(board.Flowers, s)
+	}
+	for _, s := range b.Grasses {
+		s.PutPoint(at(s.P))
+		board.Grasses = append(board.Grasses, s)
+	}
+	for _, s := range b.Humans {
+		s.PutPoint(at(s.P))
+		board.Humans = append(board.Humans, s)
+	}
+	for _, s := range b.Animals {
+		s.PutPoint(at(s.P))
+		board.Animals = append(board.Animals, s)
+	}
+	for _, s := range b.Portals {
+		s.PutPoint(at(s.P))
+		board.Portals = append(board.Portals, s)
+	}
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func start(t *testing.T) *wondertest.Cluster {
+	t.Helper()
+
+	cluster, err := wondertest.Start(&wondertest.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func TestPlant(t *testing.T) {
+	cluster := start(t)
+	ui := cli.NewMockUi()
+	c := PlantCommand{Ui: ui}
+
+	if code := c.Run([]string{"-rpc-addr", cluster.ServerAddr, "-what", "tree", "-number", "2"}); code != 0 {
+		t.Fatalf("plant exited %d: %s", code, ui.OutputWriter.String())
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, "succ: 2") {
+		t.Fatalf("plant said %q", out)
+	}
+}
+
+func TestPlantFails(t *testing.T) {
+	cluster := start(t)
+	cluster.Fail(share.PlantCommand, "no room")
+	ui := cli.NewMockUi()
+	c := PlantCommand{Ui: ui}
+
+	if code := c.Run([]string{"-rpc-addr", cluster.ServerAddr}); code != 1 {
+		t.Fatalf("failed plant exited %d", code)
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, "no room") {
+		t.Fatalf("failed plant said %q", out)
+	}
+}
+
+func TestPlantInAnUnknownLand(t *testing.T) {
+	cluster := start(t)
+	ui := cli.NewMockUi()
+	c := PlantCommand{Ui: ui}
+
+	if code := c.Run([]string{"-stage-addr", cluster.StageAddr, "-land", "nowhere"}); code != 1 {
+		t.Fatalf("plant in an unknown land exited %d", code)
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, "can not locate land nowhere") {
+		t.Fatalf("plant in an unknown land said %q", out)
+	}
+}
+
+func TestList(t *testing.T) {
+	cluster := start(t)
+	ui := cli.NewMockUi()
+	c := ListCommand{Ui: ui}
+
+	if code := c.Run([]string{"-rpc-addr", cluster.StageAddr}); code != 0 {
+		t.Fatalf("list exited %d: %s", code, ui.OutputWriter.String())
+	}
+	out := ui.OutputWriter.String()
+	if !strings.HasPrefix(out, "NAME") || !strings.Contains(out, cluster.ServerAddr) {
+		t.Fatalf("list did not show the server:\n%s", out)
+	}
+}
+
+func TestListJSON(t *testing.T) {
+	cluster := start(t)
+	members := share.ListServersResponse{Members: []share.Member{{Name: "canned", Addr: "10.0.0.1:9898"}}}
+	cluster.Respond(share.ListServersCommand, &members)
+	ui := cli.NewMockUi()
+	c := ListCommand{Ui: ui}
+
+	if code := c.Run([]string{"-rpc-addr", cluster.StageAddr, "-format", "json"}); code != 0 {
+		t.Fatalf("list exited %d: %s", code, ui.OutputWriter.String())
+	}
+	if out := ui.OutputWriter.String(); !strings.Contains(out, `"Name": "canned"`) {
+		t.Fatalf("list said %q", out)
+	}
+}
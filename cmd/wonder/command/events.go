@@ -0,0 +1,98 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+)
+
+type EventsCommand struct {
+	Ui cli.Ui
+}
+
+func (c *EventsCommand) Help() string {
+	helpText := `
+Usage: wonder events [options]
+
+	Watch the events of every land of every server, through the stage.
+	each line is the server, the land, the seq, the type and the event.
+	the land of a server itself is shown as -.
+
+Options:
+	--stage-addr the stage to watch, ip:port
+	--land only events of these lands, comma separated
+	--type only events of these types, comma separated,
+	       like move,jump,leave,enter
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *EventsCommand) Run(args []string) int {
+	var stageAddr string
+	var lands, types string
+
+	cmdFlags := flag.NewFlagSet("events", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "ip:port of the stage")
+	cmdFlags.StringVar(&lands, "land", "", "lands to watch, comma separated")
+	cmdFlags.StringVar(&types, "type", "", "event types to watch, comma separated")
+
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	config := client.Config{
+		Addr:      stageAddr,
+		Timeout:   20 * time.Second,
+		Reconnect: true,
+	}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not get client: %s", err))
+		return 1
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	request := share.SubscribeRequest{
+		Lands: splitList(lands),
+		Types: splitList(types),
+	}
+	stream, err := cl.SubscribeCtx(ctx, &request)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not subscribe: %s", err))
+		return 1
+	}
+	defer stream.Close()
+
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("stream ended: %s", err))
+			return 1
+		}
+		c.Ui.Output(fmt.Sprintf("%s\t%s\t%d\t%s\t%s",
+			event.Server, orDash(event.Land), event.Seq, event.Type, event.Payload))
+	}
+}
+
+// splitList reads a comma separated list, nil for none.
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func (c *EventsCommand) Synopsis() string {
+	return "watch the events of every land through the stage."
+}
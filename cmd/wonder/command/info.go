@@ -1,7 +1,7 @@
 package command
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -27,176 +27,382 @@ func init() {
 
 type InfoCommand struct {
 	Ui cli.Ui
-
-	board *share.GameBoard
 }
 
 func (c *InfoCommand) Help() string {
 	helpText := `
-Usage: wonder info
+Usage: wonder info [options]
 
 	Get every information about wonder land. including tiles, sprites etc.
+	a land bigger than the terminal is panned with the arrow keys or hjkl,
+	f follows the sprite again, esc quits. i shows what is under a cursor,
+	moved with the arrow keys or hjkl meanwhile, e shows the events as they
+	come, scrolled back with page up and page down.
+
+Options:
+	--policy what the server does when we fall behind,
+	         choose from [drop-oldest, disconnect, coalesce]
+	--rpc-addr the server to talk to, ip:port
+	--land the land to look at, by name, the stage tells where it is
+	--stage-addr the stage to ask for the land, ip:port
+	--world look at every land of the world map of the stage at once
+	--follow the name of a sprite to keep in the middle of the screen,
+	         Alice by default, none if empty
+	--once print the land as text once it is synced, and quit
+	--text print the land as text on every change, instead of the screen.
+	       with it or --once, errors go to stderr instead of the log
+	--color print the text in ANSI colours
+	--theme what the land looks like, a builtin theme of classic,
+	        okabe-ito, safe with colour blindness, and mono, or a JSON file
+	--colors the colours of the terminal, 16, 256 or truecolor
+	--record a file to record the land into as an asciicast, as it changes,
+	         for asciinema play
+	--journal a file to keep the journal of the land in, every change of
+	          it, for wonder render
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *InfoCommand) Run(args []string) int {
+	var policy string
+	var rpcAddr string
+	var landName, stageAddr string
+	var world bool
+	var follow string
+	var once, text, color bool
+	var themeName, colors string
+	var record, journal string
+
 	cmdFlags := flag.NewFlagSet("information", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&policy, "policy", share.PolicyCoalesce, "what to do when falling behind")
+	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "ip:port to connect")
+	cmdFlags.StringVar(&landName, "land", "", "name of the land to look at")
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "ip:port of the stage")
+	cmdFlags.BoolVar(&world, "world", false, "look at the whole world map")
+	cmdFlags.StringVar(&follow, "follow", "Alice", "name of a sprite to follow")
+	cmdFlags.BoolVar(&once, "once", false, "print the land once and quit")
+	cmdFlags.BoolVar(&text, "text", false, "print the land as text")
+	cmdFlags.BoolVar(&color, "color", false, "print the text in colours")
+	cmdFlags.StringVar(&themeName, "theme", render.DefaultTheme, "builtin theme or theme file")
+	cmdFlags.StringVar(&colors, "colors", render.Colors256, "16, 256 or truecolor")
+	cmdFlags.StringVar(&record, "record", "", "asciicast file to record into")
+	cmdFlags.StringVar(&journal, "journal", "", "file to keep the journal in")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
+	// the ui writes into the log of the screen. a script or a pipe reading
+	// the text has to see why it failed.
+	if basic, ok := c.Ui.(*cli.BasicUi); ok && (once || text) {
+		c.Ui = &cli.BasicUi{Reader: basic.Reader, Writer: basic.Writer, ErrorWriter: os.Stderr}
+	}
 
+	stateCh := make(chan client.ConnState, 4)
 	config := client.Config{
-		Addr:    "127.0.0.1:9898",
-		Timeout: 20 * time.Second,
+		Addr:      rpcAddr,
+		Timeout:   20 * time.Second,
+		Policy:    policy,
+		Reconnect: true,
+		StateCh:   stateCh,
 	}
 
-	cl, err := client.ClientFromConfig(&config)
+	theme, err := loadTheme(themeName, colors)
 	if err != nil {
-		c.Ui.Output(fmt.Sprintf("can not get client: %s\n", err))
+		c.Ui.Error(err.Error())
 		return 1
 	}
-
-	board := share.NewGameBoard()
-	c.board = board
-
-	rend := render.TermRender{}
-	rend.Stage(board, 2*gCol, gRow, c.Ui.(*cli.BasicUi).Writer)
-
-	respCh1 := make(chan share.InfoResponseObj, 512)
-	if err := cl.Info(respCh1); err != nil {
-		c.Ui.Output(fmt.Sprintf("can not get info: %s\n", err))
+	if world && journal != "" {
+		c.Ui.Error("a journal is of one land, not of the world")
 		return 1
 	}
 
-	c.receiveInfoItems(respCh1, &rend)
+	var rec *recording
+	if record != "" {
+		rec, err = newRecording(record, theme, colors)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("can not record: %s", err))
+			return 1
+		}
+	}
 
-	respCh2 := make(chan share.EventResponseObj, 512)
-	if err := cl.Subscribe(respCh2); err != nil {
-		c.Ui.Output(fmt.Sprintf("can not subscribe: %s\n", err))
-		return 1
+	var rend render.InfoRender = &render.TermRender{Follow: follow, Land: landName, Theme: theme, Colors: colors}
+	if once || text {
+		rend = &render.TextRender{Color: color, Once: once, Theme: theme, Colors: colors}
 	}
+	go c.showConnState(stateCh)
 
-	// long run polling events from server
-	go c.receiveEventItems(respCh2, &rend)
+	if world {
+		return c.runWorld(stageAddr, config, rend, rec)
+	}
 
-	rend.Loop()
+	stageWidth, stageHeight := gCol, gRow
+	if landName != "" {
+		placement, err := locateLand(stageAddr, landName)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("can not locate land %s: %s", landName, err))
+			return 1
+		}
+		if placement.Width > 0 {
+			stageWidth, stageHeight = placement.Width, placement.Height
+		}
+		// the land moves to another server when its server fails.
+		config.Resolve = func() ([]string, error) {
+			placement, err := locateLand(stageAddr, landName)
+			if err != nil {
+				return nil, err
+			}
+			return []string{placement.ServerAddr}, nil
+		}
+	}
 
-	return 0
-}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("can not get client: %s", err))
+		return 1
+	}
 
-func (c *InfoCommand) receiveInfoItems(respCh chan share.InfoResponseObj, rend render.InfoRender) {
+	// opened before the mirror, so it is closed after it.
+	var journalFile *os.File
+	if journal != "" {
+		journalFile, err = os.Create(journal)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("can not keep a journal: %s", err))
+			return 1
+		}
+		defer journalFile.Close()
+	}
 
-	for {
-		select {
-		// receive from info response
-		case r := <-respCh:
-			t := r.Type
-			p := r.Payload
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
 
-			c.Ui.Output(fmt.Sprintf("Get Info Response Payload: %v", string(p)))
+	// the mirror follows one stream for both the snapshot and the events
+	// after it, so nothing happens in between that we do not know about.
+	mirror, err := cl.MirrorCtx(ctx, &share.SyncRequest{Land: landName})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("can not sync: %s", err))
+		return 1
+	}
+	defer mirror.Close()
 
-			switch t {
-			case share.InfoItemTypeTile:
-				tiles := [][]share.Tile{}
-				json.Unmarshal(p, &tiles)
-				c.Ui.Output(fmt.Sprintf("receive tiles struct is: %v\n", tiles))
+	if journalFile != nil {
+		if err := mirror.Record(client.NewJournal(journalFile)); err != nil {
+			c.Ui.Error(fmt.Sprintf("can not keep a journal: %s", err))
+			return 1
+		}
+	}
 
-				c.board.Tiles = tiles
+	subscribe := func() (*client.EventStream, error) {
+		return cl.SubscribeCtx(ctx, &share.SubscribeRequest{Land: landName})
+	}
+	return c.show(mirror, 2*stageWidth, stageHeight, rend, rec, subscribe)
+}
 
-			case share.InfoItemTypeTree:
-				spr := share.Tree{}
-				json.Unmarshal(p, &spr)
-				c.Ui.Output(fmt.Sprintf("receive tree struct is: %v\n", spr))
+// runWorld shows every land of the world map, each from its own server.
+func (c *InfoCommand) runWorld(stageAddr string, config client.Config, rend render.InfoRender, rec *recording) int {
+	stageConfig := client.Config{
+		Addr:      stageAddr,
+		Timeout:   5 * time.Second,
+		Reconnect: true,
+	}
+	stage, err := client.ClientFromConfig(&stageConfig)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("can not get stage client: %s", err))
+		return 1
+	}
+	defer stage.Close()
 
-				c.board.Trees = append(c.board.Trees, spr)
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
 
-			case share.InfoItemTypeFlower:
-				spr := share.Flower{}
-				json.Unmarshal(p, &spr)
-				c.Ui.Output(fmt.Sprintf("receive flower struct is: %v\n", spr))
+	world, err := client.WorldCtx(ctx, stage, config)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("can not sync the world: %s", err))
+		return 1
+	}
+	defer world.Close()
 
-				c.board.Flowers = append(c.board.Flowers, spr)
+	if term, ok := rend.(*render.TermRender); ok {
+		term.Land = "world"
+	}
+	// the stage tells the events of every land of the world.
+	subscribe := func() (*client.EventStream, error) {
+		return stage.SubscribeCtx(ctx, &share.SubscribeRequest{Lands: world.Lands()})
+	}
+	stageWidth, stageHeight := world.Size()
+	return c.show(world, 2*stageWidth, stageHeight, rend, rec, subscribe)
+}
 
-			case share.InfoItemTypeGrass:
-				spr := share.Grass{}
-				json.Unmarshal(p, &spr)
-				c.Ui.Output(fmt.Sprintf("receive grass struct is: %v\n", spr))
+// show draws board with rend until rend is done, and records it into rec
+// meanwhile, if set. a render with an event log gets the events of
+// subscribe too. a text render draws into stdout, the screen one logs into
+// the ui.
+func (c *InfoCommand) show(board followed, stageWidth, stageHeight int, rend render.InfoRender,
+	rec *recording, subscribe func() (*client.EventStream, error)) int {
+	out := c.Ui.(*cli.BasicUi).Writer
+	if text, ok := rend.(*render.TextRender); ok {
+		out = os.Stdout
+		// a text frame is not redrawn, it waits for the whole board.
+		if text.Once {
+			waitSynced(board)
+		}
+	}
 
-				c.board.Grasses = append(c.board.Grasses, spr)
+	if logger, ok := rend.(eventLogger); ok {
+		events, err := subscribe()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("can not subscribe: %s", err))
+			return 1
+		}
+		defer events.Close()
+		go logEvents(events, logger)
+	}
 
-			case share.InfoItemTypeHuman:
-				spr := share.Human{}
-				json.Unmarshal(p, &spr)
-				c.Ui.Output(fmt.Sprintf("receive human struct is: %v\n", spr))
+	rend.Stage(board, stageWidth, stageHeight, out)
+	if rec != nil {
+		rec.start(board, stageWidth, stageHeight)
+	}
 
-				c.board.Humans = append(c.board.Humans, spr)
+	go c.renderChanges(board, rend, rec)
 
-			case share.InfoItemTypeDone:
-				c.Ui.Output("received all repsonse. finish")
+	rend.Loop()
 
-				return
-			}
+	if rec != nil {
+		if err := rec.stop(); err != nil {
+			c.Ui.Error(fmt.Sprintf("recording failed: %s", err))
+			return 1
 		}
-
-		rend.Render()
 	}
+	return 0
 }
 
-func (c *InfoCommand) receiveEventItems(respCh chan share.EventResponseObj, rend render.InfoRender) {
-
-	for {
-		select {
-		// receive from subscribe response
-		case r := <-respCh:
-			t := r.Type
-			p := r.Payload
-
-			c.Ui.Output(fmt.Sprintf("Get Subscribed Response Payload: %v", string(p)))
+// recording is the file of --record, and the render that draws into it.
+type recording struct {
+	file   *os.File
+	rend   *render.CastRender
+	doneCh chan struct{}
+}
 
-			switch t {
-			case share.EventTypeMove:
-				event := share.SpriteMove{}
-				json.Unmarshal(p, &event)
-				c.Ui.Output(fmt.Sprintf("receive sprite move struct is: %v\n", event))
+func newRecording(path string, theme *render.Theme, colors string) (*recording, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := recording{
+		file:   file,
+		rend:   &render.CastRender{Theme: theme, Colors: colors},
+		doneCh: make(chan struct{}),
+	}
+	return &r, nil
+}
 
-				c.board.MoveEventsCh() <- event
+func (r *recording) start(board followed, stageWidth, stageHeight int) {
+	r.rend.Stage(board, stageWidth, stageHeight, r.file)
+	go func() {
+		r.rend.Loop()
+		close(r.doneCh)
+	}()
+}
 
-				// c.board.MoveEvents = append(c.board.MoveEvents, event)
+// stop ends the recording once the frame it is on is written.
+func (r *recording) stop() error {
+	r.rend.Close()
+	<-r.doneCh
+	if err := r.rend.Err(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
 
-			case share.EventTypeJump:
-				event := share.SpriteJump{}
-				json.Unmarshal(p, &event)
-				c.Ui.Output(fmt.Sprintf("receive sprite jump struct is: %v\n", event))
+// waitSynced waits until board has a whole snapshot, or has stopped.
+func waitSynced(board followed) {
+	changes := board.Changes()
+	for !board.Synced() {
+		if _, ok := <-changes; !ok {
+			return
+		}
+	}
+}
 
-				c.board.JumpEventsCh() <- event
+// loadTheme reads the theme of --theme, for a render in colors.
+func loadTheme(name, colors string) (*render.Theme, error) {
+	switch colors {
+	case render.Colors16, render.Colors256, render.ColorsTruecolor:
+	default:
+		return nil, fmt.Errorf("unknown colours %s, choose from 16, 256 and truecolor", colors)
+	}
+	return render.LoadTheme(name)
+}
 
-				// c.board.MoveEvents = append(c.board.MoveEvents, event)
+// locateLand asks the stage which server has the land.
+func locateLand(stageAddr, name string) (*share.LandPlacement, error) {
+	config := client.Config{
+		Addr:    stageAddr,
+		Timeout: 5 * time.Second,
+	}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		return nil, err
+	}
+	defer cl.Close()
 
-			case share.EventTypeAdd:
-				event := share.SpriteAdd{}
-				json.Unmarshal(p, &event)
-				c.Ui.Output(fmt.Sprintf("receive sprite add struct is: %v\n", event))
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
 
-				c.board.AddEventsCh() <- event
+	resp, err := cl.LocateLandCtx(ctx, &share.LocateLandRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Land, nil
+}
 
-				// c.board.AddEvents = append(c.board.AddEvents, event)
+// followed is a client.Mirror or a client.World.
+type followed interface {
+	render.Board
+	Changes() <-chan struct{}
+	Synced() bool
+	Err() error
+}
 
-			case share.EventTypeDelete:
-				event := share.SpriteDelete{}
-				json.Unmarshal(p, &event)
-				c.Ui.Output(fmt.Sprintf("receive sprite delete struct is: %v\n", event))
+// eventLogger is a render with an event log, like render.TermRender.
+type eventLogger interface {
+	Log(line string)
+}
 
-				c.board.DeleteEventsCh() <- event
+func (c *InfoCommand) renderChanges(board followed, rend render.InfoRender, rec *recording) {
+	for range board.Changes() {
+		rend.Render()
+		if rec != nil {
+			rec.rend.Render()
+		}
+	}
+	c.Ui.Output(fmt.Sprintf("mirror stopped: %s", board.Err()))
 
-				// c.board.DeleteEvents = append(c.board.DeleteEvents, event)
-			}
+	// a text render has no esc to quit with, it stops with the board.
+	if text, ok := rend.(*render.TextRender); ok {
+		text.Close()
+	}
+}
 
+// logEvents shows the events of stream in the event log of rend.
+func logEvents(stream *client.EventStream, rend eventLogger) {
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			return
+		}
+		line := fmt.Sprintf("%d %s %s", event.Seq, event.Type, event.Payload)
+		if event.Land != "" {
+			line = event.Land + " " + line
 		}
+		rend.Log(line)
+	}
+}
 
-		rend.Render()
+func (c *InfoCommand) showConnState(stateCh <-chan client.ConnState) {
+	for state := range stateCh {
+		c.Ui.Output(fmt.Sprintf("connection to server: %s", state))
 	}
 }
 
@@ -0,0 +1,35 @@
+package command
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestInfoOnceFailsOnStderr(t *testing.T) {
+	cluster := start(t)
+	var log bytes.Buffer
+	c := InfoCommand{Ui: &cli.BasicUi{Writer: &log}}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	code := c.Run([]string{"-once", "-stage-addr", cluster.StageAddr, "-land", "nowhere"})
+	os.Stderr = stderr
+	w.Close()
+
+	if code != 1 {
+		t.Fatalf("info of an unknown land exited %d", code)
+	}
+	out, _ := ioutil.ReadAll(r)
+	if !strings.Contains(string(out), "can not locate land nowhere") {
+		t.Fatalf("info said %q on stderr, and %q in the log", out, log.String())
+	}
+}
@@ -1,10 +1,15 @@
 package command
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"sort"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/mitchellh/cli"
@@ -18,21 +23,41 @@ func (c *ListCommand) Help() string {
 	helpText := `
 Usage: wonder list [options]
 
-	List alive servers
+	List alive servers, and suspect ones that missed their last report, with
+	their status
+
+Options:
+	--rpc-addr the stage to ask, ip:port
+	--all also list left and failed servers
+	--tag key=regexp, only list servers with a matching tag, may be repeated
+	--format choose from [table, json]
 `
 	return strings.TrimSpace(helpText)
 }
 
 func (c *ListCommand) Run(args []string) int {
+	var rpcAddr string
+	var all bool
+	var format string
+	tags := make(tagsFlag)
+
 	cmdFlags := flag.NewFlagSet("list", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "ip:port to connect")
+	cmdFlags.BoolVar(&all, "all", false, "list servers in every state")
+	cmdFlags.Var(tags, "tag", "key=regexp filter on tags")
+	cmdFlags.StringVar(&format, "format", "table", "output format")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
+	if format != "table" && format != "json" {
+		c.Ui.Output(fmt.Sprintf("unknown format: %s", format))
+		return 1
+	}
 
 	config := client.Config{
-		Addr:    "127.0.0.1:9898",
+		Addr:    rpcAddr,
 		Timeout: 20 * time.Second,
 	}
 	cl, err := client.ClientFromConfig(&config)
@@ -41,21 +66,107 @@ func (c *ListCommand) Run(args []string) int {
 		return 1
 	}
 
-	respCh := make(chan []string)
-	if err := cl.ListServers(respCh); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	request := share.ListServersRequest{
+		All:  all,
+		Tags: tags,
+	}
+	resp, err := cl.ListServersCtx(ctx, &request)
+	if err != nil {
 		c.Ui.Output(fmt.Sprintf("can not list: %s", err))
 		return 1
 	}
 
-	select {
-	case r := <-respCh:
-		c.Ui.Output(fmt.Sprintf("get list response: %v\n", r))
+	if format == "json" {
+		out, err := json.MarshalIndent(resp.Members, "", "  ")
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("can not encode: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(out))
+		return 0
 	}
 
+	c.Ui.Output(membersTable(resp.Members))
 	return 0
 
 }
 
+func membersTable(members []share.Member) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "NAME\tADDR\tSTATUS\tHEALTH\tRTT\tID\tTAGS\tSIZE\tPOPULATION\tLOAD\tLANDS")
+	for _, m := range members {
+		rtt := time.Duration(m.RTT) * time.Microsecond
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%dx%d\t%s\t%d\t%s\n",
+			m.Name, m.Addr, m.Status, memberHealth(m), rtt, m.ID, joinTags(m.Tags),
+			m.Width, m.Height, joinCounts(m.Population), m.Load, strings.Join(m.Lands, ","))
+	}
+	w.Flush()
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// memberHealth names the stuck lands after the health, the home land as
+// home.
+func memberHealth(m share.Member) string {
+	if len(m.StuckLands) == 0 {
+		return orDash(m.Health)
+	}
+	var names []string
+	for _, name := range m.StuckLands {
+		if name == "" {
+			name = "home"
+		}
+		names = append(names, name)
+	}
+	return fmt.Sprintf("%s(stuck:%s)", orDash(m.Health), strings.Join(names, ","))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func joinTags(tags map[string]string) string {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func joinCounts(counts map[string]int) string {
+	var pairs []string
+	for k, v := range counts {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// tagsFlag collects repeated -tag key=value flags.
+type tagsFlag map[string]string
+
+func (t tagsFlag) String() string {
+	return joinTags(t)
+}
+
+func (t tagsFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("tag should be key=value: %s", value)
+	}
+	t[kv[0]] = kv[1]
+	return nil
+}
+
 func (c *ListCommand) Synopsis() string {
 	return "list some trees or flowers in wonder land."
 }
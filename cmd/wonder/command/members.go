@@ -0,0 +1,241 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+)
+
+type MembersCommand struct {
+	Ui cli.Ui
+}
+
+func (c *MembersCommand) Help() string {
+	helpText := `
+Usage: wonder members [options]
+
+	List every server the stage knows of, or watch them join, leave and fail.
+
+Options:
+	--rpc-addr the stage to ask, ip:port
+	--watch print membership events as they happen
+	--event-handler [type,...=]script, run script with sh on every event,
+	         or only on the given types. may be repeated. the script gets
+	         WONDER_EVENT in its env, and the member on stdin as
+	         name, addr, status and tags separated by tabs. the scripts
+	         run one at a time, in the order of the events.
+	--format choose from [table, json]
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *MembersCommand) Run(args []string) int {
+	var rpcAddr string
+	var watch bool
+	var format string
+	var handlers eventHandlersFlag
+
+	cmdFlags := flag.NewFlagSet("members", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "ip:port to connect")
+	cmdFlags.BoolVar(&watch, "watch", false, "print membership events as they happen")
+	cmdFlags.Var(&handlers, "event-handler", "script to run on membership events")
+	cmdFlags.StringVar(&format, "format", "table", "output format")
+
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if format != "table" && format != "json" {
+		c.Ui.Output(fmt.Sprintf("unknown format: %s", format))
+		return 1
+	}
+	if len(handlers) > 0 && !watch {
+		c.Ui.Output("--event-handler needs --watch")
+		return 1
+	}
+
+	config := client.Config{
+		Addr:      rpcAddr,
+		Timeout:   20 * time.Second,
+		Reconnect: watch,
+	}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not get client: %s", err))
+		return 1
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	if !watch {
+		resp, err := cl.ListServersCtx(ctx, &share.ListServersRequest{All: true})
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("can not list: %s", err))
+			return 1
+		}
+		if format == "json" {
+			out, _ := json.MarshalIndent(resp.Members, "", "  ")
+			c.Ui.Output(string(out))
+		} else {
+			c.Ui.Output(membersTable(resp.Members))
+		}
+		return 0
+	}
+
+	stream, err := cl.SubscribeMembersCtx(ctx)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not watch: %s", err))
+		return 1
+	}
+	defer stream.Close()
+
+	// the handlers run on their own goroutine, so a slow script does not
+	// keep the stream from being read, and the stage from dropping us.
+	var handleCh chan *share.MemberEvent
+	if len(handlers) > 0 {
+		c.Ui = &cli.ConcurrentUi{Ui: c.Ui}
+		handleCh = make(chan *share.MemberEvent, handlerQueue)
+		defer close(handleCh)
+		go c.runHandlers(handlers, handleCh, rpcAddr)
+	}
+
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("member stream closed: %s", err))
+			return 1
+		}
+
+		if format == "json" {
+			out, _ := json.Marshal(event)
+			c.Ui.Output(string(out))
+		} else {
+			c.Ui.Output(fmt.Sprintf("%s\t%s", event.Type, memberLine(&event.Member)))
+		}
+
+		if handleCh == nil {
+			continue
+		}
+		select {
+		case handleCh <- event:
+		default:
+			c.Ui.Output(fmt.Sprintf("event handlers are behind, %s event of %s not handled", event.Type, event.Member.Addr))
+		}
+	}
+}
+
+// handlerQueue is how many events wait for the event handlers.
+const handlerQueue = 64
+
+// runHandlers runs the handlers of every event in turn, until handleCh is
+// closed.
+func (c *MembersCommand) runHandlers(handlers []eventHandler, handleCh <-chan *share.MemberEvent, stageAddr string) {
+	for event := range handleCh {
+		for _, h := range handlers {
+			if h.wants(event.Type) {
+				c.runHandler(h.script, event, stageAddr)
+			}
+		}
+	}
+}
+
+func memberLine(m *share.Member) string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s", m.Name, m.Addr, m.Status, joinTags(m.Tags))
+}
+
+func (c *MembersCommand) runHandler(script string, event *share.MemberEvent, stageAddr string) {
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = append(os.Environ(),
+		"WONDER_EVENT="+event.Type,
+		"WONDER_STAGE_ADDR="+stageAddr,
+	)
+	cmd.Stdin = strings.NewReader(memberLine(&event.Member) + "\n")
+
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		c.Ui.Output(strings.TrimSuffix(string(out), "\n"))
+	}
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("event handler %q failed: %s", script, err))
+	}
+}
+
+type eventHandler struct {
+	// no types means every type.
+	types  []string
+	script string
+}
+
+func (h *eventHandler) wants(eventType string) bool {
+	if len(h.types) == 0 {
+		return true
+	}
+	for _, t := range h.types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// eventHandlersFlag collects repeated -event-handler [type,...=]script flags.
+type eventHandlersFlag []eventHandler
+
+func (f *eventHandlersFlag) String() string {
+	var specs []string
+	for _, h := range *f {
+		if len(h.types) == 0 {
+			specs = append(specs, h.script)
+		} else {
+			specs = append(specs, strings.Join(h.types, ",")+"="+h.script)
+		}
+	}
+	return strings.Join(specs, " ")
+}
+
+func (f *eventHandlersFlag) Set(value string) error {
+	h := eventHandler{script: value}
+
+	// the script itself may have a '=', only known types make a filter.
+	if kv := strings.SplitN(value, "=", 2); len(kv) == 2 {
+		types := strings.Split(kv[0], ",")
+		if knownMemberEvents(types) {
+			h.types = types
+			h.script = kv[1]
+		}
+	}
+	if h.script == "" {
+		return fmt.Errorf("empty event handler: %s", value)
+	}
+
+	*f = append(*f, h)
+	return nil
+}
+
+func knownMemberEvents(types []string) bool {
+	for _, t := range types {
+		switch t {
+		case share.MemberEventJoin, share.MemberEventLeave,
+			share.MemberEventFailed, share.MemberEventUpdate:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (c *MembersCommand) Synopsis() string {
+	return "list or watch the servers of the stage."
+}
@@ -0,0 +1,52 @@
+package command
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// watched counts the membership events members --watch printed.
+func watched(ui *cli.MockUi) int {
+	out := ui.OutputWriter.String()
+	return strings.Count(out, share.MemberEventJoin+"\t") + strings.Count(out, share.MemberEventLeave+"\t")
+}
+
+func TestSlowHandlerDoesNotHoldTheWatch(t *testing.T) {
+	cluster := start(t)
+	ui := cli.NewMockUi()
+	c := MembersCommand{Ui: ui}
+
+	go c.Run([]string{"-rpc-addr", cluster.StageAddr, "-watch", "-event-handler", "sleep 3"})
+
+	// the server leaves and comes back until the watch is there.
+	rejoin := func() {
+		cluster.Stage().ServerLeave(cluster.ServerAddr)
+		if err := cluster.Report(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for watched(ui) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("members --watch printed no event")
+		}
+		rejoin()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the handler of the first event is still asleep.
+	n := watched(ui)
+	rejoin()
+	deadline = time.Now().Add(time.Second)
+	for watched(ui) < n+2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("members --watch printed %d events while a handler ran, want %d:\n%s", watched(ui), n+2, ui.OutputWriter.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,9 +1,11 @@
 package command
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
 	"strings"
 	"time"
 
@@ -24,6 +26,9 @@ Options:
 	--what choose from [tree, flower, grass]
 	--color color of this plant, hex
 	--number plant how many instances
+	--rpc-addr the server to talk to, ip:port
+	--land the land to plant in, by name, the stage tells where it is
+	--stage-addr the stage to ask for the land, ip:port
 `
 	return strings.TrimSpace(helpText)
 }
@@ -31,19 +36,33 @@ Options:
 func (c *PlantCommand) Run(args []string) int {
 	var what, color string
 	var number int
+	var rpcAddr string
+	var landName, stageAddr string
 
 	cmdFlags := flag.NewFlagSet("plant", flag.ContinueOnError)
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	cmdFlags.StringVar(&what, "what", "flower", "plant what ?")
 	cmdFlags.StringVar(&color, "color", "red", "what color is it?")
 	cmdFlags.IntVar(&number, "number", 1, "how many")
+	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "ip:port to connect")
+	cmdFlags.StringVar(&landName, "land", "", "name of the land to plant in")
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "ip:port of the stage")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
 	}
 
+	if landName != "" {
+		placement, err := locateLand(stageAddr, landName)
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("can not locate land %s: %s", landName, err))
+			return 1
+		}
+		rpcAddr = placement.ServerAddr
+	}
+
 	config := client.Config{
-		Addr:    "127.0.0.1:9898",
+		Addr:    rpcAddr,
 		Timeout: 20 * time.Second,
 	}
 	cl, err := client.ClientFromConfig(&config)
@@ -52,16 +71,21 @@ func (c *PlantCommand) Run(args []string) int {
 		return 1
 	}
 
-	respCh := make(chan string)
-	if err := cl.Plant(what, color, number, respCh); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	request := share.PlantRequest{
+		Land:   landName,
+		What:   share.PlantType(what),
+		Color:  color,
+		Number: number,
+	}
+	resp, err := cl.PlantCtx(ctx, &request)
+	if err != nil {
 		c.Ui.Output(fmt.Sprintf("can not plant: %s", err))
 		return 1
 	}
-
-	select {
-	case r := <-respCh:
-		c.Ui.Output(fmt.Sprintf("get plant response: %v\n", r))
-	}
+	c.Ui.Output(fmt.Sprintf("get plant response: succ: %d, fail: %d\n", resp.Succ, resp.Fail))
 
 	return 0
 
@@ -0,0 +1,364 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/cmd/wonder/command/render"
+	"github.com/nickelchen/wonder/share"
+)
+
+type PlayCommand struct {
+	Ui cli.Ui
+}
+
+func (c *PlayCommand) Help() string {
+	helpText := `
+Usage: wonder play [options]
+
+	Steer a character of the land yourself, the land leaves it alone
+	meanwhile. move with the arrow keys or hjkl, n and p switch to the
+	next or previous character, r gives it back to the land and t takes it
+	again, esc quits. it walks over the edge into the next land, and down
+	portals, like anybody else, and is given back then.
+	HJKL pan the camera, f follows the character again, i and e show the
+	inspector and the events, like in wonder info.
+
+Options:
+	--as the character to steer, like Alice or Rabbit
+	--rpc-addr the server to talk to, ip:port
+	--land the land to play in, by name, the stage tells where it is
+	--stage-addr the stage to ask for the land, ip:port
+	--theme what the land looks like, a builtin theme of classic,
+	        okabe-ito, safe with colour blindness, and mono, or a JSON file
+	--colors the colours of the terminal, 16, 256 or truecolor
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *PlayCommand) Run(args []string) int {
+	var as string
+	var rpcAddr string
+	var landName, stageAddr string
+	var themeName, colors string
+
+	cmdFlags := flag.NewFlagSet("play", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&as, "as", "Alice", "name of the character to steer")
+	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "ip:port to connect")
+	cmdFlags.StringVar(&landName, "land", "", "name of the land to play in")
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "ip:port of the stage")
+	cmdFlags.StringVar(&themeName, "theme", render.DefaultTheme, "builtin theme or theme file")
+	cmdFlags.StringVar(&colors, "colors", render.Colors256, "16, 256 or truecolor")
+
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	theme, err := loadTheme(themeName, colors)
+	if err != nil {
+		c.Ui.Output(err.Error())
+		return 1
+	}
+
+	config := client.Config{
+		Addr:      rpcAddr,
+		Timeout:   20 * time.Second,
+		Reconnect: true,
+	}
+
+	stageWidth, stageHeight := gCol, gRow
+	if landName != "" {
+		placement, err := locateLand(stageAddr, landName)
+		if err != nil {
+			c.Ui.Output(fmt.Sprintf("can not locate land %s: %s", landName, err))
+			return 1
+		}
+		if placement.Width > 0 {
+			stageWidth, stageHeight = placement.Width, placement.Height
+		}
+		config.Resolve = func() ([]string, error) {
+			placement, err := locateLand(stageAddr, landName)
+			if err != nil {
+				return nil, err
+			}
+			return []string{placement.ServerAddr}, nil
+		}
+	}
+
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not get client: %s", err))
+		return 1
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	mirror, err := cl.MirrorCtx(ctx, &share.SyncRequest{Land: landName})
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not sync: %s", err))
+		return 1
+	}
+	defer mirror.Close()
+
+	events, err := cl.SubscribeCtx(ctx, &share.SubscribeRequest{Land: landName})
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not subscribe: %s", err))
+		return 1
+	}
+	defer events.Close()
+
+	rend := render.TermRender{Follow: as, Land: landName, Theme: theme, Colors: colors}
+	p := newPlayer(cl, mirror, landName, as, config.Timeout)
+	if err := p.possess(as); err != nil {
+		c.Ui.Output(fmt.Sprintf("can not possess %s: %s", as, err))
+		return 1
+	}
+	p.steering = true
+	p.log = rend.Log
+	p.follow = rend.SetFollow
+	go p.run()
+
+	rend.Control = p
+	rend.Stage(mirror, 2*stageWidth, stageHeight, c.Ui.(*cli.BasicUi).Writer)
+
+	go logEvents(events, &rend)
+
+	go func() {
+		for range mirror.Changes() {
+			rend.Render()
+		}
+	}()
+
+	rend.Loop()
+
+	if err := p.stop(); err != nil {
+		c.Ui.Output(fmt.Sprintf("can not release: %s", err))
+	}
+	return 0
+}
+
+// player is the render.Controller of wonder play, it steers one character of
+// the land at a time. the keys come from the Loop of the render, the calls
+// they make wait in callCh for run, so a slow server does not hold up the
+// screen.
+type player struct {
+	client  *client.RPCClient
+	mirror  *client.Mirror
+	land    string
+	timeout time.Duration
+	log     func(string)
+	follow  func(string)
+
+	// lock guards name and steering. steering is off once the character
+	// is given back, lost or gone to another land, until t takes it again.
+	lock     sync.Mutex
+	name     string
+	steering bool
+
+	callCh chan func()
+	doneCh chan struct{}
+}
+
+// playCalls is how many calls may wait for a slow server, keys after that
+// are dropped.
+const playCalls = 16
+
+func newPlayer(cl *client.RPCClient, mirror *client.Mirror, land string, name string, timeout time.Duration) *player {
+	p := player{
+		client:  cl,
+		mirror:  mirror,
+		land:    land,
+		name:    name,
+		timeout: timeout,
+		log:     func(string) {},
+		follow:  func(string) {},
+		callCh:  make(chan func(), playCalls),
+		doneCh:  make(chan struct{}),
+	}
+	return &p
+}
+
+// run makes the calls of the keys one by one, until stop.
+func (p *player) run() {
+	for call := range p.callCh {
+		call()
+	}
+	close(p.doneCh)
+}
+
+// stop waits for the calls already made, and gives the character back.
+func (p *player) stop() error {
+	close(p.callCh)
+	<-p.doneCh
+
+	p.lock.Lock()
+	name, steering := p.name, p.steering
+	p.steering = false
+	p.lock.Unlock()
+
+	if !steering {
+		return nil
+	}
+	return p.release(name)
+}
+
+func (p *player) do(call func()) {
+	select {
+	case p.callCh <- call:
+	default:
+		p.log("the server is slow, key dropped")
+	}
+}
+
+func (p *player) possess(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.client.PossessCtx(ctx, &share.PossessRequest{Land: p.land, Name: name})
+	return err
+}
+
+func (p *player) release(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	_, err := p.client.ReleaseCtx(ctx, &share.ReleaseRequest{Land: p.land, Name: name})
+	return err
+}
+
+// letGo stops steering name, unless another character is steered by now.
+func (p *player) letGo(name string, why string) {
+	p.lock.Lock()
+	if p.name == name {
+		p.steering = false
+	}
+	p.lock.Unlock()
+
+	p.log(why)
+}
+
+func (p *player) Move(dir share.MoveDirection) {
+	p.lock.Lock()
+	name, steering := p.name, p.steering
+	p.lock.Unlock()
+
+	if !steering {
+		p.log(fmt.Sprintf("%s is not steered, t takes it", name))
+		return
+	}
+	p.do(func() { p.move(name, dir) })
+}
+
+func (p *player) move(name string, dir share.MoveDirection) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	request := share.MoveRequest{Land: p.land, Name: name, Direction: dir}
+	resp, err := p.client.MoveCtx(ctx, &request)
+	switch {
+	case err == client.ErrNotPossessed:
+		// lost with the connection.
+		p.letGo(name, fmt.Sprintf("%s was given back to the land, t takes it again", name))
+	case err != nil:
+		p.log(fmt.Sprintf("can not move %s: %s", name, err))
+	case resp.To != "":
+		p.letGo(name, fmt.Sprintf("%s went to %s", name, resp.To))
+	}
+}
+
+func (p *player) Switch(step int) {
+	var names []string
+	p.mirror.View(func(board *share.GameBoard) {
+		for _, h := range board.Humans {
+			names = append(names, h.Name)
+		}
+		for _, a := range board.Animals {
+			names = append(names, a.Name)
+		}
+	})
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	p.lock.Lock()
+	old, steering := p.name, p.steering
+	p.lock.Unlock()
+
+	next := 0
+	for i, name := range names {
+		if name == old {
+			next = (i + step + len(names)) % len(names)
+		}
+	}
+	name := names[next]
+	if name == old {
+		return
+	}
+
+	// the keys steer it right away, the moves queue up behind the possess.
+	p.lock.Lock()
+	p.name, p.steering = name, true
+	p.lock.Unlock()
+	p.follow(name)
+
+	p.do(func() {
+		if steering {
+			if err := p.release(old); err != nil {
+				p.log(fmt.Sprintf("can not release %s: %s", old, err))
+			}
+		}
+		if err := p.possess(name); err != nil {
+			p.letGo(name, fmt.Sprintf("can not possess %s: %s", name, err))
+		}
+	})
+}
+
+func (p *player) Release() {
+	p.lock.Lock()
+	name, steering := p.name, p.steering
+	p.steering = false
+	p.lock.Unlock()
+
+	if !steering {
+		return
+	}
+	p.do(func() {
+		if err := p.release(name); err != nil {
+			p.log(fmt.Sprintf("can not release %s: %s", name, err))
+			return
+		}
+		p.log(fmt.Sprintf("%s is the land's again, t takes it back", name))
+	})
+}
+
+func (p *player) Take() {
+	p.lock.Lock()
+	name, steering := p.name, p.steering
+	p.steering = true
+	p.lock.Unlock()
+
+	if steering {
+		return
+	}
+	p.do(func() {
+		if err := p.possess(name); err != nil {
+			p.letGo(name, fmt.Sprintf("can not possess %s: %s", name, err))
+		}
+	})
+}
+
+func (c *PlayCommand) Synopsis() string {
+	return "steer a character of wonder land with the keys."
+}
@@ -0,0 +1,129 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+const waitTime = 5 * time.Second
+
+func alice(cluster *wondertest.Cluster) share.Point {
+	for _, s := range cluster.Land().Snapshot().Sprites {
+		if h, ok := s.(share.Human); ok && h.Name == "Alice" {
+			return h.P
+		}
+	}
+	return share.Point{X: -1, Y: -1}
+}
+
+// steer starts a player of Alice, the lines it logs come on the channel.
+func steer(t *testing.T, cluster *wondertest.Cluster) (*player, chan string) {
+	t.Helper()
+
+	cl, err := cluster.Client(&client.Config{Timeout: waitTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cl.Close)
+
+	p := newPlayer(cl, nil, "", "Alice", waitTime)
+	logCh := make(chan string, 16)
+	p.log = func(line string) { logCh <- line }
+	if err := p.possess("Alice"); err != nil {
+		t.Fatal(err)
+	}
+	p.steering = true
+	go p.run()
+	return p, logCh
+}
+
+func logged(t *testing.T, logCh chan string, what string) {
+	t.Helper()
+
+	for {
+		select {
+		case line := <-logCh:
+			if strings.Contains(line, what) {
+				return
+			}
+		case <-time.After(waitTime):
+			t.Fatalf("%q was not logged", what)
+		}
+	}
+}
+
+// inward is a step away from the edges of the land.
+func inward(p share.Point) share.MoveDirection {
+	if p.X < wondertest.DefaultCol/2 {
+		return share.MoveRight
+	}
+	return share.MoveLeft
+}
+
+func TestReleasedStaysReleased(t *testing.T) {
+	cluster := start(t)
+	p, logCh := steer(t, cluster)
+
+	p.Release()
+	logged(t, logCh, "the land's again")
+
+	at := alice(cluster)
+	p.Move(inward(at))
+	logged(t, logCh, "t takes it")
+	if err := p.stop(); err != nil {
+		t.Fatal(err)
+	}
+	if now := alice(cluster); now != at {
+		t.Fatalf("released Alice was moved from %v to %v", at, now)
+	}
+
+	// nobody steers her.
+	cl, err := cluster.Client(&client.Config{Timeout: waitTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if _, err := cl.PossessCtx(context.Background(), &share.PossessRequest{Name: "Alice"}); err != nil {
+		t.Fatalf("Alice was taken again: %s", err)
+	}
+}
+
+func TestTakeBack(t *testing.T) {
+	cluster := start(t)
+	p, logCh := steer(t, cluster)
+
+	p.Release()
+	logged(t, logCh, "the land's again")
+	p.Take()
+
+	at := alice(cluster)
+	p.Move(inward(at))
+	if err := p.stop(); err != nil {
+		t.Fatal(err)
+	}
+	if now := alice(cluster); now == at {
+		t.Fatal("Alice was not moved after t")
+	}
+}
+
+func TestKeysDoNotWaitForTheServer(t *testing.T) {
+	cluster := start(t)
+	p, _ := steer(t, cluster)
+	cluster.SlowStreams(200 * time.Millisecond)
+
+	at := alice(cluster)
+	began := time.Now()
+	for i := 0; i < 3; i++ {
+		p.Move(inward(at))
+	}
+	if d := time.Since(began); d > 100*time.Millisecond {
+		t.Fatalf("3 moves took %s on the key handler", d)
+	}
+	p.stop()
+}
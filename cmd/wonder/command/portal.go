@@ -0,0 +1,109 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+)
+
+type PortalCreateCommand struct {
+	Ui cli.Ui
+}
+
+func (c *PortalCreateCommand) Help() string {
+	helpText := `
+Usage: wonder portal create [options]
+
+	Dig a rabbit hole in a land. whoever steps on it comes out at the
+	other end, in the same land or another one.
+
+Options:
+	--from where the portal is, land:x,y
+	--to where it leads, land:x,y
+	--stage-addr the stage to register the portal with, ip:port
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *PortalCreateCommand) Run(args []string) int {
+	var from, to string
+	var stageAddr string
+
+	cmdFlags := flag.NewFlagSet("portal create", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&from, "from", "", "land:x,y of the portal")
+	cmdFlags.StringVar(&to, "to", "", "land:x,y the portal leads to")
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "ip:port of the stage")
+
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	request := share.CreatePortalRequest{}
+	var err error
+	if request.From, err = parsePortalEnd(from); err != nil {
+		c.Ui.Output(fmt.Sprintf("bad --from: %s", err))
+		return 1
+	}
+	if request.To, err = parsePortalEnd(to); err != nil {
+		c.Ui.Output(fmt.Sprintf("bad --to: %s", err))
+		return 1
+	}
+
+	config := client.Config{
+		Addr:    stageAddr,
+		Timeout: 20 * time.Second,
+	}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not get client: %s", err))
+		return 1
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	if _, err := cl.CreatePortalCtx(ctx, &request); err != nil {
+		c.Ui.Output(fmt.Sprintf("can not create portal: %s", err))
+		return 1
+	}
+	c.Ui.Output(fmt.Sprintf("portal from %s to %s", request.From, request.To))
+
+	return 0
+}
+
+// parsePortalEnd reads land:x,y.
+func parsePortalEnd(value string) (share.PortalEnd, error) {
+	var end share.PortalEnd
+
+	colon := strings.LastIndex(value, ":")
+	if colon <= 0 {
+		return end, fmt.Errorf("%q should be land:x,y", value)
+	}
+	end.Land = value[:colon]
+
+	xy := strings.SplitN(value[colon+1:], ",", 2)
+	if len(xy) != 2 {
+		return end, fmt.Errorf("%q should be land:x,y", value)
+	}
+	var errX, errY error
+	end.X, errX = strconv.Atoi(xy[0])
+	end.Y, errY = strconv.Atoi(xy[1])
+	if errX != nil || errY != nil {
+		return end, fmt.Errorf("%q should be land:x,y", value)
+	}
+	return end, nil
+}
+
+func (c *PortalCreateCommand) Synopsis() string {
+	return "dig a rabbit hole from one land to another."
+}
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/cli"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/cmd/wonder/command/render"
+	"github.com/nickelchen/wonder/share"
+)
+
+// defaultGIFFrameRate is how many frames a second wonder render draws, a
+// GIF can not show many more.
+const defaultGIFFrameRate = 10
+
+type RenderCommand struct {
+	Ui cli.Ui
+}
+
+func (c *RenderCommand) Help() string {
+	helpText := `
+Usage: wonder render [options]
+
+	Replay the journal kept by wonder info --journal into an animated GIF,
+	every tile a block of its colour, every sprite with a mark in the middle,
+	a frame for every moment of the land at the frame rate.
+
+Options:
+	--journal the journal to replay
+	--out the GIF to write, wonder.gif by default
+	--cell how many pixels square a tile is
+	--frame-rate how many frames a second of the land, and of the GIF
+	--theme what the land looks like, a builtin theme of classic,
+	        okabe-ito, safe with colour blindness, and mono, or a JSON file
+	--colors the colours to draw in, 16, 256 or truecolor
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *RenderCommand) Run(args []string) int {
+	var journal, out string
+	var cell, frameRate int
+	var themeName, colors string
+
+	cmdFlags := flag.NewFlagSet("render", flag.ContinueOnError)
+	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
+	cmdFlags.StringVar(&journal, "journal", "", "journal to replay")
+	cmdFlags.StringVar(&out, "out", "wonder.gif", "GIF to write")
+	cmdFlags.IntVar(&cell, "cell", render.DefaultCell, "pixels square of a tile")
+	cmdFlags.IntVar(&frameRate, "frame-rate", defaultGIFFrameRate, "frames a second")
+	cmdFlags.StringVar(&themeName, "theme", render.DefaultTheme, "builtin theme or theme file")
+	cmdFlags.StringVar(&colors, "colors", render.ColorsTruecolor, "16, 256 or truecolor")
+
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+	if journal == "" {
+		c.Ui.Output("no journal to replay, see wonder info --journal")
+		return 1
+	}
+	if cell <= 0 || frameRate <= 0 {
+		c.Ui.Output("the cell and the frame rate have to be above 0")
+		return 1
+	}
+
+	theme, err := loadTheme(themeName, colors)
+	if err != nil {
+		c.Ui.Output(err.Error())
+		return 1
+	}
+
+	in, err := os.Open(journal)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not open journal: %s", err))
+		return 1
+	}
+	defer in.Close()
+
+	anim := render.Animation{Theme: theme, Colors: colors, Cell: cell, FrameRate: frameRate}
+	if err := replay(client.NewJournalReader(in), int64(1000/frameRate), &anim); err != nil {
+		c.Ui.Output(fmt.Sprintf("can not replay journal: %s", err))
+		return 1
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		c.Ui.Output(fmt.Sprintf("can not create %s: %s", out, err))
+		return 1
+	}
+	if err := anim.Encode(f); err != nil {
+		f.Close()
+		c.Ui.Output(fmt.Sprintf("can not write %s: %s", out, err))
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		c.Ui.Output(fmt.Sprintf("can not write %s: %s", out, err))
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("%d frames written to %s", anim.Len(), out))
+	return 0
+}
+
+// replay applies the journal to a mirror, and draws a frame of it every step
+// milliseconds from the first snapshot on, and the last at the end.
+func replay(journal *client.JournalReader, step int64, anim *render.Animation) error {
+	mirror := client.NewMirror()
+	defer mirror.Close()
+
+	frame := func() {
+		mirror.View(func(board *share.GameBoard) {
+			anim.Frame(board)
+		})
+	}
+
+	next := int64(-1)
+	for {
+		entry, err := journal.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// the frames due before the entry show the land as it was.
+		for next >= 0 && next < entry.Time && mirror.Synced() {
+			frame()
+			next += step
+		}
+
+		if err := mirror.Apply(entry.Item()); err != nil {
+			return fmt.Errorf("seq %d: %s", entry.Seq, err)
+		}
+		if next < 0 && mirror.Synced() {
+			next = entry.Time
+		}
+	}
+
+	if mirror.Synced() {
+		frame()
+	}
+	return nil
+}
+
+func (c *RenderCommand) Synopsis() string {
+	return "Replay a journal of a land into an animated GIF."
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CastRender records the board as an asciinema v2 asciicast into a writer:
+// a header, and a frame of TextRender in colours on every change, drawn over
+// the one before, at the time it was drawn. asciinema play shows it back.
+type CastRender struct {
+	// Theme and Colors are as of TextRender.
+	Theme  *Theme
+	Colors string
+
+	text  TextRender
+	out   io.Writer
+	start time.Time
+	lock  sync.Mutex
+	err   error
+}
+
+// castHeader is the first line of an asciicast.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Env       map[string]string `json:"env"`
+}
+
+// Stage records board of stageWidth by stageHeight characters into out.
+func (u *CastRender) Stage(board Board, stageWidth, stageHeight int, out io.Writer) {
+	u.out = out
+	u.start = time.Now()
+
+	header := castHeader{
+		Version:   2,
+		Width:     stageWidth,
+		Height:    stageHeight,
+		Timestamp: u.start.Unix(),
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	}
+	u.write(header)
+
+	u.text = TextRender{Color: true, Theme: u.Theme, Colors: u.Colors}
+	u.text.Stage(board, stageWidth, stageHeight, castWriter{u})
+}
+
+// Loop records a frame now and on every Render after, until Close.
+func (u *CastRender) Loop() {
+	u.text.Loop()
+}
+
+// Render asks Loop for a new frame. it never blocks.
+func (u *CastRender) Render() {
+	u.text.Render()
+}
+
+// Close ends Loop.
+func (u *CastRender) Close() {
+	u.text.Close()
+}
+
+// Err tells why the recording stopped, nil if it did not.
+func (u *CastRender) Err() error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.err
+}
+
+// write puts v on a line of its own.
+func (u *CastRender) write(v interface{}) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.err != nil {
+		return u.err
+	}
+	bs, err := json.Marshal(v)
+	if err == nil {
+		_, err = u.out.Write(append(bs, '\n'))
+	}
+	u.err = err
+	return err
+}
+
+// castWriter turns every frame written into it into an output event.
+type castWriter struct {
+	u *CastRender
+}
+
+func (w castWriter) Write(frame []byte) (int, error) {
+	// the cursor goes home to draw over the last frame, and the player
+	// wants a carriage return with every line feed, as a terminal gives.
+	// the empty line that ends a text frame would scroll the screen.
+	lines := strings.TrimRight(string(frame), "\n")
+	data := "\x1b[H" + strings.Replace(lines, "\n", "\r\n", -1)
+
+	elapsed := time.Since(w.u.start).Seconds()
+	if err := w.u.write([]interface{}{elapsed, "o", data}); err != nil {
+		return 0, err
+	}
+	return len(frame), nil
+}
@@ -0,0 +1,193 @@
+package render
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// the colour modes a render draws in.
+const (
+	Colors16        = "16"
+	Colors256       = "256"
+	ColorsTruecolor = "truecolor"
+)
+
+func validColors(mode string) bool {
+	return mode == Colors16 || mode == Colors256 || mode == ColorsTruecolor
+}
+
+// Color is a colour of a theme: one of the 16 by name, like "red" or
+// "bright-blue", one of the 256 by number, like "196", or any by "#rrggbb".
+// empty is the colour of the terminal.
+type Color string
+
+var colorNames = []string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"bright-black", "bright-red", "bright-green", "bright-yellow",
+	"bright-blue", "bright-magenta", "bright-cyan", "bright-white",
+}
+
+// the 16 colours as xterm has them.
+var rgb16 = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// paint is a Color for a colour mode: the default of the terminal, an index
+// of the 16 or 256 colours, or rgb in truecolor.
+type paint struct {
+	def   bool
+	index int
+	rgb   [3]uint8
+	mode  string
+}
+
+// resolve finds the paint of c in mode, the closest one if mode has not got
+// c.
+func (c Color) resolve(mode string) (paint, error) {
+	p := paint{mode: mode}
+	s := strings.ToLower(strings.TrimSpace(string(c)))
+	if s == "" {
+		p.def = true
+		return p, nil
+	}
+
+	index := -1
+	var rgb [3]uint8
+	switch {
+	case strings.HasPrefix(s, "#"):
+		v, err := strconv.ParseUint(s[1:], 16, 32)
+		if err != nil || len(s) != 7 {
+			return p, fmt.Errorf("bad colour %q, want #rrggbb", string(c))
+		}
+		rgb = [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	case s[0] >= '0' && s[0] <= '9':
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 0 || v > 255 {
+			return p, fmt.Errorf("bad colour %q, want 0 to 255", string(c))
+		}
+		index = v
+		rgb = paletteRGB(v)
+	default:
+		for i, name := range colorNames {
+			if name == s {
+				index = i
+			}
+		}
+		if index < 0 {
+			return p, fmt.Errorf("unknown colour %q", string(c))
+		}
+		rgb = rgb16[index]
+	}
+
+	switch mode {
+	case ColorsTruecolor:
+		p.rgb = rgb
+	case Colors256:
+		if index < 0 {
+			index = nearest(rgb, 256)
+		}
+		p.index = index
+	default:
+		if index < 0 || index >= 16 {
+			index = nearest(rgb, 16)
+		}
+		p.index = index
+	}
+	return p, nil
+}
+
+func (p paint) String() string {
+	switch {
+	case p.def:
+		return "default"
+	case p.mode == ColorsTruecolor:
+		return fmt.Sprintf("#%02x%02x%02x", p.rgb[0], p.rgb[1], p.rgb[2])
+	}
+	return strconv.Itoa(p.index)
+}
+
+// attribute is the paint for termbox, in the output mode of its colour
+// mode. termbox counts the colours from 1, 0 is the default.
+func (p paint) attribute() termbox.Attribute {
+	switch {
+	case p.def:
+		return termbox.ColorDefault
+	case p.mode == ColorsTruecolor:
+		return termbox.RGBToAttribute(p.rgb[0], p.rgb[1], p.rgb[2])
+	}
+	return termbox.Attribute(p.index + 1)
+}
+
+// sgr is the ANSI parameter for the paint as the foreground, or as the
+// background with bg.
+func (p paint) sgr(bg bool) string {
+	base := 30
+	if bg {
+		base = 40
+	}
+	switch {
+	case p.def:
+		return strconv.Itoa(base + 9)
+	case p.mode == ColorsTruecolor:
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, p.rgb[0], p.rgb[1], p.rgb[2])
+	case p.mode == Colors256:
+		return fmt.Sprintf("%d;5;%d", base+8, p.index)
+	case p.index >= 8:
+		return strconv.Itoa(base + 60 + p.index - 8)
+	}
+	return strconv.Itoa(base + p.index)
+}
+
+// rgba is the paint as the colour of an image, foreground or background
+// with bg. the default of the terminal is taken as light grey on black.
+func (p paint) rgba(bg bool) color.RGBA {
+	rgb := p.rgb
+	switch {
+	case p.def && bg:
+		rgb = rgb16[0]
+	case p.def:
+		rgb = rgb16[7]
+	case p.mode != ColorsTruecolor:
+		rgb = paletteRGB(p.index)
+	}
+	return color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xff}
+}
+
+// paletteRGB is the rgb of one of the 256 colours: the 16, a 6x6x6 cube, and
+// 24 greys.
+func paletteRGB(i int) [3]uint8 {
+	switch {
+	case i < 16:
+		return rgb16[i]
+	case i < 232:
+		levels := [6]uint8{0, 95, 135, 175, 215, 255}
+		i -= 16
+		return [3]uint8{levels[i/36], levels[i/6%6], levels[i%6]}
+	}
+	grey := uint8(8 + (i-232)*10)
+	return [3]uint8{grey, grey, grey}
+}
+
+// nearest is the closest colour to rgb of the first n of the palette.
+func nearest(rgb [3]uint8, n int) int {
+	best, bestDist := 0, -1
+	for i := 0; i < n; i++ {
+		c := paletteRGB(i)
+		dist := 0
+		for k := range c {
+			d := int(c[k]) - int(rgb[k])
+			dist += d * d
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// DefaultCell is how many pixels square a tile of an Animation is.
+const DefaultCell = 8
+
+// Animation draws boards as the frames of an animated GIF, each tile a
+// square of Cell pixels on the background of its look. a sprite gets a mark
+// in the colour of its glyph in the middle, so it shows in any theme.
+type Animation struct {
+	// Theme is what the blocks look like, the DefaultTheme if nil, in the
+	// colour mode Colors, ColorsTruecolor if empty.
+	Theme  *Theme
+	Colors string
+
+	// Cell is the size of a tile in pixels, DefaultCell if 0. FrameRate is
+	// how many frames the GIF shows a second, DefaultFrameRate if 0.
+	Cell      int
+	FrameRate int
+
+	paint *painter
+	cols  int
+	rows  int
+	anim  gif.GIF
+}
+
+// ErrNoFrames is returned by Encode when no board was drawn.
+var ErrNoFrames = errors.New("no frames to encode")
+
+// Frame draws board as the next frame. the first frame sets the size of the
+// stage, later boards are cut to it.
+func (a *Animation) Frame(board *share.GameBoard) {
+	if a.paint == nil {
+		colors := a.Colors
+		if colors == "" {
+			colors = ColorsTruecolor
+		}
+		a.paint = newPainter(a.Theme, colors)
+		if a.Cell <= 0 {
+			a.Cell = DefaultCell
+		}
+		if a.FrameRate <= 0 {
+			a.FrameRate = DefaultFrameRate
+		}
+		a.rows = len(board.Tiles)
+		if a.rows > 0 {
+			a.cols = len(board.Tiles[0])
+		}
+	}
+
+	// a palette of the colours of this frame, a theme has few of them. past
+	// 256, the rest are drawn in the closest ones.
+	var palette color.Palette
+	indexes := map[color.RGBA]uint8{}
+	index := func(c color.RGBA) uint8 {
+		if i, ok := indexes[c]; ok {
+			return i
+		}
+		if len(palette) == 256 {
+			return uint8(palette.Index(c))
+		}
+		i := uint8(len(palette))
+		palette = append(palette, c)
+		indexes[c] = i
+		return i
+	}
+
+	type cell struct{ fg, bg uint8 }
+	cells := grid(board, a.cols, a.rows)
+	colored := make([][]cell, len(cells))
+	marked := make([][]bool, len(cells))
+	for y, row := range cells {
+		colored[y] = make([]cell, len(row))
+		marked[y] = make([]bool, len(row))
+		for x, b := range row {
+			l := a.paint.block(b)
+			fg, bg := l.fg.rgba(false), l.bg.rgba(true)
+			for _, attr := range l.attrs {
+				if attr == "reverse" {
+					fg, bg = bg, fg
+				}
+			}
+			colored[y][x] = cell{fg: index(fg), bg: index(bg)}
+			marked[y][x] = l.glyph[0] != ' ' && l.glyph[0] != 0
+		}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, a.cols*a.Cell, a.rows*a.Cell), palette)
+	fill := func(x0, y0, x1, y1 int, i uint8) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img.SetColorIndex(x, y, i)
+			}
+		}
+	}
+	margin := a.Cell / 4
+	for y, row := range colored {
+		for x, c := range row {
+			px, py := x*a.Cell, y*a.Cell
+			fill(px, py, px+a.Cell, py+a.Cell, c.bg)
+			if marked[y][x] {
+				fill(px+margin, py+margin, px+a.Cell-margin, py+a.Cell-margin, c.fg)
+			}
+		}
+	}
+
+	delay := 100 / a.FrameRate
+	if delay < 1 {
+		delay = 1
+	}
+	a.anim.Image = append(a.anim.Image, img)
+	a.anim.Delay = append(a.anim.Delay, delay)
+}
+
+// Len is how many frames were drawn.
+func (a *Animation) Len() int {
+	return len(a.anim.Image)
+}
+
+// Encode writes the frames drawn as a GIF that loops.
+func (a *Animation) Encode(w io.Writer) error {
+	if a.Len() == 0 {
+		return ErrNoFrames
+	}
+	return gif.EncodeAll(w, &a.anim)
+}
@@ -0,0 +1,96 @@
+package render
+
+import (
+	"strconv"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// blockSize is how many characters wide a tile is drawn, it looks square
+// that way.
+const blockSize = 2
+
+// block is a tile or a sprite to draw: its kind, a detail that a theme may
+// have a style for, and the glyph it has if the theme gives none. every
+// render draws these through a painter, so they all look the same.
+type block struct {
+	kind   string
+	detail string
+	glyph  [blockSize]rune
+}
+
+// letters is a block of the first letter of name, or of what, unnamed.
+func letters(kind, name string, what rune) block {
+	r := what
+	for _, c := range name {
+		r = c
+		break
+	}
+	return block{kind: kind, detail: name, glyph: [blockSize]rune{r, r}}
+}
+
+var (
+	treeBlock  = letters("tree", "", 'T')
+	grassBlock = letters("grass", "", 'G')
+	// a rabbit hole is (), apart from the letters of the other sprites.
+	portalBlock = block{kind: "portal", glyph: [blockSize]rune{'(', ')'}}
+)
+
+func flowerBlock(color string) block {
+	return block{kind: "flower", detail: color, glyph: [blockSize]rune{'F', 'F'}}
+}
+
+func tileBlock(t share.Tile) block {
+	if t.Gradient > 0 {
+		return block{kind: "mud", detail: strconv.Itoa(t.Gradient), glyph: [blockSize]rune{' ', ' '}}
+	}
+	return block{kind: "ground", glyph: [blockSize]rune{' ', ' '}}
+}
+
+// blocks calls f with the block of every tile and sprite of board, in the
+// order they are drawn: the sprites over the tiles, the humans and animals
+// over the rest.
+func blocks(board *share.GameBoard, f func(p share.Point, b block)) {
+	for y, row := range board.Tiles {
+		for x, t := range row {
+			f(share.Point{X: x, Y: y}, tileBlock(t))
+		}
+	}
+
+	for _, s := range board.Trees {
+		f(s.GetPoint(), treeBlock)
+	}
+	for _, s := range board.Flowers {
+		f(s.GetPoint(), flowerBlock(s.Color))
+	}
+	for _, s := range board.Grasses {
+		f(s.GetPoint(), grassBlock)
+	}
+	for _, s := range board.Portals {
+		f(s.GetPoint(), portalBlock)
+	}
+	for _, h := range board.Humans {
+		f(h.GetPoint(), letters("human", h.Name, 'H'))
+	}
+	for _, a := range board.Animals {
+		f(a.GetPoint(), letters("animal", a.Name, 'A'))
+	}
+}
+
+// grid lays the blocks of board out on a stage of cols by rows tiles, the
+// topmost of each tile. the tiles beyond the board are ground.
+func grid(board *share.GameBoard, cols, rows int) [][]block {
+	g := make([][]block, rows)
+	for y := range g {
+		g[y] = make([]block, cols)
+		for x := range g[y] {
+			g[y][x] = tileBlock(share.Tile{})
+		}
+	}
+	blocks(board, func(p share.Point, b block) {
+		if p.Y >= 0 && p.Y < rows && p.X >= 0 && p.X < cols {
+			g[p.Y][p.X] = b
+		}
+	})
+	return g
+}
@@ -0,0 +1,309 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nickelchen/wonder/share"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// logRows is how high the event log pane is, logSize how many lines it
+// keeps to scroll back through.
+const logRows = 5
+const logSize = 200
+
+// ticker is a board that knows its tick, like client.Mirror.
+type ticker interface {
+	Tick() uint64
+}
+
+// legend tells what the blocks on the stage are.
+var legend = []block{
+	letters("human", "Alice", 'H'),
+	letters("animal", "Rabbit", 'A'),
+	treeBlock,
+	flowerBlock(""),
+	grassBlock,
+	portalBlock,
+	tileBlock(share.Tile{Gradient: 1}),
+	tileBlock(share.Tile{}),
+}
+
+// eventLog is the lines of the event log pane. Log adds to it from any
+// goroutine.
+type eventLog struct {
+	lock  sync.Mutex
+	lines []string
+}
+
+// Log adds a line to the event log pane, and asks for a frame.
+func (u *TermRender) Log(line string) {
+	u.events.lock.Lock()
+	u.events.lines = append(u.events.lines, line)
+	if len(u.events.lines) > logSize {
+		u.events.lines = u.events.lines[len(u.events.lines)-logSize:]
+	}
+	u.events.lock.Unlock()
+
+	u.Render()
+}
+
+// lastLines are the n lines of the log up to scroll lines from the end.
+func (l *eventLog) lastLines(n, scroll int) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	end := len(l.lines) - scroll
+	if end < 0 {
+		end = 0
+	}
+	start := end - n
+	if start < 0 {
+		start = 0
+	}
+	return append([]string(nil), l.lines[start:end]...)
+}
+
+func (l *eventLog) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return len(l.lines)
+}
+
+// layout leaves a status bar at the top of the terminal, and the legend,
+// the inspector and the event log at the bottom, as far as they are shown.
+// the stage gets the rest.
+func (u *TermRender) layout() {
+	u.top, u.bottom = 1, 1
+	if u.inspecting {
+		u.bottom++
+	}
+	if u.showLog {
+		u.bottom += logRows
+	}
+
+	u.screenWidth = u.termWidth
+	u.screenHeight = u.termHeight - u.top - u.bottom
+	if u.screenHeight < 0 {
+		u.screenHeight = 0
+	}
+	u.offsetX = place(u.screenWidth, u.stageWidth+blockSize, u.offsetX)
+	u.offsetY = place(u.screenHeight, u.stageHeight+1, u.offsetY)
+}
+
+func (u *TermRender) renderHUD(board *share.GameBoard) {
+	u.clearRow(0)
+
+	land := u.Land
+	if land == "" {
+		land = "-"
+	}
+	var tick uint64
+	if t, ok := u.board.(ticker); ok {
+		tick = t.Tick()
+	}
+	status := fmt.Sprintf(" land %s  tick %d  %s  humans %d  animals %d  trees %d  flowers %d  grass %d  portals %d",
+		land, tick, share.TimeOfDay(tick),
+		len(board.Humans), len(board.Animals), len(board.Trees),
+		len(board.Flowers), len(board.Grasses), len(board.Portals))
+	if u.following {
+		status += fmt.Sprintf("  following %s", u.Follow)
+	}
+	hud := u.paint.look("hud")
+	u.text(0, 0, status+"  i inspect  e events  esc quit", hud)
+
+	x, y := 1, u.termHeight-1
+	u.clearRow(y)
+	for _, b := range legend {
+		l := u.paint.block(b)
+		x = u.text(x, y, string(l.glyph[:]), l)
+		x = u.text(x, y, " "+b.kind+"  ", hud)
+	}
+
+	if u.inspecting {
+		y := u.termHeight - 2
+		u.clearRow(y)
+		u.text(1, y, u.inspect(board), hud)
+		u.renderCursor()
+	}
+
+	if u.showLog {
+		y := u.termHeight - u.bottom
+		lines := u.events.lastLines(logRows, u.logScroll)
+		for i := 0; i < logRows; i++ {
+			u.clearRow(y + i)
+		}
+		for i, line := range lines {
+			u.text(1, y+logRows-len(lines)+i, line, hud)
+		}
+	}
+}
+
+// inspect tells the id, the type, the place, the colour and the state of
+// what is under the cursor, the topmost sprite or else the tile.
+func (u *TermRender) inspect(board *share.GameBoard) string {
+	p := u.cursor
+	describe := func(id string, b block, state string) string {
+		return fmt.Sprintf("%s  %s  at %d,%d  colour %s  %s", id, b.kind, p.X, p.Y, u.paint.block(b).bg, state)
+	}
+	character := func(name string) string {
+		if u.following && name == u.Follow {
+			return "followed"
+		}
+		return "-"
+	}
+
+	for _, a := range board.Animals {
+		if a.P == p {
+			return describe(a.Name, letters("animal", a.Name, 'A'), character(a.Name))
+		}
+	}
+	for _, h := range board.Humans {
+		if h.P == p {
+			return describe(h.Name, letters("human", h.Name, 'H'), character(h.Name))
+		}
+	}
+	for i, s := range board.Portals {
+		if s.P == p {
+			return describe(fmt.Sprintf("portal#%d", i), portalBlock,
+				fmt.Sprintf("to %s", share.PortalEnd{Land: s.To, X: s.ToX, Y: s.ToY}))
+		}
+	}
+	for i, s := range board.Grasses {
+		if s.P == p {
+			return describe(fmt.Sprintf("grass#%d", i), grassBlock, "-")
+		}
+	}
+	for i, s := range board.Flowers {
+		if s.P == p {
+			state := "-"
+			if s.Color != "" {
+				state = s.Color
+			}
+			return describe(fmt.Sprintf("flower#%d", i), flowerBlock(s.Color), state)
+		}
+	}
+	for i, s := range board.Trees {
+		if s.P == p {
+			return describe(fmt.Sprintf("tree#%d", i), treeBlock, "-")
+		}
+	}
+
+	if p.Y >= len(board.Tiles) || p.X >= len(board.Tiles[p.Y]) {
+		return fmt.Sprintf("nothing at %d,%d", p.X, p.Y)
+	}
+	if t := board.Tiles[p.Y][p.X]; t.Gradient > 0 {
+		return describe("-", tileBlock(t), fmt.Sprintf("gradient %d", t.Gradient))
+	}
+	return describe("-", tileBlock(share.Tile{}), "-")
+}
+
+// renderCursor puts brackets round the block under the cursor.
+func (u *TermRender) renderCursor() {
+	if !u.onScreen(u.cursor) {
+		return
+	}
+	x := u.offsetX + (u.cursor.X+1)*blockSize
+	y := u.top + u.offsetY + u.cursor.Y + 1
+
+	w, _ := termbox.Size()
+	cells := termbox.CellBuffer()
+	bg := cells[y*w+x].Bg
+	fg := u.paint.look("hud").fgAttribute() | termbox.AttrBold
+	termbox.SetCell(x, y, '[', fg, bg)
+	termbox.SetCell(x+blockSize-1, y, ']', fg, bg)
+}
+
+// toggleInspect shows the inspector, with the cursor on the followed sprite
+// or in the middle of the screen.
+func (u *TermRender) toggleInspect() {
+	u.inspecting = !u.inspecting
+	if !u.inspecting {
+		return
+	}
+
+	u.cursor = share.Point{
+		X: (u.screenWidth/2 - u.offsetX) / blockSize,
+		Y: u.screenHeight/2 - u.offsetY,
+	}
+	for _, h := range u.front.Humans {
+		if h.Name == u.Follow {
+			u.cursor = h.P
+		}
+	}
+	for _, a := range u.front.Animals {
+		if a.Name == u.Follow {
+			u.cursor = a.P
+		}
+	}
+	u.cursor.X = clamp(u.cursor.X, 0, u.stageWidth/blockSize-1)
+	u.cursor.Y = clamp(u.cursor.Y, 0, u.stageHeight-1)
+}
+
+// moveCursor moves the cursor one tile to dir, and the camera after it
+// when it goes off the screen.
+func (u *TermRender) moveCursor(dir share.MoveDirection) {
+	switch dir {
+	case share.MoveUp:
+		u.cursor.Y--
+	case share.MoveDown:
+		u.cursor.Y++
+	case share.MoveLeft:
+		u.cursor.X--
+	case share.MoveRight:
+		u.cursor.X++
+	}
+	u.cursor.X = clamp(u.cursor.X, 0, u.stageWidth/blockSize-1)
+	u.cursor.Y = clamp(u.cursor.Y, 0, u.stageHeight-1)
+
+	if !u.onScreen(u.cursor) {
+		u.following = false
+		u.offsetX = place(u.screenWidth, u.stageWidth+blockSize, u.screenWidth/2-(u.cursor.X+1)*blockSize)
+		u.offsetY = place(u.screenHeight, u.stageHeight+1, u.screenHeight/2-(u.cursor.Y+1))
+	}
+}
+
+// scrollLog scrolls the event log pane back by step lines, or on with a
+// negative step.
+func (u *TermRender) scrollLog(step int) {
+	u.logScroll = clamp(u.logScroll+step, 0, u.events.len()-logRows)
+}
+
+// onScreen tells if the tile at p is drawn whole on the stage part of the
+// screen.
+func (u *TermRender) onScreen(p share.Point) bool {
+	x := u.offsetX + (p.X+1)*blockSize
+	y := u.offsetY + p.Y + 1
+	return x >= 0 && x+blockSize <= u.screenWidth && y >= 0 && y < u.screenHeight
+}
+
+func (u *TermRender) clearRow(y int) {
+	hud := u.paint.look("hud")
+	for x := 0; x < u.termWidth; x++ {
+		termbox.SetCell(x, y, ' ', hud.fgAttribute(), hud.bgAttribute())
+	}
+}
+
+// text writes s in l from x, y as far as the screen goes, and tells where
+// it ended.
+func (u *TermRender) text(x, y int, s string, l look) int {
+	for _, r := range strings.Replace(s, "\n", " ", -1) {
+		termbox.SetCell(x, y, r, l.fgAttribute(), l.bgAttribute())
+		x++
+	}
+	return x
+}
+
+func clamp(v, min, max int) int {
+	if v > max {
+		v = max
+	}
+	if v < min {
+		v = min
+	}
+	return v
+}
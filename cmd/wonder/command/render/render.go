@@ -6,8 +6,27 @@ import (
 	"github.com/nickelchen/wonder/share"
 )
 
+// Board is what a render draws, a client.Mirror of one land or a
+// client.World of many.
+type Board interface {
+	View(func(*share.GameBoard))
+}
+
+// Controller steers a character with the keys, in wonder play. it is called
+// from Loop and should not take long.
+type Controller interface {
+	Move(dir share.MoveDirection)
+	// Switch steers the next character, or the previous one with -1.
+	Switch(step int)
+	// Release gives the character back to the land, Take takes it again.
+	Release()
+	Take()
+}
+
+// InfoRender draws a board until Loop returns. Render may be called from any
+// goroutine after Stage, to have the board drawn again.
 type InfoRender interface {
-	Stage(*share.GameBoard, int, int, io.Writer)
+	Stage(Board, int, int, io.Writer)
 	Render()
 	Loop()
 }
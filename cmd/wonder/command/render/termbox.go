@@ -6,8 +6,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+	"unicode"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/nickelchen/wonder/share"
@@ -17,63 +17,126 @@ import (
 
 var debug bool
 
-const textColor = termbox.ColorBlack
-const backgroundColor = termbox.ColorBlack
-
-const animationSpeed = 4 * time.Second
-
-var elemColor map[string]termbox.Attribute
-
-const blockSize = 2
-
-func readColorCode(key string) termbox.Attribute {
-	value, _ := strconv.Atoi(os.Getenv(key))
-	return termbox.Attribute(value)
-}
-
 func readDebug() bool {
 	return "true" == strings.ToLower(os.Getenv("DEBUG"))
 }
 
 func init() {
+	debug = readDebug()
+}
 
-	elemColor = map[string]termbox.Attribute{
-		"ground": readColorCode("COLOR_GROUND"),
-		"mud":    readColorCode("COLOR_MUD"),
-		"human":  readColorCode("COLOR_HUMAN"),
-		"animal": readColorCode("COLOR_ANIMAL"),
-		"tree":   readColorCode("COLOR_TREE"),
-		"grass":  readColorCode("COLOR_GRASS"),
-		"flower": readColorCode("COLOR_FLOWER"),
-	}
+// panStep is how many tiles the camera pans at a key.
+const panStep = 4
 
-	debug = readDebug()
+var keyDirs = map[rune]share.MoveDirection{
+	'k': share.MoveUp,
+	'j': share.MoveDown,
+	'h': share.MoveLeft,
+	'l': share.MoveRight,
 }
 
-type TermRender struct {
-	renderLock sync.Mutex
-	offsetX    int
-	offsetY    int
+// the arrows are taken as hjkl.
+var arrowKeys = map[termbox.Key]rune{
+	termbox.KeyArrowUp:    'k',
+	termbox.KeyArrowDown:  'j',
+	termbox.KeyArrowLeft:  'h',
+	termbox.KeyArrowRight: 'l',
+}
 
-	board  *share.GameBoard
+// DefaultFrameRate is how many frames a second TermRender draws at most.
+const DefaultFrameRate = 30
+
+type TermRender struct {
+	// Follow, if set, is the name of a sprite kept in the middle of the
+	// screen, as far as the stage goes, until the camera is panned.
+	Follow string
+
+	// Control, if set, gets the keys that steer a character.
+	Control Controller
+
+	// Land is the name of the land shown in the status bar.
+	Land string
+
+	// Theme is what the blocks look like, the DefaultTheme if nil, in the
+	// colour mode Colors, Colors256 if empty.
+	Theme  *Theme
+	Colors string
+
+	// FrameRate caps the frames drawn a second, DefaultFrameRate if 0.
+	FrameRate int
+
+	// everything below belongs to the goroutine in Loop, which draws every
+	// frame. the others only ask for one with Render.
+	dirtyCh chan struct{}
+	paint   *painter
+
+	// the board is copied to back while it is held still, and drawn from
+	// there once it is let go, so the events go on while a frame is drawn.
+	// back then becomes front, the frame on the screen.
+	front *share.GameBoard
+	back  *share.GameBoard
+
+	// the camera: the stage is drawn offsetX, offsetY from the top left of
+	// the part of the terminal left by the HUD, screenWidth by screenHeight
+	// from top, tile x, y at offsetX+(x+1)*blockSize, top+offsetY+y+1.
+	offsetX      int
+	offsetY      int
+	screenWidth  int
+	screenHeight int
+	following    bool
+
+	// the HUD takes top rows above the stage and bottom rows below it.
+	termWidth  int
+	termHeight int
+	top        int
+	bottom     int
+	inspecting bool
+	cursor     share.Point
+	showLog    bool
+	logScroll  int
+	events     eventLog
+
+	stageWidth  int
+	stageHeight int
+
+	board  Board
 	logger io.Writer
 }
 
-func (u *TermRender) Stage(board *share.GameBoard, stageWidth, stageHeight int, logger io.Writer) {
+// watcher is a board that only needs to keep what is in view, like
+// client.Mirror.
+type watcher interface {
+	Watch(view share.Region)
+}
+
+func (u *TermRender) Stage(board Board, stageWidth, stageHeight int, logger io.Writer) {
 	err := termbox.Init()
 	if err != nil {
 		panic(err)
 	}
-	termbox.SetOutputMode(termbox.Output256)
+	u.paint = newPainter(u.Theme, u.Colors)
+	switch u.paint.mode {
+	case Colors16:
+		termbox.SetOutputMode(termbox.OutputNormal)
+	case ColorsTruecolor:
+		termbox.SetOutputMode(termbox.OutputRGB)
+	default:
+		termbox.SetOutputMode(termbox.Output256)
+	}
 
 	u.board = board
 	u.logger = logger
+	u.dirtyCh = make(chan struct{}, 1)
+	u.front = share.NewGameBoard()
+	u.back = share.NewGameBoard()
+	u.stageWidth = stageWidth
+	u.stageHeight = stageHeight
+	u.following = u.Follow != ""
 
 	w, h := termbox.Size()
 	io.WriteString(u.logger, fmt.Sprintf("termbox.Size w: %d, h:%d\n", w, h))
 
-	u.offsetX = (w - stageWidth) / 2
-	u.offsetY = (h - stageHeight) / 2
+	u.resize(w, h)
 }
 
 func (u *TermRender) Loop() {
@@ -86,72 +149,181 @@ func (u *TermRender) Loop() {
 		}
 	}()
 
+	frameRate := u.FrameRate
+	if frameRate <= 0 {
+		frameRate = DefaultFrameRate
+	}
+	interval := time.Second / time.Duration(frameRate)
+
+	// a frame is drawn when something changed, but not sooner than interval
+	// after the last one. frameCh waits out the rest of it.
+	dirty := true
+	var last time.Time
+	var frameCh <-chan time.Time
+
 	for {
-		ev := <-eventQueue
-		if ev.Type == termbox.EventKey {
-			switch {
-			case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Key == termbox.KeyArrowLeft || ev.Ch == 'h':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Key == termbox.KeyArrowRight || ev.Ch == 'l':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Ch == 'n':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Ch == 'p':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Ch == 'r':
-				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Ch == 'd':
+		if dirty && frameCh == nil {
+			if wait := interval - time.Since(last); wait > 0 {
+				frameCh = time.After(wait)
+			} else {
+				u.draw()
+				last = time.Now()
+				dirty = false
+			}
+		}
+
+		select {
+		case ev := <-eventQueue:
+			switch ev.Type {
+			case termbox.EventKey:
+				if ev.Key == termbox.KeyEsc {
+					return
+				}
 				io.WriteString(u.logger, fmt.Sprintf("get keyevent: %v\n", ev))
-			case ev.Key == termbox.KeyEsc:
-				return
+				u.key(ev)
+			case termbox.EventResize:
+				io.WriteString(u.logger, fmt.Sprintf("termbox.Size w: %d, h:%d\n", ev.Width, ev.Height))
+				u.resize(ev.Width, ev.Height)
 			}
+			dirty = true
+		case <-u.dirtyCh:
+			dirty = true
+		case <-frameCh:
+			frameCh = nil
 		}
-		u.Render()
-		time.Sleep(animationSpeed)
 	}
 }
 
+// Render asks Loop for a new frame, on a change of the board. it never
+// blocks, many asks before the frame is drawn make one frame.
 func (u *TermRender) Render() {
-	termbox.Clear(backgroundColor, backgroundColor)
-
-	tiles := u.board.Tiles
-	for y := 1; y <= len(tiles); y++ {
-		tilesRow := tiles[y-1]
-		for x := 1; x <= len(tilesRow); x++ {
-			t := tilesRow[x-1]
-			if t.Gradient > 0 {
-				u.RenderMud(x, y)
-			} else {
-				u.RenderGround(x, y)
-			}
-		}
+	select {
+	case u.dirtyCh <- struct{}{}:
+	default:
+	}
+}
+
+// key handles a key other than Esc. the arrows and hjkl move the cursor of
+// the inspector, if shown, else steer the character in play, else pan the
+// camera, as HJKL always do.
+func (u *TermRender) key(ev termbox.Event) {
+	ch := ev.Ch
+	if a, ok := arrowKeys[ev.Key]; ok {
+		ch = a
 	}
 
-	for _, s := range u.board.Trees {
-		p := s.GetPoint()
-		u.RenderTree(p.X+1, p.Y+1)
+	switch ev.Key {
+	case termbox.KeyPgup:
+		u.scrollLog(logRows)
+		return
+	case termbox.KeyPgdn:
+		u.scrollLog(-logRows)
+		return
+	}
+
+	if dir, ok := keyDirs[ch]; ok {
+		if u.inspecting {
+			u.moveCursor(dir)
+		} else if u.Control != nil {
+			u.Control.Move(dir)
+		} else {
+			u.pan(dir)
+		}
+		return
 	}
-	for _, s := range u.board.Flowers {
-		p := s.GetPoint()
-		u.RenderFlower(p.X+1, p.Y+1)
+	if dir, ok := keyDirs[unicode.ToLower(ch)]; ok && unicode.IsUpper(ch) {
+		u.pan(dir)
+		return
+	}
+
+	switch ch {
+	case 'f':
+		u.toggleFollow()
+	case 'i':
+		u.toggleInspect()
+	case 'e':
+		u.showLog = !u.showLog
+		u.logScroll = 0
+	case 'n':
+		if u.Control != nil {
+			u.Control.Switch(1)
+		}
+	case 'p':
+		if u.Control != nil {
+			u.Control.Switch(-1)
+		}
+	case 'r':
+		if u.Control != nil {
+			u.Control.Release()
+		}
+	case 't':
+		if u.Control != nil {
+			u.Control.Take()
+		}
 	}
-	for _, s := range u.board.Grasses {
-		p := s.GetPoint()
-		u.RenderGrass(p.X+1, p.Y+1)
+}
+
+// SetFollow follows another sprite. like Controller, it is called from Loop.
+func (u *TermRender) SetFollow(name string) {
+	u.Follow = name
+	u.following = name != ""
+}
+
+func (u *TermRender) toggleFollow() {
+	u.following = !u.following && u.Follow != ""
+}
+
+// pan moves the camera panStep tiles to dir, and stops following.
+func (u *TermRender) pan(dir share.MoveDirection) {
+	dx, dy := 0, 0
+	switch dir {
+	case share.MoveUp:
+		dy = -panStep
+	case share.MoveDown:
+		dy = panStep
+	case share.MoveLeft:
+		dx = -panStep
+	case share.MoveRight:
+		dx = panStep
 	}
 
-	for _, h := range u.board.Humans {
-		p := h.GetPoint()
-		u.RenderHuman(p.X+1, p.Y+1, h.Name)
+	u.following = false
+	u.offsetX = place(u.screenWidth, u.stageWidth+blockSize, u.offsetX-dx*blockSize)
+	u.offsetY = place(u.screenHeight, u.stageHeight+1, u.offsetY-dy)
+}
+
+// resize keeps the camera where it was on a terminal of w by h.
+func (u *TermRender) resize(w, h int) {
+	u.termWidth, u.termHeight = w, h
+	u.layout()
+}
+
+// visible is the region of the stage on the screen.
+func (u *TermRender) visible() share.Region {
+	return share.Region{
+		X:      -u.offsetX/blockSize - 1,
+		Y:      -u.offsetY - 1,
+		Width:  u.screenWidth/blockSize + 2,
+		Height: u.screenHeight + 2,
 	}
+}
 
-	for _, a := range u.board.Animals {
-		p := a.GetPoint()
-		u.RenderAnimal(p.X+1, p.Y+1, a.Name)
+func (u *TermRender) draw() {
+	u.board.View(func(board *share.GameBoard) {
+		board.CopyTo(u.back)
+	})
+	u.front, u.back = u.back, u.front
+
+	background := u.paint.look("background").bgAttribute()
+	termbox.Clear(background, background)
+
+	u.layout()
+	u.renderBoard(u.front)
+	u.renderHUD(u.front)
+
+	// the board is not held any more, the watcher may sync again.
+	if w, ok := u.board.(watcher); ok {
+		w.Watch(u.visible())
 	}
 
 	if debug {
@@ -169,65 +341,73 @@ func (u *TermRender) Render() {
 
 }
 
-func (u *TermRender) Render256(i, x, y, z int) {
-	row := i % 16
-	col := i / 16
+func (u *TermRender) renderBoard(board *share.GameBoard) {
+	if u.following {
+		u.follow(board)
+	}
+	view := u.visible()
 
-	color := termbox.Attribute(z*100 + y*10 + x)
-	termbox.SetCell(col*3, row, []rune(strconv.Itoa(z))[0], textColor, color)
-	termbox.SetCell(col*3+1, row, []rune(strconv.Itoa(y))[0], textColor, color)
-	termbox.SetCell(col*3+2, row, []rune(strconv.Itoa(x))[0], textColor, color)
+	blocks(board, func(p share.Point, b block) {
+		if view.Contains(p) {
+			u.renderBlock(p.X+1, p.Y+1, b)
+		}
+	})
 }
 
-func (u *TermRender) RenderFlower(x, y int) {
-	color := elemColor["flower"]
-
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, 'F', textColor, color)
+// follow moves the camera so the followed sprite stays in the middle of the
+// screen, as far as the stage goes.
+func (u *TermRender) follow(board *share.GameBoard) {
+	var p *share.Point
+	for _, h := range board.Humans {
+		if h.Name == u.Follow {
+			p = &h.P
+		}
+	}
+	for _, a := range board.Animals {
+		if a.Name == u.Follow {
+			p = &a.P
+		}
+	}
+	if p == nil {
+		return
 	}
 
+	u.offsetX = place(u.screenWidth, u.stageWidth+blockSize, u.screenWidth/2-(p.X+1)*blockSize)
+	u.offsetY = place(u.screenHeight, u.stageHeight+1, u.screenHeight/2-(p.Y+1))
 }
-func (u *TermRender) RenderTree(x, y int) {
-	color := elemColor["tree"]
 
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, 'T', textColor, color)
+// place keeps offset from showing what is beyond either end of the stage,
+// which reaches to extent. a stage that fits the screen is in the middle.
+func place(screen, extent, offset int) int {
+	if extent <= screen {
+		return (screen - extent) / 2
 	}
-}
-func (u *TermRender) RenderGrass(x, y int) {
-	color := elemColor["grass"]
-
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, 'G', textColor, color)
+	if offset > 0 {
+		offset = 0
 	}
-}
-
-func (u *TermRender) RenderGround(x, y int) {
-	color := elemColor["ground"]
-
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, ' ', textColor, color)
+	if offset < screen-extent {
+		offset = screen - extent
 	}
+	return offset
 }
-func (u *TermRender) RenderMud(x, y int) {
-	color := elemColor["mud"]
 
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, ' ', textColor, color)
-	}
-}
-func (u *TermRender) RenderHuman(x, y int, name string) {
-	color := elemColor["human"]
+// Render256 shows the 256 colours, for DEBUG.
+func (u *TermRender) Render256(i, x, y, z int) {
+	const textColor = termbox.ColorBlack
 
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, []rune(name)[0], textColor, color)
-	}
+	row := i % 16
+	col := i / 16
+
+	color := termbox.Attribute(z*100 + y*10 + x)
+	termbox.SetCell(col*3, row, []rune(strconv.Itoa(z))[0], textColor, color)
+	termbox.SetCell(col*3+1, row, []rune(strconv.Itoa(y))[0], textColor, color)
+	termbox.SetCell(col*3+2, row, []rune(strconv.Itoa(x))[0], textColor, color)
 }
 
-func (u *TermRender) RenderAnimal(x, y int, name string) {
-	color := elemColor["animal"]
+func (u *TermRender) renderBlock(x, y int, b block) {
+	l := u.paint.block(b)
 
-	for k := 0; k < blockSize; k++ {
-		termbox.SetCell(u.offsetX+x*blockSize+k, u.offsetY+y, []rune(name)[0], textColor, color)
+	for k, r := range l.glyph {
+		termbox.SetCell(u.offsetX+x*blockSize+k, u.top+u.offsetY+y, r, l.fgAttribute(), l.bgAttribute())
 	}
 }
@@ -0,0 +1,129 @@
+package render
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// TextRender draws the board as text frames into a writer, without a
+// terminal, for a dump of the land or a pipe. a frame is a line of blocks
+// for every row of the stage, and an empty line after. with Color the
+// blocks are on their colours in ANSI escapes, else the ground is .. and the
+// mud ~~, so they tell apart.
+type TextRender struct {
+	// Color draws the frames with ANSI colours, of Theme in the colour mode
+	// Colors, like TermRender.
+	Color  bool
+	Theme  *Theme
+	Colors string
+
+	// Once draws a single frame in Loop, and returns.
+	Once bool
+
+	// the frames are drawn by the goroutine in Loop, like TermRender.
+	dirtyCh   chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	front     *share.GameBoard
+	paint     *painter
+
+	cols  int
+	rows  int
+	board Board
+	out   io.Writer
+}
+
+// Stage draws board of stageWidth by stageHeight characters, a tile is
+// blockSize of them, into out.
+func (u *TextRender) Stage(board Board, stageWidth, stageHeight int, out io.Writer) {
+	u.board = board
+	u.out = out
+	u.cols = stageWidth / blockSize
+	u.rows = stageHeight
+	u.dirtyCh = make(chan struct{}, 1)
+	u.doneCh = make(chan struct{})
+	u.front = share.NewGameBoard()
+	u.paint = newPainter(u.Theme, u.Colors)
+}
+
+// Loop draws a frame now and on every Render after, until Close, or until
+// out fails. with Once it returns after the first.
+func (u *TextRender) Loop() {
+	u.draw()
+	if u.Once {
+		return
+	}
+
+	for {
+		select {
+		case <-u.dirtyCh:
+			u.draw()
+		case <-u.doneCh:
+			return
+		}
+	}
+}
+
+// Render asks Loop for a new frame. it never blocks.
+func (u *TextRender) Render() {
+	select {
+	case u.dirtyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Close ends Loop.
+func (u *TextRender) Close() {
+	u.closeOnce.Do(func() { close(u.doneCh) })
+}
+
+func (u *TextRender) draw() {
+	u.board.View(func(board *share.GameBoard) {
+		board.CopyTo(u.front)
+	})
+
+	if _, err := u.out.Write(u.frame(u.front)); err != nil {
+		u.Close()
+	}
+}
+
+// frame draws the blocks of board on the stage.
+func (u *TextRender) frame(board *share.GameBoard) []byte {
+	var buf bytes.Buffer
+	for _, row := range grid(board, u.cols, u.rows) {
+		last := ""
+		for _, b := range row {
+			l := u.paint.block(b)
+			if !u.Color {
+				buf.WriteString(string(plain(b.kind, l.glyph)))
+				continue
+			}
+			// one escape for a run of blocks of the same look.
+			if esc := l.ansi(); esc != last {
+				buf.WriteString(esc)
+				last = esc
+			}
+			buf.WriteString(string(l.glyph[:]))
+		}
+		if u.Color {
+			buf.WriteString("\x1b[0m")
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// plain is the glyph of a kind without colours, the blank tiles get one.
+func plain(kind string, glyph [blockSize]rune) []rune {
+	switch {
+	case glyph[0] != ' ':
+		return glyph[:]
+	case kind == "mud":
+		return []rune("~~")
+	}
+	return []rune("..")
+}
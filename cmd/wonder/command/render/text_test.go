@@ -0,0 +1,82 @@
+package render
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the text frames")
+
+// fixedBoard is a board that never changes.
+type fixedBoard share.GameBoard
+
+func (b *fixedBoard) View(f func(*share.GameBoard)) {
+	f((*share.GameBoard)(b))
+}
+
+// testBoard is 6 by 4 tiles of ground and mud, with one of every sprite.
+func testBoard() *fixedBoard {
+	board := share.GameBoard{}
+	for y := 0; y < 4; y++ {
+		var row []share.Tile
+		for x := 0; x < 6; x++ {
+			row = append(row, share.Tile{Gradient: (x + y) % 2})
+		}
+		board.Tiles = append(board.Tiles, row)
+	}
+
+	at := func(x, y int) share.SpriteBase {
+		return share.SpriteBase{P: share.Point{X: x, Y: y}, Visible: true}
+	}
+	board.Trees = []share.Tree{{SpriteBase: at(0, 0)}}
+	board.Grasses = []share.Grass{{SpriteBase: at(1, 0)}}
+	board.Flowers = []share.Flower{{SpriteBase: at(2, 1), Color: "red"}, {SpriteBase: at(3, 1), Color: "blue"}}
+	board.Humans = []share.Human{{SpriteBase: at(4, 2), Name: "Alice"}}
+	board.Animals = []share.Animal{{SpriteBase: at(5, 2), Name: "Rabbit"}}
+	board.Portals = []share.Portal{{SpriteBase: at(0, 3), To: "north"}}
+	return (*fixedBoard)(&board)
+}
+
+func TestTextFrames(t *testing.T) {
+	theme, err := LoadTheme(DefaultTheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []struct {
+		name   string
+		color  bool
+		colors string
+	}{
+		{"plain", false, ""},
+		{Colors16, true, Colors16},
+		{Colors256, true, Colors256},
+		{ColorsTruecolor, true, ColorsTruecolor},
+	} {
+		t.Run(mode.name, func(t *testing.T) {
+			var out bytes.Buffer
+			u := TextRender{Color: mode.color, Theme: theme, Colors: mode.colors, Once: true}
+			u.Stage(testBoard(), 6*blockSize, 4, &out)
+			u.Loop()
+
+			golden := filepath.Join("testdata", "text_"+mode.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s, go test -update writes it", err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("frame is not %s:\n%q\nwant:\n%q", golden, out.Bytes(), want)
+			}
+		})
+	}
+}
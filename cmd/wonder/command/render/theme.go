@@ -0,0 +1,335 @@
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	termbox "github.com/nsf/termbox-go"
+)
+
+// DefaultTheme is the theme of a render that was given none.
+const DefaultTheme = "classic"
+
+// Style is how one kind of block is drawn. Glyph is one rune, drawn twice,
+// or blockSize runes; the letter of the name, or the usual one, if empty.
+// Attrs are any of bold, underline and reverse.
+type Style struct {
+	Glyph string   `json:"glyph,omitempty"`
+	Fg    Color    `json:"fg,omitempty"`
+	Bg    Color    `json:"bg,omitempty"`
+	Attrs []string `json:"attrs,omitempty"`
+}
+
+// Theme tells the style of every kind of block, and of the HUD.
+//
+// the keys of Styles are the kinds: ground and mud, the tiles, tree, flower,
+// grass, portal, human and animal, and hud and background for the rest of
+// the screen. a key may go on with a dot to be more particular: mud.1 is
+// mud of gradient 1, flower.red a flower of that colour, human.Alice is
+// Alice. those only need what differs from the kind. a flower of a colour
+// the theme does not know is drawn in it, if it is a Color.
+//
+// Variants are the styles of a colour mode, 16, 256 or truecolor, over
+// Styles, for colours that come out poorly there. a colour a mode has not
+// got is drawn in the closest one it has.
+type Theme struct {
+	Name     string                      `json:"name"`
+	Styles   map[string]Style            `json:"styles"`
+	Variants map[string]map[string]Style `json:"variants,omitempty"`
+}
+
+var attrNames = map[string]termbox.Attribute{
+	"bold":      termbox.AttrBold,
+	"underline": termbox.AttrUnderline,
+	"reverse":   termbox.AttrReverse,
+}
+
+var attrSGR = map[string]string{
+	"bold":      "1",
+	"underline": "4",
+	"reverse":   "7",
+}
+
+var builtinThemes = map[string]*Theme{}
+
+// the themes that come with wonder. classic has the colours of old, okabe-ito
+// keeps to a palette that reads with any colour blindness, and tells the
+// tiles apart by glyph too. mono has no colours at all.
+var builtinThemeFiles = []string{`{
+	"name": "classic",
+	"styles": {
+		"ground": {"fg": "black", "bg": "195"},
+		"mud":    {"fg": "black", "bg": "187"},
+		"tree":   {"fg": "black", "bg": "10"},
+		"flower": {"fg": "black", "bg": "171"},
+		"grass":  {"fg": "black", "bg": "120"},
+		"portal": {"fg": "black", "bg": "53"},
+		"human":  {"fg": "black", "bg": "196"},
+		"animal": {"fg": "black"},
+		"hud":    {"fg": "white", "bg": "black"},
+		"background": {"bg": "black"}
+	},
+	"variants": {
+		"16": {
+			"ground": {"bg": "bright-cyan"},
+			"mud":    {"bg": "white"},
+			"tree":   {"bg": "green"},
+			"flower": {"bg": "bright-magenta"},
+			"grass":  {"bg": "bright-green"},
+			"portal": {"fg": "white", "bg": "magenta"},
+			"human":  {"bg": "bright-red"}
+		}
+	}
+}`, `{
+	"name": "okabe-ito",
+	"styles": {
+		"ground": {"fg": "black", "bg": "#ffffff"},
+		"mud":    {"glyph": "~", "fg": "black", "bg": "#bbbbbb"},
+		"tree":   {"fg": "white", "bg": "#009e73"},
+		"flower": {"fg": "black", "bg": "#cc79a7"},
+		"grass":  {"fg": "black", "bg": "#56b4e9"},
+		"portal": {"fg": "white", "bg": "#0072b2"},
+		"human":  {"fg": "white", "bg": "#d55e00", "attrs": ["bold"]},
+		"animal": {"fg": "black", "bg": "#f0e442", "attrs": ["bold"]},
+		"hud":    {"fg": "#ffffff", "bg": "#000000"},
+		"background": {"bg": "#000000"}
+	},
+	"variants": {
+		"16": {
+			"ground": {"bg": "bright-white"},
+			"mud":    {"bg": "white"},
+			"tree":   {"bg": "green"},
+			"flower": {"bg": "magenta"},
+			"grass":  {"bg": "bright-cyan"},
+			"portal": {"bg": "blue"},
+			"human":  {"bg": "red"},
+			"animal": {"bg": "bright-yellow"}
+		}
+	}
+}`, `{
+	"name": "mono",
+	"styles": {
+		"ground": {"glyph": "."},
+		"mud":    {"glyph": "~"},
+		"human":  {"attrs": ["reverse", "bold"]},
+		"animal": {"attrs": ["reverse"]},
+		"portal": {"attrs": ["underline"]}
+	}
+}`}
+
+func init() {
+	for _, file := range builtinThemeFiles {
+		theme, err := parseTheme([]byte(file))
+		if err != nil {
+			panic(fmt.Sprintf("bad builtin theme: %s", err))
+		}
+		builtinThemes[theme.Name] = theme
+	}
+}
+
+// ThemeNames are the names of the builtin themes.
+func ThemeNames() []string {
+	var names []string
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTheme finds a builtin theme by name, or else reads a theme file in
+// JSON.
+func LoadTheme(name string) (*Theme, error) {
+	if theme, ok := builtinThemes[name]; ok {
+		return theme, nil
+	}
+
+	bs, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("no builtin theme %s, nor a file: %s", name, err)
+	}
+	theme, err := parseTheme(bs)
+	if err != nil {
+		return nil, fmt.Errorf("can not read theme %s: %s", name, err)
+	}
+	if theme.Name == "" {
+		theme.Name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	}
+	return theme, nil
+}
+
+func parseTheme(bs []byte) (*Theme, error) {
+	var theme Theme
+	if err := json.Unmarshal(bs, &theme); err != nil {
+		return nil, err
+	}
+	if err := theme.check(); err != nil {
+		return nil, err
+	}
+	return &theme, nil
+}
+
+// check tells of the first style that can not be drawn.
+func (t *Theme) check() error {
+	checkStyles := func(styles map[string]Style) error {
+		for key, style := range styles {
+			if n := utf8.RuneCountInString(style.Glyph); n > blockSize {
+				return fmt.Errorf("%s: glyph %q is longer than %d", key, style.Glyph, blockSize)
+			}
+			for _, c := range []Color{style.Fg, style.Bg} {
+				if _, err := c.resolve(Colors256); err != nil {
+					return fmt.Errorf("%s: %s", key, err)
+				}
+			}
+			for _, attr := range style.Attrs {
+				if _, ok := attrNames[attr]; !ok {
+					return fmt.Errorf("%s: unknown attr %q", key, attr)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := checkStyles(t.Styles); err != nil {
+		return err
+	}
+	for mode, styles := range t.Variants {
+		if !validColors(mode) {
+			return fmt.Errorf("unknown colour mode %q", mode)
+		}
+		if err := checkStyles(styles); err != nil {
+			return fmt.Errorf("%s: %s", mode, err)
+		}
+	}
+	return nil
+}
+
+// has tells if the theme has a style for key.
+func (t *Theme) has(key string) bool {
+	if _, ok := t.Styles[key]; ok {
+		return true
+	}
+	for _, styles := range t.Variants {
+		if _, ok := styles[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// over puts the fields that o has over s.
+func (s Style) over(o Style) Style {
+	if o.Glyph != "" {
+		s.Glyph = o.Glyph
+	}
+	if o.Fg != "" {
+		s.Fg = o.Fg
+	}
+	if o.Bg != "" {
+		s.Bg = o.Bg
+	}
+	if o.Attrs != nil {
+		s.Attrs = o.Attrs
+	}
+	return s
+}
+
+// look is a style ready to draw in a colour mode.
+type look struct {
+	glyph [blockSize]rune
+	fg    paint
+	bg    paint
+	attrs []string
+}
+
+func (l look) fgAttribute() termbox.Attribute {
+	a := l.fg.attribute()
+	for _, attr := range l.attrs {
+		a |= attrNames[attr]
+	}
+	return a
+}
+
+func (l look) bgAttribute() termbox.Attribute {
+	return l.bg.attribute()
+}
+
+// ansi is the escape that starts drawing in the look.
+func (l look) ansi() string {
+	params := []string{"0"}
+	for _, attr := range l.attrs {
+		params = append(params, attrSGR[attr])
+	}
+	params = append(params, l.fg.sgr(false), l.bg.sgr(true))
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+// painter finds the looks of a theme in a colour mode. it belongs to the
+// goroutine that draws, like the rest of a render.
+type painter struct {
+	theme *Theme
+	mode  string
+	looks map[string]look
+}
+
+func newPainter(theme *Theme, mode string) *painter {
+	if theme == nil {
+		theme = builtinThemes[DefaultTheme]
+	}
+	if !validColors(mode) {
+		mode = Colors256
+	}
+	return &painter{theme: theme, mode: mode, looks: map[string]look{}}
+}
+
+// look is the style of keys, each over the ones before.
+func (p *painter) look(keys ...string) look {
+	cacheKey := strings.Join(keys, " ")
+	if l, ok := p.looks[cacheKey]; ok {
+		return l
+	}
+
+	var style Style
+	for _, key := range keys {
+		style = style.over(p.theme.Styles[key]).over(p.theme.Variants[p.mode][key])
+	}
+
+	l := look{attrs: style.Attrs}
+	// the theme was checked, a colour it has can be resolved.
+	l.fg, _ = style.Fg.resolve(p.mode)
+	l.bg, _ = style.Bg.resolve(p.mode)
+	runes := []rune(style.Glyph)
+	for k := range l.glyph {
+		if len(runes) > 0 {
+			l.glyph[k] = runes[k%len(runes)]
+		}
+	}
+
+	p.looks[cacheKey] = l
+	return l
+}
+
+// block is the look of b, with the glyph of b if the theme has none.
+func (p *painter) block(b block) look {
+	key := b.kind + "." + b.detail
+	l := p.look(b.kind)
+	if b.detail != "" {
+		l = p.look(b.kind, key)
+	}
+	if l.glyph[0] == 0 {
+		l.glyph = b.glyph
+	}
+
+	// a flower the theme has no style for is in its own colour.
+	if b.kind == "flower" && b.detail != "" && !p.theme.has(key) {
+		if bg, err := Color(b.detail).resolve(p.mode); err == nil {
+			l.bg = bg
+		}
+	}
+	return l
+}
@@ -6,9 +6,12 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/nickelchen/wonder/land"
+
 	log "github.com/sirupsen/logrus"
 
 	"github.com/mitchellh/cli"
@@ -20,14 +23,47 @@ type Command struct {
 
 var DefaultStageTimeout = 10 * time.Second
 var DefaultReportInterval = 2 * time.Second
+var DefaultSnapshotInterval = 10 * time.Second
+
+// DefaultProbeTimeout is how long a health check waits for each land, it
+// stays under the health timeout of the stage.
+var DefaultProbeTimeout = 500 * time.Millisecond
+
+// gracefulTimeout is how long a server has to leave on a signal. Leave saves
+// the lands for LeaveSaveTimeout of it, the ServerLeave goes out after.
+var gracefulTimeout = 3 * time.Second
+var LeaveSaveTimeout = 500 * time.Millisecond
 
 type Config struct {
 	bindIP     string
 	ServerAddr string
 
-	StageAddr      string
+	// Name and Tags are reported to the stage, for people and for
+	// `wonder list -tag`.
+	Name string
+	Tags map[string]string
+
+	// StageAddrs are the stages of the cluster, the server reports to the
+	// first one it can reach and fails over to the others.
+	StageAddrs     []string
 	StageTimeout   time.Duration
 	ReportInterval time.Duration
+
+	// how often the lands hosted for the stage are saved to it.
+	SnapshotInterval time.Duration
+
+	// how many recent events are kept for resuming subscribers.
+	EventBufferSize int
+
+	// how many events a stream may fall behind before its policy applies.
+	EventQueueSize int
+
+	// how long a health check waits for a land before it reports it stuck.
+	ProbeTimeout time.Duration
+
+	// LandConfig is the land to spread, nil means land.DefaultConfig. its
+	// EventCh is set by the server.
+	LandConfig *land.Config
 }
 
 func (c *Command) readConfig(args []string) *Config {
@@ -36,17 +72,31 @@ func (c *Command) readConfig(args []string) *Config {
 	var stageAddr string
 	var stageTimeout int
 	var reportInterval int
+	var snapshotInterval time.Duration
+	var eventBufferSize int
+	var eventQueueSize int
 
 	var bindIP string
 	var debug bool
 
+	var name string
+	tags := make(tagsFlag)
+
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
-	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "which stage doest the server to report")
+	cmdFlags.StringVar(&stageAddr, "stage-addr", "127.0.0.1:9898", "which stages does the server report to, comma separated")
 	cmdFlags.IntVar(&stageTimeout, "stage-timeout", 0, "timeout when connect to stage")
 	cmdFlags.IntVar(&reportInterval, "stage-report-interval", 0, "time interval to report to stage")
+	cmdFlags.DurationVar(&snapshotInterval, "snapshot-interval", DefaultSnapshotInterval, "how often to save hosted lands to the stage")
+
+	cmdFlags.IntVar(&eventBufferSize, "event-buffer-size", DefaultEventBufferSize, "how many recent events to keep for resuming subscribers")
+	cmdFlags.IntVar(&eventQueueSize, "event-queue-size", DefaultEventQueueSize, "how many events a slow subscriber may fall behind")
 
 	cmdFlags.StringVar(&bindIP, "bind-ip", "127.0.0.1", "this server bind ip address")
 
+	hostname, _ := os.Hostname()
+	cmdFlags.StringVar(&name, "name", hostname, "name of this server")
+	cmdFlags.Var(tags, "tag", "key=value tag reported to the stage, may be repeated")
+
 	cmdFlags.BoolVar(&debug, "debug", true, "debug mode")
 
 	if err := cmdFlags.Parse(args); err != nil {
@@ -61,14 +111,41 @@ func (c *Command) readConfig(args []string) *Config {
 
 	config := Config{
 		bindIP:         bindIP,
-		StageAddr:      stageAddr,
+		Name:           name,
+		Tags:           tags,
+		StageAddrs:     strings.Split(stageAddr, ","),
 		StageTimeout:   time.Duration(stageTimeout) * time.Second,
 		ReportInterval: time.Duration(reportInterval) * time.Second,
+
+		SnapshotInterval: snapshotInterval,
+
+		EventBufferSize: eventBufferSize,
+		EventQueueSize:  eventQueueSize,
 	}
 
 	return &config
 }
 
+// tagsFlag collects repeated -tag key=value flags.
+type tagsFlag map[string]string
+
+func (t tagsFlag) String() string {
+	var pairs []string
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (t tagsFlag) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("tag should be key=value: %s", value)
+	}
+	t[kv[0]] = kv[1]
+	return nil
+}
+
 func (c *Command) createServer(config *Config) *Server {
 	server := Create(config)
 	return server
@@ -116,6 +193,7 @@ func (c *Command) Run(args []string) int {
 	}
 
 	go c.reportStage(config, server)
+	go c.saveLands(config, server)
 	go c.pingLoop(server)
 
 	// block until we get a signal
@@ -153,6 +231,21 @@ func (c *Command) reportStage(config *Config, server *Server) {
 	}
 }
 
+func (c *Command) saveLands(config *Config, server *Server) {
+	if config.SnapshotInterval == 0 {
+		config.SnapshotInterval = DefaultSnapshotInterval
+	}
+
+	for {
+		select {
+		case <-time.After(config.SnapshotInterval):
+			if err := server.SaveLands(); err != nil {
+				log.Error(fmt.Sprintf("can not save lands to stage: %s", err))
+			}
+		}
+	}
+}
+
 func (c *Command) exitSignals(server *Server) int {
 	signalCh := make(chan os.Signal, 4)
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
@@ -175,7 +268,6 @@ func (c *Command) exitSignals(server *Server) int {
 	}
 
 	gracefulCh := make(chan struct{})
-	gracefulTimeout := 3 * time.Second
 
 	go func() {
 		if err := server.Leave(); err != nil {
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/nickelchen/wonder/land"
+)
+
+var DefaultEventBufferSize = 256
+
+// eventBuffer is a ring of the most recent events, so a subscriber that lost
+// its connection can pick up where it stopped.
+type eventBuffer struct {
+	events []land.Event
+	start  int
+	size   int
+
+	// the seq of the last event ever added, it stays after the event itself
+	// is pushed out of the ring.
+	lastSeq uint64
+}
+
+func newEventBuffer(capacity int) *eventBuffer {
+	if capacity <= 0 {
+		capacity = DefaultEventBufferSize
+	}
+	b := eventBuffer{
+		events: make([]land.Event, capacity),
+	}
+	return &b
+}
+
+func (b *eventBuffer) add(event land.Event) {
+	if b.size < len(b.events) {
+		b.events[(b.start+b.size)%len(b.events)] = event
+		b.size++
+	} else {
+		b.events[b.start] = event
+		b.start = (b.start + 1) % len(b.events)
+	}
+	b.lastSeq = event.Seq
+}
+
+// since returns every buffered event after seq. ok is false when some of them
+// are already gone, or seq is newer than anything we have seen.
+func (b *eventBuffer) since(seq uint64) (events []land.Event, ok bool) {
+	if seq > b.lastSeq {
+		return nil, false
+	}
+	if seq == b.lastSeq {
+		return nil, true
+	}
+	if b.size == 0 || b.events[b.start].Seq > seq+1 {
+		return nil, false
+	}
+
+	for i := 0; i < b.size; i++ {
+		event := b.events[(b.start+i)%len(b.events)]
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, true
+}
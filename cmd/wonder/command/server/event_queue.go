@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var DefaultEventQueueSize = 512
+
+var errSlowConsumer = errors.New(share.ErrSlowConsumer)
+var errStreamStopped = errors.New("stream stopped")
+
+// streamEvent is a land event on its way to one subscriber.
+type streamEvent struct {
+	land.Event
+
+	// events right before this one were coalesced away.
+	Coalesced bool
+}
+
+// eventQueue sits between the server eventLoop and one stream. push never
+// blocks, when the stream falls behind the queue applies its policy.
+type eventQueue struct {
+	policy string
+	limit  int
+
+	lock     sync.Mutex
+	events   []streamEvent
+	closeErr error
+	notifyCh chan struct{}
+}
+
+func newEventQueue(policy string, limit int) *eventQueue {
+	if policy == "" {
+		policy = share.PolicyDropOldest
+	}
+	if limit <= 0 {
+		limit = DefaultEventQueueSize
+	}
+
+	q := eventQueue{
+		policy:   policy,
+		limit:    limit,
+		notifyCh: make(chan struct{}, 1),
+	}
+	return &q
+}
+
+func validPolicy(policy string) bool {
+	switch policy {
+	case "", share.PolicyDropOldest, share.PolicyDisconnect, share.PolicyCoalesce:
+		return true
+	}
+	return false
+}
+
+func (q *eventQueue) push(event land.Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closeErr != nil {
+		return
+	}
+
+	q.events = append(q.events, streamEvent{Event: event})
+
+	if len(q.events) > q.limit {
+		switch q.policy {
+		case share.PolicyDisconnect:
+			log.Warn(fmt.Sprintf("the stream is too slow, %d events queued. closing it", len(q.events)))
+			q.closeErr = errSlowConsumer
+			q.events = nil
+
+		case share.PolicyCoalesce:
+			q.events = coalesce(q.events)
+			if len(q.events) > q.limit {
+				q.dropOldest()
+			}
+
+		default:
+			q.dropOldest()
+		}
+	}
+
+	q.notify()
+}
+
+// stop makes pop return errStreamStopped.
+func (q *eventQueue) stop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closeErr == nil {
+		q.closeErr = errStreamStopped
+		q.events = nil
+	}
+	q.notify()
+}
+
+func (q *eventQueue) notify() {
+	select {
+	case q.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (q *eventQueue) dropOldest() {
+	n := len(q.events) - q.limit
+	log.Warn(fmt.Sprintf("the stream event queue is full. dropping %d oldest events", n))
+	q.events = append([]streamEvent(nil), q.events[n:]...)
+}
+
+// pop waits for queued events and takes all of them. it fails with
+// errSlowConsumer once the stream fell too far behind, or errStreamStopped.
+func (q *eventQueue) pop() ([]streamEvent, error) {
+	for {
+		q.lock.Lock()
+		events, err := q.events, q.closeErr
+		q.events = nil
+		q.lock.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			if q.policy == share.PolicyCoalesce {
+				events = coalesce(events)
+			}
+			return events, nil
+		}
+
+		<-q.notifyCh
+	}
+}
+
+// coalesce keeps only the last move, jump or position event of each sprite,
+// turned into an absolute EventTypePosition. The event that follows a removed
+// one is marked Coalesced so the receiver does not take the hole in Seq for
+// lost events.
+func coalesce(events []streamEvent) []streamEvent {
+	last := make(map[string]int)
+	for i, e := range events {
+		if name, _, ok := spritePosition(e.Event); ok {
+			last[name] = i
+		}
+	}
+
+	var result []streamEvent
+	folded := false
+	replaced := make(map[string]bool)
+
+	for i, e := range events {
+		name, p, ok := spritePosition(e.Event)
+		if ok && last[name] != i {
+			folded = true
+			replaced[name] = true
+			continue
+		}
+
+		if ok && replaced[name] {
+			e.Type = share.EventTypePosition
+			e.Item = share.SpritePosition{Name: name, X: p.X, Y: p.Y}
+		}
+		if folded {
+			e.Coalesced = true
+			folded = false
+		}
+		result = append(result, e)
+	}
+
+	return result
+}
+
+// spritePosition tells which sprite an event puts where, if it is a moving
+// event at all.
+func spritePosition(event land.Event) (name string, p share.Point, ok bool) {
+	switch item := event.Item.(type) {
+	case share.SpriteMove:
+		return item.Name, share.Point{X: item.X, Y: item.Y}, true
+	case share.SpriteJump:
+		return item.Name, share.Point{X: item.X, Y: item.Y}, true
+	case share.SpritePosition:
+		return item.Name, share.Point{X: item.X, Y: item.Y}, true
+	}
+	return "", share.Point{}, false
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a handoff is tried DefaultHandoffTries times, DefaultHandoffRetry apart,
+// before the sprite goes back where it came from.
+var DefaultHandoffTries = 3
+var DefaultHandoffRetry = 1 * time.Second
+
+// Handoff takes in a sprite from a land of another server.
+func (a *Server) Handoff(req *share.HandoffRequest) error {
+	h, err := a.hosted(req.To)
+	if err != nil {
+		return err
+	}
+	return h.land.Enter(req)
+}
+
+// handoffLoop delivers the sprites leaving our lands one by one, so they
+// arrive in the order they left. a try that timed out may have arrived, the
+// id makes the retry of it a no-op.
+func (a *Server) handoffLoop() {
+	for req := range a.handoffCh {
+		req.ID = newID()
+
+		var err error
+		for try := 0; try < DefaultHandoffTries; try++ {
+			if try > 0 {
+				time.Sleep(DefaultHandoffRetry)
+			}
+			if err = a.deliver(&req); err == nil {
+				break
+			}
+			log.Debug(fmt.Sprintf("can not hand off from %s to %s: %s", req.From, req.To, err))
+		}
+		if err == nil {
+			continue
+		}
+
+		log.Error(fmt.Sprintf("can not hand off from %s to %s, sending back: %s", req.From, req.To, err))
+		if h, err := a.hosted(req.From); err == nil {
+			if err := h.land.Return(&req); err != nil {
+				log.Error(fmt.Sprintf("can not send back to %s: %s", req.From, err))
+			}
+		}
+	}
+}
+
+// deliver hands the sprite to the land To, here or on the server the stage
+// says has it.
+func (a *Server) deliver(req *share.HandoffRequest) error {
+	if h, err := a.hosted(req.To); err == nil {
+		return h.land.Enter(req)
+	}
+	if a.stageClient == nil {
+		return fmt.Errorf("unknown land: %s", req.To)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.StageTimeout)
+	defer cancel()
+
+	resp, err := a.stageClient.LocateLandCtx(ctx, &share.LocateLandRequest{Name: req.To})
+	if err != nil {
+		return err
+	}
+	addr := resp.Land.ServerAddr
+
+	cl, ok := a.handoffClients[addr]
+	if !ok {
+		config := client.Config{
+			Addr:    addr,
+			Timeout: a.config.StageTimeout,
+		}
+		if cl, err = client.ClientFromConfig(&config); err != nil {
+			return err
+		}
+		a.handoffClients[addr] = cl
+	}
+
+	if _, err := cl.HandoffCtx(ctx, req); err != nil {
+		// the server may be gone, dial again next time.
+		cl.Close()
+		delete(a.handoffClients, addr)
+		return err
+	}
+	return nil
+}
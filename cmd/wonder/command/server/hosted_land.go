@@ -0,0 +1,192 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hostedLand is one land of the server with the subscribers of its events.
+type hostedLand struct {
+	name string
+	// id is new whenever the land starts here, its seqs are only good with
+	// it.
+	id               string
+	land             *land.Land
+	eventCh          chan land.Event
+	eventHandlers    map[EventHandler]struct{}
+	eventHandlerList []EventHandler
+	eventHandlerLock sync.Mutex
+	eventBuffer      *eventBuffer
+	stopCh           chan struct{}
+
+	// tick reads the tick of the land, it blocks while the land is stuck.
+	tick func() uint64
+	// probeLock guards probing and lastTick. probing is the read of the tick
+	// still under way, a stuck land is only asked once.
+	probeLock sync.Mutex
+	probing   *landProbe
+	lastTick  uint64
+}
+
+type landProbe struct {
+	doneCh chan struct{}
+	tick   uint64
+}
+
+func newHostedLand(name string, landConfig *land.Config, eventBufferSize int) *hostedLand {
+	eventCh := make(chan land.Event, 512)
+	landConfig.EventCh = eventCh
+
+	h := hostedLand{
+		name:          name,
+		id:            newID(),
+		land:          land.Create(landConfig),
+		eventCh:       eventCh,
+		eventHandlers: make(map[EventHandler]struct{}),
+		eventBuffer:   newEventBuffer(eventBufferSize),
+		stopCh:        make(chan struct{}),
+	}
+	h.tick = h.land.Tick
+	return &h
+}
+
+func (h *hostedLand) start() {
+	h.land.Spread()
+
+	go h.eventLoop()
+}
+
+// stop shrinks the land and ends the streams of its subscribers.
+func (h *hostedLand) stop() {
+	h.land.Shrink()
+	close(h.stopCh)
+
+	h.eventHandlerLock.Lock()
+	defer h.eventHandlerLock.Unlock()
+
+	for _, eh := range h.eventHandlerList {
+		if s, ok := eh.(responseStream); ok {
+			s.stop()
+		}
+	}
+	h.eventHandlers = make(map[EventHandler]struct{})
+	h.refreshEventHandlerList()
+}
+
+func (h *hostedLand) subscribe(eh EventHandler) {
+	h.eventHandlerLock.Lock()
+	defer h.eventHandlerLock.Unlock()
+
+	h.eventHandlers[eh] = struct{}{}
+	h.refreshEventHandlerList()
+}
+
+func (h *hostedLand) resume(eh EventHandler, landID string, lastSeq uint64) error {
+	h.eventHandlerLock.Lock()
+	defer h.eventHandlerLock.Unlock()
+
+	// lastSeq is of another run of the land, it says nothing of this one.
+	if landID != h.id {
+		return errors.New(share.ErrSeqTooOld)
+	}
+	events, ok := h.eventBuffer.since(lastSeq)
+	if !ok {
+		return errors.New(share.ErrSeqTooOld)
+	}
+
+	for _, event := range events {
+		eh.Handle(event)
+	}
+
+	h.eventHandlers[eh] = struct{}{}
+	h.refreshEventHandlerList()
+
+	return nil
+}
+
+func (h *hostedLand) unsubscribe(eh EventHandler) {
+	h.eventHandlerLock.Lock()
+	defer h.eventHandlerLock.Unlock()
+
+	if _, ok := h.eventHandlers[eh]; !ok {
+		return
+	}
+	delete(h.eventHandlers, eh)
+	h.refreshEventHandlerList()
+}
+
+func (h *hostedLand) subscribers() int {
+	h.eventHandlerLock.Lock()
+	defer h.eventHandlerLock.Unlock()
+
+	return len(h.eventHandlerList)
+}
+
+func (h *hostedLand) refreshEventHandlerList() {
+	h.eventHandlerList = nil
+	for eh := range h.eventHandlers {
+		h.eventHandlerList = append(h.eventHandlerList, eh)
+	}
+}
+
+// probe reads the tick of the land, waiting at most timeout. a land that
+// does not answer in time is reported stuck with the last tick it answered.
+func (h *hostedLand) probe(timeout time.Duration) share.LandHealth {
+	h.probeLock.Lock()
+	p := h.probing
+	if p == nil {
+		p = &landProbe{doneCh: make(chan struct{})}
+		h.probing = p
+		go func() {
+			p.tick = h.tick()
+
+			h.probeLock.Lock()
+			h.probing = nil
+			h.lastTick = p.tick
+			h.probeLock.Unlock()
+			close(p.doneCh)
+		}()
+	}
+	h.probeLock.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.doneCh:
+		return share.LandHealth{Name: h.name, Tick: p.tick}
+	case <-timer.C:
+	}
+
+	h.probeLock.Lock()
+	defer h.probeLock.Unlock()
+
+	log.Warn(fmt.Sprintf("land %q did not answer the probe in %s", h.name, timeout))
+	return share.LandHealth{Name: h.name, Tick: h.lastTick, Stuck: true}
+}
+
+func (h *hostedLand) eventLoop() {
+	for {
+		select {
+		case event := <-h.eventCh:
+			// get Event from land, fan out
+			log.Debug(fmt.Sprintf("in server eventLoop, land %q, get event :%v", h.name, event))
+
+			h.eventHandlerLock.Lock()
+			h.eventBuffer.add(event)
+			for _, eh := range h.eventHandlerList {
+				eh.Handle(event)
+			}
+			h.eventHandlerLock.Unlock()
+		case <-h.stopCh:
+			return
+		}
+	}
+}
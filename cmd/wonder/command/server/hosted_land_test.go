@@ -0,0 +1,46 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/land"
+)
+
+func TestProbeOfAStuckLand(t *testing.T) {
+	config := land.DefaultConfig()
+	config.ManualTick = true
+	h := newHostedLand("stuck", config, 0)
+
+	if health := h.probe(time.Second); health.Stuck || health.Tick != 0 {
+		t.Fatalf("probe of a fresh land: %+v", health)
+	}
+	h.land.Step()
+	if health := h.probe(time.Second); health.Stuck || health.Tick != 1 {
+		t.Fatalf("probe after a step: %+v", health)
+	}
+
+	// the land holds its lock until unstuck.
+	var reads int32
+	unstuckCh := make(chan struct{})
+	h.tick = func() uint64 {
+		atomic.AddInt32(&reads, 1)
+		<-unstuckCh
+		return 2
+	}
+
+	for i := 0; i < 3; i++ {
+		if health := h.probe(10 * time.Millisecond); !health.Stuck || health.Tick != 1 {
+			t.Fatalf("probe %d of a stuck land: %+v", i, health)
+		}
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Fatalf("a stuck land was asked %d times", n)
+	}
+
+	close(unstuckCh)
+	if health := h.probe(time.Second); health.Stuck || health.Tick != 2 {
+		t.Fatalf("probe of an unstuck land: %+v", health)
+	}
+}
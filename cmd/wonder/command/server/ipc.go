@@ -2,12 +2,14 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"github.com/nickelchen/wonder/land"
 	"github.com/nickelchen/wonder/share"
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
 
@@ -15,17 +17,32 @@ import (
 )
 
 type IPCClient struct {
-	from                 string
-	conn                 net.Conn
-	reader               *bufio.Reader
-	writer               *bufio.Writer
-	dec                  *codec.Decoder
-	enc                  *codec.Encoder
-	eventResponseStreams map[uint64]*eventResponseStream
+	from    string
+	conn    net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+	dec     *codec.Decoder
+	enc     *codec.Encoder
+	streams map[uint64]responseStream
+	hooks   *Hooks
+
+	// the characters this client possessed, only touched by handleClient.
+	possessed map[possession]struct{}
+
+	// streams write to the same connection from their own goroutines.
+	writeLock sync.Mutex
 }
 
 // send share.ResponseHeader and responseBody to client.
 func (c *IPCClient) send(header *share.ResponseHeader, obj interface{}) error {
+	if err := c.hookSend(header); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
 	if err := c.enc.Encode(header); err != nil {
 		log.Error(fmt.Sprintf("Error in encode header: %s", err))
 		log.Error(trace())
@@ -48,18 +65,38 @@ func (c *IPCClient) send(header *share.ResponseHeader, obj interface{}) error {
 	return nil
 }
 
+// possession is a character of a land.
+type possession struct {
+	land string
+	name string
+}
+
+// responseStream is a long running stream of events to one client, it lives
+// until the client stops it or goes away.
+type responseStream interface {
+	EventHandler
+	stop()
+}
+
 type ServerIPC struct {
-	server   *Server
-	listener net.Listener
-	clients  map[string]*IPCClient
-	stop     bool
+	server      *Server
+	listener    net.Listener
+	clients     map[string]*IPCClient
+	clientsLock sync.Mutex
+	stop        bool
+	hooks       *Hooks
 }
 
 func NewServerIPC(server *Server, listener net.Listener) *ServerIPC {
+	return NewServerIPCWithHooks(server, listener, nil)
+}
+
+func NewServerIPCWithHooks(server *Server, listener net.Listener, hooks *Hooks) *ServerIPC {
 	ipc := &ServerIPC{
 		server:   server,
 		listener: listener,
 		clients:  make(map[string]*IPCClient),
+		hooks:    hooks,
 	}
 
 	go ipc.listen()
@@ -74,6 +111,15 @@ func (i *ServerIPC) Shutdown() {
 	i.stop = true
 
 	i.listener.Close()
+	i.DropClients()
+}
+
+// DropClients closes the connection of every client, as a failing network
+// would. the listener stays open for them to come back.
+func (i *ServerIPC) DropClients() {
+	i.clientsLock.Lock()
+	defer i.clientsLock.Unlock()
+
 	for _, c := range i.clients {
 		c.conn.Close()
 	}
@@ -86,18 +132,22 @@ func (i *ServerIPC) listen() {
 			return
 		}
 		client := &IPCClient{
-			from:                 conn.RemoteAddr().String(),
-			conn:                 conn,
-			reader:               bufio.NewReader(conn),
-			writer:               bufio.NewWriter(conn),
-			eventResponseStreams: make(map[uint64]*eventResponseStream),
+			from:      conn.RemoteAddr().String(),
+			conn:      conn,
+			reader:    bufio.NewReader(conn),
+			writer:    bufio.NewWriter(conn),
+			streams:   make(map[uint64]responseStream),
+			possessed: make(map[possession]struct{}),
 		}
+		client.hooks = i.hooks
 		client.dec = codec.NewDecoder(client.reader,
 			&codec.MsgpackHandle{RawToString: true, WriteExt: true})
 		client.enc = codec.NewEncoder(client.writer,
 			&codec.MsgpackHandle{RawToString: true, WriteExt: true})
 
+		i.clientsLock.Lock()
 		i.clients[client.from] = client
+		i.clientsLock.Unlock()
 
 		go i.handleClient(client)
 	}
@@ -106,6 +156,9 @@ func (i *ServerIPC) listen() {
 // read client request header, dispatch command, send response to client.
 func (i *ServerIPC) handleClient(client *IPCClient) {
 	log.Debug(fmt.Sprintf("Get client. %v", client))
+	defer i.dropClient(client)
+	defer i.stopStreams(client)
+	defer i.releaseAll(client)
 
 	var reqHeader share.RequestHeader
 	for {
@@ -127,13 +180,40 @@ func (i *ServerIPC) handleClient(client *IPCClient) {
 		command := reqHeader.Command
 		log.Debug(fmt.Sprintf("reqHeader.Command: %v", command))
 
+		if respHeader, respBody = client.hookRequest(command, reqHeader.Seq); respHeader != nil {
+			client.send(respHeader, respBody)
+			continue
+		}
+
 		switch command {
+		case share.HandshakeCommand:
+			respHeader, respBody = i.handleHandshake(client, reqHeader.Seq)
 		case share.PlantCommand:
 			respHeader, respBody = i.handlePlant(client, reqHeader.Seq)
 		case share.InfoCommand:
 			respHeader, respBody = i.handleInfo(client, reqHeader.Seq)
 		case share.SubscribeCommand:
 			respHeader, respBody = i.handleSubscribe(client, reqHeader.Seq)
+		case share.SyncCommand:
+			respHeader, respBody = i.handleSync(client, reqHeader.Seq)
+		case share.StopCommand:
+			respHeader, respBody = i.handleStop(client, reqHeader.Seq)
+		case share.PingCommand:
+			respHeader, respBody = i.handlePing(client, reqHeader.Seq)
+		case share.HostLandCommand:
+			respHeader, respBody = i.handleHostLand(client, reqHeader.Seq)
+		case share.DropLandCommand:
+			respHeader, respBody = i.handleDropLand(client, reqHeader.Seq)
+		case share.HandoffCommand:
+			respHeader, respBody = i.handleHandoff(client, reqHeader.Seq)
+		case share.AddPortalCommand:
+			respHeader, respBody = i.handleAddPortal(client, reqHeader.Seq)
+		case share.PossessCommand:
+			respHeader, respBody = i.handlePossess(client, reqHeader.Seq)
+		case share.ReleaseCommand:
+			respHeader, respBody = i.handleRelease(client, reqHeader.Seq)
+		case share.MoveCommand:
+			respHeader, respBody = i.handleMove(client, reqHeader.Seq)
 		}
 
 		log.Debug(fmt.Sprintf("respHeader is :%v", respHeader))
@@ -157,16 +237,17 @@ func (i *ServerIPC) handlePlant(client *IPCClient, seq uint64) (*share.ResponseH
 		Number: req.Number,
 	}
 
-	plantResult, err := i.server.Plant(&plantParams)
+	plantResult, err := i.server.Plant(req.Land, &plantParams)
 
 	respHeader := share.ResponseHeader{
 		Seq:   seq,
 		Error: errorToString(err),
 	}
 
-	respBody := share.PlantResponse{
-		Succ: plantResult.Succ,
-		Fail: plantResult.Fail,
+	respBody := share.PlantResponse{}
+	if plantResult != nil {
+		respBody.Succ = plantResult.Succ
+		respBody.Fail = plantResult.Fail
 	}
 
 	return &respHeader, &respBody
@@ -182,19 +263,26 @@ func (i *ServerIPC) handleInfo(client *IPCClient, seq uint64) (*share.ResponseHe
 
 	infoParams := land.InfoParams{}
 
-	infoResult, err := i.server.Info(&infoParams)
-
-	if err == nil {
-		infoRespStream := newInfoResponseStream(client, seq)
-		go infoRespStream.stream(infoResult)
-	}
+	infoResult, err := i.server.Info(req.Land, &infoParams)
 
 	respHeader := share.ResponseHeader{
 		Seq:   seq,
 		Error: errorToString(err),
 	}
 
-	return &respHeader, nil
+	// the client takes the first response for the header of the stream, so
+	// it must go out before any item.
+	if err := client.send(&respHeader, &share.InfoResponse{}); err != nil {
+		return nil, nil
+	}
+	if infoResult == nil {
+		return nil, nil
+	}
+
+	infoRespStream := newInfoResponseStream(client, seq)
+	go infoRespStream.stream(infoResult)
+
+	return nil, nil
 }
 
 func (i *ServerIPC) handleSubscribe(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.SubscribeResponse) {
@@ -208,18 +296,315 @@ func (i *ServerIPC) handleSubscribe(client *IPCClient, seq uint64) (*share.Respo
 		Seq:   seq,
 		Error: "",
 	}
-	if _, ok := client.eventResponseStreams[seq]; ok {
+	if _, ok := client.streams[seq]; ok {
 		respHeader.Error = "stream with seq already exists"
+		return &respHeader, &share.SubscribeResponse{}
+	}
+
+	if !validPolicy(req.Policy) {
+		respHeader.Error = fmt.Sprintf("unknown policy: %s", req.Policy)
+		return &respHeader, &share.SubscribeResponse{}
+	}
+
+	s := newEventResponseStream(client, seq, req.Policy, i.server.config.EventQueueSize)
+
+	var landID string
+	var err error
+	if req.Resume {
+		landID, err = i.server.Resume(req.Land, s, req.LandID, req.LastSeq)
+	} else {
+		landID, err = i.server.Subscribe(req.Land, s)
+	}
+	if err != nil {
+		respHeader.Error = errorToString(err)
+		return &respHeader, &share.SubscribeResponse{}
+	}
+
+	// events are queued in the stream until now. make sure send this
+	// response first, then we can stream events.
+	if err := client.send(&respHeader, &share.SubscribeResponse{LandID: landID}); err != nil {
+		i.server.Unsubscribe(s)
+		return nil, nil
+	}
+	client.streams[seq] = s
+
+	go func() {
+		s.stream()
+		i.server.Unsubscribe(s)
+	}()
+
+	return nil, nil
+}
+
+func (i *ServerIPC) handleSync(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.SyncResponse) {
+	var req share.SyncRequest
+	if err := client.dec.Decode(&req); err != nil {
+		log.Error("can not decode syncRequest")
+		return nil, nil
+	}
+
+	if _, ok := client.streams[seq]; ok {
+		respHeader := share.ResponseHeader{
+			Seq:   seq,
+			Error: "stream with seq already exists",
+		}
+		return &respHeader, &share.SyncResponse{}
+	}
+
+	if !validPolicy(req.Policy) {
+		respHeader := share.ResponseHeader{
+			Seq:   seq,
+			Error: fmt.Sprintf("unknown policy: %s", req.Policy),
+		}
+		return &respHeader, &share.SyncResponse{}
+	}
+
+	s := newSyncResponseStream(client, seq, req.Policy, i.server.config.EventQueueSize, req.Region)
+	snapshot, landID, err := i.server.Sync(req.Land, s)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
 	}
 
-	s := newEventResponseStream(client, seq)
-	client.eventResponseStreams[seq] = s
+	// the client waits for this response before reading the stream, so it
+	// must go out before any snapshot item.
+	if err := client.send(&respHeader, &share.SyncResponse{LandID: landID}); err != nil {
+		i.server.Unsubscribe(s)
+		return nil, nil
+	}
+	if snapshot == nil {
+		i.server.Unsubscribe(s)
+		return nil, nil
+	}
+
+	client.streams[seq] = s
+
+	go func() {
+		s.stream(snapshot)
+		i.server.Unsubscribe(s)
+	}()
+
+	return nil, nil
+}
 
-	// prevent race condition. make sure send this response first, then we can stream events.
-	// TODO
-	defer i.server.Subscribe(s)
+func (i *ServerIPC) handleStop(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.StopResponse) {
+	var req share.StopRequest
+	if err := client.dec.Decode(&req); err != nil {
+		log.Error("can not decode stopRequest")
+		return nil, nil
+	}
+
+	// stopping a stream that is already gone is fine.
+	if s, ok := client.streams[req.Seq]; ok {
+		delete(client.streams, req.Seq)
+		i.server.Unsubscribe(s)
+		s.stop()
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+
+	return &respHeader, &share.StopResponse{}
+}
+
+func (i *ServerIPC) handlePing(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.PingResponse) {
+	var req share.PingRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	resp, err := i.server.Probe()
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, resp
+}
+
+func (i *ServerIPC) handleHostLand(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.HostLandResponse) {
+	var req share.HostLandRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.server.HostLand(&req)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.HostLandResponse{}
+}
+
+func (i *ServerIPC) handleDropLand(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.DropLandResponse) {
+	var req share.DropLandRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.server.DropLand(req.Name)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.DropLandResponse{}
+}
+
+func (i *ServerIPC) handleHandoff(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.HandoffResponse) {
+	var req share.HandoffRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.server.Handoff(&req)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.HandoffResponse{}
+}
+
+func (i *ServerIPC) handlePossess(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.PossessResponse) {
+	var req share.PossessRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	var err error
+	p := possession{land: req.Land, name: req.Name}
+	if _, ok := client.possessed[p]; !ok {
+		if err = i.server.Possess(req.Land, req.Name); err == nil {
+			client.possessed[p] = struct{}{}
+		}
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.PossessResponse{}
+}
+
+func (i *ServerIPC) handleRelease(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.ReleaseResponse) {
+	var req share.ReleaseRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	p := possession{land: req.Land, name: req.Name}
+	if _, ok := client.possessed[p]; ok {
+		delete(client.possessed, p)
+		i.server.Release(req.Land, req.Name)
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+
+	return &respHeader, &share.ReleaseResponse{}
+}
+
+func (i *ServerIPC) handleMove(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.MoveResponse) {
+	var req share.MoveRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	var err error
+	var point share.Point
+	var to string
+	key := possession{land: req.Land, name: req.Name}
+	if _, ok := client.possessed[key]; ok {
+		point, to, err = i.server.Move(req.Land, req.Name, req.Direction)
+	} else {
+		err = errors.New(share.ErrNotPossessed)
+	}
+	if to != "" {
+		// the land let go of it.
+		delete(client.possessed, key)
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.MoveResponse{X: point.X, Y: point.Y, To: to}
+}
+
+// releaseAll gives back the characters of a client that went away.
+func (i *ServerIPC) releaseAll(client *IPCClient) {
+	for p := range client.possessed {
+		delete(client.possessed, p)
+		i.server.Release(p.land, p.name)
+	}
+}
+
+// stopStreams ends the streams of a client that went away, a quiet land
+// would never fail a send to tell them.
+func (i *ServerIPC) stopStreams(client *IPCClient) {
+	for seq, s := range client.streams {
+		delete(client.streams, seq)
+		i.server.Unsubscribe(s)
+		s.stop()
+	}
+}
+
+// dropClient forgets a client that went away, unless another one came from
+// the same address since.
+func (i *ServerIPC) dropClient(client *IPCClient) {
+	i.clientsLock.Lock()
+	defer i.clientsLock.Unlock()
+
+	if i.clients[client.from] == client {
+		delete(i.clients, client.from)
+	}
+}
+
+func (i *ServerIPC) handleAddPortal(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.AddPortalResponse) {
+	var req share.AddPortalRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.server.AddPortal(req.Land, req.Portal)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.AddPortalResponse{}
+}
+
+func (i *ServerIPC) handleHandshake(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.HandshakeResponse) {
+	var req share.HandshakeRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	if req.Version != share.ProtocolVersion {
+		respHeader.Error = fmt.Sprintf("unsupported protocol version %d, want %d",
+			req.Version, share.ProtocolVersion)
+	}
 
-	return &respHeader, nil
+	return &respHeader, &share.HandshakeResponse{}
 }
 
 func errorToString(err error) string {
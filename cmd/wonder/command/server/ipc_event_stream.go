@@ -11,30 +11,28 @@ import (
 
 type eventResponseStream struct {
 	//
-	client  *IPCClient
-	seq     uint64
-	eventCh chan land.Event
+	client *IPCClient
+	seq    uint64
+	queue  *eventQueue
 }
 
-func newEventResponseStream(client *IPCClient, seq uint64) *eventResponseStream {
+func newEventResponseStream(client *IPCClient, seq uint64, policy string, queueSize int) *eventResponseStream {
 	s := eventResponseStream{
-		client:  client,
-		seq:     seq,
-		eventCh: make(chan land.Event, 512),
+		client: client,
+		seq:    seq,
+		queue:  newEventQueue(policy, queueSize),
 	}
 
-	go s.stream()
-
 	return &s
 }
 
 func (s *eventResponseStream) Handle(event land.Event) {
-	// non-blocking send.
-	select {
-	case s.eventCh <- event:
-	default:
-		log.Warn(fmt.Sprintf("the stream event channel is full. dropping event: %s", event))
-	}
+	// never blocks, the queue deals with a slow stream.
+	s.queue.push(event)
+}
+
+func (s *eventResponseStream) stop() {
+	s.queue.stop()
 }
 
 func (s *eventResponseStream) stream() {
@@ -43,16 +41,31 @@ func (s *eventResponseStream) stream() {
 		Error: "",
 	}
 	for {
-		select {
-		case event := <-s.eventCh:
+		events, err := s.queue.pop()
+		if err == errSlowConsumer {
+			respHeader.Error = err.Error()
+			s.client.send(&respHeader, &share.SubscribeResponse{})
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		for _, event := range events {
 			bs, err := json.Marshal(event.Item)
 			if err != nil {
 				log.Error(fmt.Sprintf("can not convert event item to bytes: %s", err))
+				respHeader.Error = err.Error()
+				s.client.send(&respHeader, &share.SubscribeResponse{})
+				return
 			}
 
 			respBody := share.EventResponseObj{
-				Type:    event.Type,
-				Payload: bs,
+				Type:      event.Type,
+				Seq:       event.Seq,
+				Tick:      event.Tick,
+				Coalesced: event.Coalesced,
+				Payload:   bs,
 			}
 
 			if err := s.client.send(&respHeader, &respBody); err != nil {
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"github.com/nickelchen/wonder/share"
+)
+
+// Hooks let tests get between a connection and the handlers, see wondertest.
+// Both are optional and called from the goroutines of the connection.
+type Hooks struct {
+	// Request is called before a request is handled. if it returns a
+	// header, the request body is skipped and the header and body are sent
+	// instead of calling the handler.
+	Request func(command string, seq uint64) (*share.ResponseHeader, interface{})
+
+	// Send is called before every response, stream items included. it may
+	// sleep to make a slow stream. an error drops the connection.
+	Send func(header *share.ResponseHeader) error
+}
+
+func (c *IPCClient) hookRequest(command string, seq uint64) (*share.ResponseHeader, interface{}) {
+	if c.hooks == nil || c.hooks.Request == nil {
+		return nil, nil
+	}
+
+	respHeader, respBody := c.hooks.Request(command, seq)
+	if respHeader != nil {
+		var body interface{}
+		c.dec.Decode(&body)
+	}
+	return respHeader, respBody
+}
+
+func (c *IPCClient) hookSend(header *share.ResponseHeader) error {
+	if c.hooks == nil || c.hooks.Send == nil {
+		return nil
+	}
+	return c.hooks.Send(header)
+}
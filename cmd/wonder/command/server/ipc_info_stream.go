@@ -23,6 +23,7 @@ func newInfoResponseStream(client *IPCClient, seq uint64) *InfoResponseStream {
 	return &s
 }
 
+// stream sends the items of infoResult, up to the InfoItemTypeDone one.
 func (s *InfoResponseStream) stream(infoResult *land.InfoResult) {
 	resultCh := infoResult.ResultCh()
 	respHeader := share.ResponseHeader{
@@ -30,24 +31,25 @@ func (s *InfoResponseStream) stream(infoResult *land.InfoResult) {
 		Error: "",
 	}
 
-	for {
-		select {
-		case obj := <-resultCh:
-			bs, err := json.Marshal(obj.Item)
-
-			if err != nil {
-				log.Error(fmt.Sprintf("can not convert struct to bytes: %s", err))
-				break
-			}
-
-			respBody := share.InfoResponseObj{
-				Type:    obj.Type,
-				Payload: bs,
-			}
-			if err := s.client.send(&respHeader, &respBody); err != nil {
-				return
-			}
+	for obj := range resultCh {
+		bs, err := json.Marshal(obj.Item)
+		if err != nil {
+			// a missing item would go unnoticed, end the stream instead.
+			log.Error(fmt.Sprintf("can not convert struct to bytes: %s", err))
+			respHeader.Error = err.Error()
+			s.client.send(&respHeader, &share.InfoResponse{})
+			return
 		}
-	}
 
+		respBody := share.InfoResponseObj{
+			Type:    obj.Type,
+			Payload: bs,
+		}
+		if err := s.client.send(&respHeader, &respBody); err != nil {
+			return
+		}
+		if obj.Type == share.InfoItemTypeDone {
+			return
+		}
+	}
 }
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type syncResponseStream struct {
+	client *IPCClient
+	seq    uint64
+	queue  *eventQueue
+	region *share.Region
+
+	// the kind of every character of the land, and if it is in the region,
+	// as far as the items sent so far tell. only stream uses them.
+	kinds  map[string]string
+	inside map[string]bool
+}
+
+func newSyncResponseStream(client *IPCClient, seq uint64, policy string, queueSize int, region *share.Region) *syncResponseStream {
+	s := syncResponseStream{
+		client: client,
+		seq:    seq,
+		queue:  newEventQueue(policy, queueSize),
+		region: region,
+		kinds:  make(map[string]string),
+		inside: make(map[string]bool),
+	}
+
+	return &s
+}
+
+func (s *syncResponseStream) Handle(event land.Event) {
+	// never blocks. events queue up here while the snapshot is streaming.
+	s.queue.push(event)
+}
+
+func (s *syncResponseStream) stop() {
+	s.queue.stop()
+}
+
+// stream sends the snapshot first, then every event after snapshot.Seq.
+func (s *syncResponseStream) stream(snapshot *land.Snapshot) {
+	respHeader := share.ResponseHeader{
+		Seq:   s.seq,
+		Error: "",
+	}
+
+	if s.region == nil {
+		if err := s.sendItem(&respHeader, share.InfoItemTypeTile, snapshot.Seq, snapshot.Tick, false, snapshot.Tiles); err != nil {
+			return
+		}
+	} else {
+		if err := s.sendItem(&respHeader, share.InfoItemTypeTileRegion, snapshot.Seq, snapshot.Tick, false, tileRegion(snapshot.Tiles, *s.region)); err != nil {
+			return
+		}
+	}
+	for _, sprite := range snapshot.Sprites {
+		in := s.region == nil || s.region.Contains(sprite.GetPoint())
+		if name, kind, ok := character(sprite); ok {
+			s.kinds[name] = kind
+			s.inside[name] = in
+		}
+		if !in {
+			continue
+		}
+		if err := s.sendItem(&respHeader, land.InfoItemType(sprite), snapshot.Seq, snapshot.Tick, false, sprite); err != nil {
+			return
+		}
+	}
+	if err := s.sendItem(&respHeader, share.InfoItemTypeDone, snapshot.Seq, snapshot.Tick, false, struct{}{}); err != nil {
+		return
+	}
+
+	// the event after one left out is marked coalesced, like the queue
+	// does, so the hole in Seq is not taken for lost events.
+	skipped := false
+	for {
+		events, err := s.queue.pop()
+		if err == errSlowConsumer {
+			respHeader.Error = err.Error()
+			s.client.send(&respHeader, &share.SyncResponse{})
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		for _, event := range events {
+			// already part of the snapshot.
+			if event.Seq <= snapshot.Seq {
+				continue
+			}
+			t, item, ok := s.scope(event.Event)
+			if !ok {
+				skipped = true
+				continue
+			}
+			if err := s.sendItem(&respHeader, t, event.Seq, event.Tick, event.Coalesced || skipped, item); err != nil {
+				return
+			}
+			skipped = false
+		}
+	}
+}
+
+// scope is what the region sees of an event, ok is false if nothing. a
+// character moving out of the region leaves it, one moving in enters it.
+func (s *syncResponseStream) scope(event land.Event) (t string, item interface{}, ok bool) {
+	if s.region == nil {
+		return event.Type, event.Item, true
+	}
+
+	switch o := event.Item.(type) {
+	case share.SpriteEnter:
+		in := s.region.Contains(share.Point{X: o.X, Y: o.Y})
+		s.kinds[o.Name] = o.Kind
+		s.inside[o.Name] = in
+		return event.Type, event.Item, in
+	case share.SpriteLeave:
+		in := s.inside[o.Name]
+		delete(s.kinds, o.Name)
+		delete(s.inside, o.Name)
+		return event.Type, event.Item, in
+	case share.Portal:
+		return event.Type, event.Item, s.region.Contains(o.P)
+	case share.SpriteAdd:
+		return event.Type, event.Item, s.region.Contains(share.Point{X: o.X, Y: o.Y})
+	}
+
+	name, p, moving := spritePosition(event)
+	if !moving {
+		return event.Type, event.Item, true
+	}
+	was, in := s.inside[name], s.region.Contains(p)
+	s.inside[name] = in
+	switch {
+	case in && !was:
+		kind, known := s.kinds[name]
+		if !known {
+			// born in the land after the snapshot, only the rabbit is.
+			kind = share.InfoItemTypeAnimal
+			s.kinds[name] = kind
+		}
+		return share.EventTypeEnter, share.SpriteEnter{Name: name, Kind: kind, X: p.X, Y: p.Y}, true
+	case was && !in:
+		return share.EventTypeLeave, share.SpriteLeave{Name: name, X: p.X, Y: p.Y}, true
+	}
+	return event.Type, event.Item, in
+}
+
+// character tells the name and the kind of a human or an animal.
+func character(sprite share.Sprite) (name string, kind string, ok bool) {
+	switch o := sprite.(type) {
+	case share.Human:
+		return o.Name, share.InfoItemTypeHuman, true
+	case share.Animal:
+		return o.Name, share.InfoItemTypeAnimal, true
+	}
+	return "", "", false
+}
+
+// tileRegion cuts r out of tiles, as far as the land goes.
+func tileRegion(tiles [][]share.Tile, r share.Region) share.TileRegion {
+	tr := share.TileRegion{Height: len(tiles)}
+	if len(tiles) > 0 {
+		tr.Width = len(tiles[0])
+	}
+
+	x0, y0 := clamp(r.X, 0, tr.Width), clamp(r.Y, 0, tr.Height)
+	x1, y1 := clamp(r.X+r.Width, x0, tr.Width), clamp(r.Y+r.Height, y0, tr.Height)
+	tr.Region = share.Region{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+	for y := y0; y < y1; y++ {
+		tr.Tiles = append(tr.Tiles, tiles[y][x0:x1])
+	}
+	return tr
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func (s *syncResponseStream) sendItem(respHeader *share.ResponseHeader, t string, seq, tick uint64, coalesced bool, item interface{}) error {
+	bs, err := json.Marshal(item)
+	if err != nil {
+		// a missing item would leave the mirror wrong, end the stream instead.
+		log.Error(fmt.Sprintf("can not convert sync item to bytes: %s", err))
+		respHeader.Error = err.Error()
+		s.client.send(respHeader, &share.SyncResponse{})
+		return err
+	}
+
+	respBody := share.SyncResponseObj{
+		Type:      t,
+		Seq:       seq,
+		Tick:      tick,
+		Coalesced: coalesced,
+		Payload:   bs,
+	}
+
+	return s.client.send(respHeader, &respBody)
+}
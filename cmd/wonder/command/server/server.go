@@ -1,11 +1,18 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/nickelchen/wonder/client"
 	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/share"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,57 +22,91 @@ type EventHandler interface {
 }
 
 type Server struct {
-	name             string
-	land             *land.Land
-	config           *Config
-	landConfig       *land.Config
-	shutdownCh       chan struct{}
-	eventCh          chan land.Event
-	eventHandlers    map[EventHandler]struct{}
-	eventHandlerList []EventHandler
+	name       string
+	id         string
+	land       *land.Land
+	config     *Config
+	landConfig *land.Config
+	shutdownCh chan struct{}
+
+	// the land of the server itself is lands[""], the others are hosted for
+	// the stage, by name.
+	lands     map[string]*hostedLand
+	landsLock sync.RWMutex
+
+	// sprites walking from our lands to others.
+	handoffCh      chan share.HandoffRequest
+	handoffClients map[string]*client.RPCClient
 
 	stageClient *client.RPCClient
 	reportTimes int
+
+	// once left, the server no longer reports alive.
+	leftStage bool
+	stageLock sync.Mutex
 }
 
 func Create(config *Config) *Server {
 	shutdownCh := make(chan struct{})
-	eventCh := make(chan land.Event, 512)
-
-	landConfig := land.DefaultConfig()
-	landConfig.EventCh = eventCh
 
-	l := land.Create(landConfig)
+	landConfig := config.LandConfig
+	if landConfig == nil {
+		landConfig = land.DefaultConfig()
+	}
+	home := newHostedLand("", landConfig, config.EventBufferSize)
 
 	server := Server{
-		land:          l,
-		config:        config,
-		landConfig:    landConfig,
-		shutdownCh:    shutdownCh,
-		eventCh:       eventCh,
-		eventHandlers: make(map[EventHandler]struct{}),
+		name:       config.Name,
+		id:         newID(),
+		land:       home.land,
+		config:     config,
+		landConfig: landConfig,
+		shutdownCh: shutdownCh,
+		lands:      map[string]*hostedLand{"": home},
+
+		handoffCh:      make(chan share.HandoffRequest, 64),
+		handoffClients: make(map[string]*client.RPCClient),
 	}
 	return &server
 }
 
 func (a *Server) Enter() {
 	log.Info("In command/server/server.go Enter()")
-	a.land.Spread()
+	a.lands[""].start()
 
-	go a.eventLoop()
+	go a.handoffLoop()
 
 	// go a.autoShutdown(10 * time.Second)
 }
 
 func (a *Server) Leave() error {
 	log.Info("In command/server/server.go Leave()")
-	a.land.Shrink()
+	// whoever hosts our lands next goes on from here. saving is cut short
+	// rather than keep the ServerLeave after it from the stage.
+	ctx, cancel := context.WithTimeout(context.Background(), LeaveSaveTimeout)
+	defer cancel()
+	if err := a.saveLands(ctx); err != nil {
+		log.Error(fmt.Sprintf("can not save lands: %s", err))
+	}
+	if err := a.LeaveStage(); err != nil {
+		log.Error(fmt.Sprintf("can not leave stage: %s", err))
+	}
+
+	a.landsLock.Lock()
+	for _, h := range a.lands {
+		h.land.Shrink()
+	}
+	a.landsLock.Unlock()
 
 	// simulate leaving process
 	time.Sleep(2 * time.Second)
 	return nil
 }
 
+func (a *Server) Land() *land.Land {
+	return a.land
+}
+
 func (a *Server) ShutdownCh() <-chan struct{} {
 	return a.shutdownCh
 }
@@ -83,30 +124,296 @@ func (a *Server) Ping() string {
 	return "<="
 }
 
-func (a *Server) Plant(params *land.PlantParams) (*land.PlantResult, error) {
-	result, err := a.land.Plant(params)
+// Probe answers the health check of the stage. it asks every hosted land at
+// once, a land stuck holding its lock is reported so after ProbeTimeout.
+func (a *Server) Probe() (*share.PingResponse, error) {
+	a.landsLock.RLock()
+	lands := make([]*hostedLand, 0, len(a.lands))
+	for _, h := range a.lands {
+		lands = append(lands, h)
+	}
+	a.landsLock.RUnlock()
+	// the home land, named "", comes first.
+	sort.Slice(lands, func(i, j int) bool { return lands[i].name < lands[j].name })
+
+	timeout := a.config.ProbeTimeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+
+	health := make([]share.LandHealth, len(lands))
+	var wg sync.WaitGroup
+	for i, h := range lands {
+		wg.Add(1)
+		go func(i int, h *hostedLand) {
+			defer wg.Done()
+			health[i] = h.probe(timeout)
+		}(i, h)
+	}
+	wg.Wait()
+
+	resp := share.PingResponse{
+		Tick:  health[0].Tick,
+		ID:    a.id,
+		Lands: health,
+	}
+	return &resp, nil
+}
+
+func (a *Server) hosted(name string) (*hostedLand, error) {
+	a.landsLock.RLock()
+	defer a.landsLock.RUnlock()
+
+	h, ok := a.lands[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown land: %s", name)
+	}
+	return h, nil
+}
+
+func (a *Server) Plant(name string, params *land.PlantParams) (*land.PlantResult, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.land.Plant(params)
 	return result, err
 }
 
-func (a *Server) Info(params *land.InfoParams) (*land.InfoResult, error) {
-	result, err := a.land.Info(params)
+func (a *Server) Info(name string, params *land.InfoParams) (*land.InfoResult, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.land.Info(params)
 	return result, err
 }
 
-func (a *Server) Subscribe(eh EventHandler) {
-	a.eventHandlers[eh] = struct{}{}
-	a.eventHandlerList = nil
-	for eh := range a.eventHandlers {
-		a.eventHandlerList = append(a.eventHandlerList, eh)
+// Subscribe subscribes eh to the events of the land, and tells the id of
+// the land to resume with.
+func (a *Server) Subscribe(name string, eh EventHandler) (string, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return "", err
+	}
+	h.subscribe(eh)
+	return h.id, nil
+}
+
+// Resume subscribes eh, first handing it every buffered event after lastSeq,
+// of the land with landID.
+func (a *Server) Resume(name string, eh EventHandler, landID string, lastSeq uint64) (string, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return "", err
+	}
+	return h.id, h.resume(eh, landID, lastSeq)
+}
+
+// Unsubscribe takes eh off whichever land it is subscribed to.
+func (a *Server) Unsubscribe(eh EventHandler) {
+	a.landsLock.RLock()
+	defer a.landsLock.RUnlock()
+
+	for _, h := range a.lands {
+		h.unsubscribe(eh)
+	}
+}
+
+func (a *Server) subscribers() int {
+	a.landsLock.RLock()
+	defer a.landsLock.RUnlock()
+
+	n := 0
+	for _, h := range a.lands {
+		n += h.subscribers()
+	}
+	return n
+}
+
+// HostLand starts a land for the stage, from its snapshot if it has one. a
+// land that is already here is left alone.
+func (a *Server) HostLand(req *share.HostLandRequest) error {
+	if req.Name == "" {
+		return errors.New("land without a name")
+	}
+
+	a.landsLock.Lock()
+	defer a.landsLock.Unlock()
+
+	if _, ok := a.lands[req.Name]; ok {
+		return nil
+	}
+
+	landConfig := land.Config{
+		Seed:       req.Seed,
+		Row:        req.Height,
+		Col:        req.Width,
+		Name:       req.Name,
+		Neighbours: req.Neighbours,
+		HandoffCh:  a.handoffCh,
+		Empty:      req.Empty,
+		Portals:    req.Portals,
+	}
+	if req.Snapshot != nil {
+		landConfig.Restore = land.SnapshotFromShare(req.Snapshot)
+	}
+	h := newHostedLand(req.Name, &landConfig, a.config.EventBufferSize)
+	h.start()
+	a.lands[req.Name] = h
+
+	log.Info(fmt.Sprintf("hosting land %s from tick %d", req.Name, h.land.Tick()))
+	return nil
+}
+
+// Possess hands a character of a land over to a player.
+func (a *Server) Possess(name string, character string) error {
+	h, err := a.hosted(name)
+	if err != nil {
+		return err
+	}
+	if err := h.land.Possess(character); err != nil {
+		return err
+	}
+
+	log.Info(fmt.Sprintf("%s of land %q is possessed", character, name))
+	return nil
+}
+
+// Release gives a character back to its land, the land may be gone already.
+func (a *Server) Release(name string, character string) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return
 	}
+	h.land.Release(character)
 
-	return
+	log.Info(fmt.Sprintf("%s of land %q is released", character, name))
+}
+
+// Move steps a possessed character, and tells the land it went to if it
+// left.
+func (a *Server) Move(name string, character string, dir share.MoveDirection) (share.Point, string, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return share.Point{}, "", err
+	}
+	return h.land.Move(character, dir)
+}
+
+// AddPortal puts a portal in a land of the server.
+func (a *Server) AddPortal(name string, p share.Portal) error {
+	h, err := a.hosted(name)
+	if err != nil {
+		return err
+	}
+	h.land.AddPortal(p)
+
+	log.Info(fmt.Sprintf("portal at %v of land %s leads to %s", p.P, name, p.To))
+	return nil
+}
+
+// DropLand stops a land, its streams end.
+func (a *Server) DropLand(name string) error {
+	if name == "" {
+		return errors.New("can not drop the land of the server")
+	}
+
+	a.landsLock.Lock()
+	h, ok := a.lands[name]
+	delete(a.lands, name)
+	a.landsLock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown land: %s", name)
+	}
+	h.stop()
+
+	log.Info(fmt.Sprintf("dropped land %s", name))
+	return nil
+}
+
+// hostedLands are the names of the lands hosted for the stage.
+func (a *Server) hostedLands() []string {
+	a.landsLock.RLock()
+	defer a.landsLock.RUnlock()
+
+	var names []string
+	for name := range a.lands {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SaveLands hands the stage a snapshot of every land hosted for it, so it can
+// be moved when this server fails.
+func (a *Server) SaveLands() error {
+	return a.saveLands(context.Background())
+}
+
+// saveLands saves the lands until ctx is done. it does not hold stageLock
+// meanwhile, a slow stage must not keep LeaveStage waiting.
+func (a *Server) saveLands(parent context.Context) error {
+	a.stageLock.Lock()
+	stageClient := a.stageClient
+	left := a.leftStage
+	a.stageLock.Unlock()
+
+	if left || stageClient == nil {
+		return nil
+	}
+
+	for _, name := range a.hostedLands() {
+		h, err := a.hosted(name)
+		if err != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(parent, a.config.StageTimeout)
+		request := share.SaveLandRequest{
+			Name:     name,
+			Snapshot: *h.land.Snapshot().Share(),
+		}
+		_, err = stageClient.SaveLandCtx(ctx, &request)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newID tells apart servers that come back on the same address.
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sync subscribes eh and returns a snapshot of the land. eh is subscribed
+// before the snapshot is taken, so no event after snapshot.Seq is missed, but
+// eh may also get events up to snapshot.Seq which it should skip.
+func (a *Server) Sync(name string, eh EventHandler) (*land.Snapshot, string, error) {
+	h, err := a.hosted(name)
+	if err != nil {
+		return nil, "", err
+	}
+	h.subscribe(eh)
+
+	return h.land.Snapshot(), h.id, nil
 }
 
 func (a *Server) ConnectStage() bool {
+	// the stage may restart, keep dialing it, or the other stages, instead of
+	// giving up.
 	stageConfig := client.Config{
-		Addr:    a.config.StageAddr,
-		Timeout: a.config.StageTimeout,
+		Addr:      a.config.StageAddrs[0],
+		Addrs:     a.config.StageAddrs[1:],
+		Timeout:   a.config.StageTimeout,
+		Reconnect: true,
 	}
 
 	stageClient, err := client.ClientFromConfig(&stageConfig)
@@ -115,26 +422,65 @@ func (a *Server) ConnectStage() bool {
 	return err == nil
 }
 
-func (a *Server) ReportStage() {
-	respCh := make(chan string)
-	log.Debug("Report To Stage, times: ", a.reportTimes)
+// DisconnectStage closes the stage client for good.
+func (a *Server) DisconnectStage() {
+	if a.stageClient != nil {
+		a.stageClient.Close()
+	}
+}
 
-	a.stageClient.ServerAlive(a.config.ServerAddr, respCh)
-	<-respCh
+// LeaveStage tells the stage we are going, so it does not wait for the
+// reports to stop. it is done only once.
+func (a *Server) LeaveStage() error {
+	a.stageLock.Lock()
+	defer a.stageLock.Unlock()
 
-	a.reportTimes++
+	if a.leftStage || a.stageClient == nil {
+		return nil
+	}
+	a.leftStage = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.StageTimeout)
+	defer cancel()
+
+	request := share.ServerLeaveRequest{
+		ServerAddr: a.config.ServerAddr,
+	}
+	_, err := a.stageClient.ServerLeaveCtx(ctx, &request)
+	return err
 }
 
-func (a *Server) eventLoop() {
-	for {
-		select {
-		case event := <-a.eventCh:
-			// get Event from land, fan out
-			log.Debug(fmt.Sprintf("in server eventLoop, get event :%v", event))
+func (a *Server) ReportStage() error {
+	a.stageLock.Lock()
+	defer a.stageLock.Unlock()
 
-			for _, eh := range a.eventHandlerList {
-				eh.Handle(event)
-			}
-		}
+	if a.leftStage {
+		return nil
+	}
+
+	log.Debug("Report To Stage, times: ", a.reportTimes)
+
+	// do not hang when the stage is gone.
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.StageTimeout)
+	defer cancel()
+
+	width, height := a.land.Size()
+	request := share.ServerAliveRequest{
+		ServerAddr: a.config.ServerAddr,
+		Name:       a.name,
+		ID:         a.id,
+		Tags:       a.config.Tags,
+		Width:      width,
+		Height:     height,
+		Population: a.land.Population(),
+		Load:       a.subscribers(),
+		Lands:      a.hostedLands(),
+	}
+	if _, err := a.stageClient.ServerAliveCtx(ctx, &request); err != nil {
+		log.Error(fmt.Sprintf("can not report to stage: %s", err))
+		return err
 	}
+
+	a.reportTimes++
+	return nil
 }
@@ -0,0 +1,196 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventHandler gets the events of every land of every server, with Server
+// and Land set. HandleEvent must not block.
+type EventHandler interface {
+	HandleEvent(event share.EventResponseObj)
+}
+
+// the bus looks for lands to attach to on every membership change, and every
+// DefaultBusInterval for streams that ended.
+var DefaultBusInterval = 5 * time.Second
+var DefaultBusTimeout = 5 * time.Second
+
+// busKey is one land of one server, "" for the land of the server itself.
+type busKey struct {
+	addr string
+	land string
+}
+
+// busSource is the stream of events of one land.
+type busSource struct {
+	key    busKey
+	client *client.RPCClient
+	stream *client.EventStream
+}
+
+// SubscribeEvents attaches the bus to every land if h is the first handler.
+func (a *Stage) SubscribeEvents(h EventHandler) {
+	a.busLock.Lock()
+	a.eventHandlers[h] = struct{}{}
+	a.busLock.Unlock()
+
+	a.kickBus()
+}
+
+// UnsubscribeEvents detaches the bus from every land if h was the last one.
+func (a *Stage) UnsubscribeEvents(h EventHandler) {
+	a.busLock.Lock()
+	delete(a.eventHandlers, h)
+	a.busLock.Unlock()
+
+	a.kickBus()
+}
+
+// kickBus never blocks, emitMember calls it with the table locked.
+func (a *Stage) kickBus() {
+	select {
+	case a.busKickCh <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Stage) busLoop() {
+	ticker := time.NewTicker(DefaultBusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.busKickCh:
+		case <-ticker.C:
+		}
+		a.attachBus()
+	}
+}
+
+// attachBus subscribes to the lands of the alive and suspect servers that
+// have no stream yet, and stops the streams of the others. with nobody
+// listening there is no stream at all.
+func (a *Stage) attachBus() {
+	a.busLock.Lock()
+	listening := len(a.eventHandlers) > 0
+	a.busLock.Unlock()
+
+	want := make(map[busKey]bool)
+	if listening {
+		a.serverState.l.RLock()
+		for addr, m := range a.serverState.members {
+			if m.status != share.MemberAlive && m.status != share.MemberSuspect {
+				continue
+			}
+			want[busKey{addr: addr}] = true
+			for _, name := range m.report.Lands {
+				want[busKey{addr: addr, land: name}] = true
+			}
+		}
+		a.serverState.l.RUnlock()
+	}
+
+	var detach []*busSource
+	var missing []busKey
+	a.busLock.Lock()
+	for key, src := range a.sources {
+		if !want[key] {
+			detach = append(detach, src)
+			delete(a.sources, key)
+		}
+	}
+	for key := range want {
+		if _, ok := a.sources[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	a.busLock.Unlock()
+
+	for _, src := range detach {
+		log.Info(fmt.Sprintf("bus detached from land %q of server %s", src.key.land, src.key.addr))
+		src.stream.Close()
+	}
+	for _, key := range missing {
+		src, err := a.busSubscribe(key)
+		if err != nil {
+			log.Debug(fmt.Sprintf("bus can not attach to land %q of server %s: %s", key.land, key.addr, err))
+			continue
+		}
+		log.Info(fmt.Sprintf("bus attached to land %q of server %s", key.land, key.addr))
+
+		a.busLock.Lock()
+		a.sources[key] = src
+		a.busLock.Unlock()
+
+		go a.busPump(src)
+	}
+}
+
+func (a *Stage) busSubscribe(key busKey) (*busSource, error) {
+	config := client.Config{
+		Addr:    key.addr,
+		Timeout: DefaultBusTimeout,
+	}
+	cl, err := client.ClientFromConfig(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultBusTimeout)
+	defer cancel()
+
+	// the stage would rather miss some events than all of them.
+	stream, err := cl.SubscribeCtx(ctx, &share.SubscribeRequest{
+		Land:   key.land,
+		Policy: share.PolicyDropOldest,
+	})
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+
+	src := busSource{
+		key:    key,
+		client: cl,
+		stream: stream,
+	}
+	return &src, nil
+}
+
+// busPump passes the events of src on until its stream ends. the land is
+// attached again on the next look if it is still there.
+func (a *Stage) busPump(src *busSource) {
+	for {
+		event, err := src.stream.Next()
+		if err != nil {
+			log.Debug(fmt.Sprintf("bus stream of land %q of server %s ended: %s", src.key.land, src.key.addr, err))
+			break
+		}
+		event.Server = src.key.addr
+		event.Land = src.key.land
+		a.publish(*event)
+	}
+
+	a.busLock.Lock()
+	if a.sources[src.key] == src {
+		delete(a.sources, src.key)
+	}
+	a.busLock.Unlock()
+	src.client.Close()
+}
+
+func (a *Stage) publish(event share.EventResponseObj) {
+	a.busLock.Lock()
+	defer a.busLock.Unlock()
+
+	for h := range a.eventHandlers {
+		h.HandleEvent(event)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,18 +22,58 @@ type Command struct {
 type Config struct {
 	Name    string
 	RPCAddr string
+
+	// StateFile keeps the membership table over restarts, empty for none.
+	StateFile string
+
+	// Peers are the other stages of the cluster. every ReplicateInterval the
+	// stage sends them its membership table and merges theirs.
+	Peers             []string
+	ReplicateInterval time.Duration
+
+	// Lands is the catalogue, the stage places each land on a server. the
+	// stages of a cluster should have the same one.
+	Lands []LandSpec
+
+	// the stage pings every alive server each HealthInterval, 0 turns the
+	// checks off. a server is unhealthy after HealthFailures pings in a row
+	// failed or took longer than HealthTimeout, or when its tick did not
+	// move for StallTimeout.
+	HealthInterval time.Duration
+	HealthTimeout  time.Duration
+	HealthFailures int
+	StallTimeout   time.Duration
 }
 
+var DefaultReplicateInterval = 1 * time.Second
+
+var DefaultHealthInterval = 2 * time.Second
+var DefaultHealthTimeout = 1 * time.Second
+var DefaultHealthFailures = 3
+var DefaultStallTimeout = 10 * time.Second
+
 func (c *Command) readConfig(args []string) *Config {
 	cmdFlags := flag.NewFlagSet("stage", flag.ContinueOnError)
 
 	var rpcAddr string
+	var stateFile string
+	var peers string
+	var lands landsFlag
 	var nodeName string
 	var debug bool
+	var healthInterval, healthTimeout, stallTimeout time.Duration
+	var healthFailures int
 	cmdFlags.Usage = func() { c.Ui.Output(c.Help()) }
 	cmdFlags.StringVar(&rpcAddr, "rpc-addr", "127.0.0.1:9898", "rpc ip:port to listen")
 	cmdFlags.StringVar(&nodeName, "name", "beauty", "name of node")
+	cmdFlags.StringVar(&stateFile, "state-file", "./stage-state.json", "file to keep the servers in, empty for none")
+	cmdFlags.StringVar(&peers, "peers", "", "rpc ip:port of the other stages, comma separated")
+	cmdFlags.Var(&lands, "land", "name, name=WIDTHxHEIGHT or name=WIDTHxHEIGHT@X,Y on the world map, of a land to place on a server, may be repeated")
 	cmdFlags.BoolVar(&debug, "debug", true, "debug mode")
+	cmdFlags.DurationVar(&healthInterval, "health-interval", DefaultHealthInterval, "how often to ping every server, 0 for never")
+	cmdFlags.DurationVar(&healthTimeout, "health-timeout", DefaultHealthTimeout, "how long a ping may take")
+	cmdFlags.IntVar(&healthFailures, "health-failures", DefaultHealthFailures, "failed pings in a row before a server is unhealthy")
+	cmdFlags.DurationVar(&stallTimeout, "stall-timeout", DefaultStallTimeout, "how long the tick of a server may stand still")
 
 	if err := cmdFlags.Parse(args); err != nil {
 		log.Fatalf("can not parse args: %s", err.Error())
@@ -45,13 +86,49 @@ func (c *Command) readConfig(args []string) *Config {
 	}
 
 	config := Config{
-		Name:    nodeName,
-		RPCAddr: rpcAddr,
+		Name:      nodeName,
+		RPCAddr:   rpcAddr,
+		StateFile: stateFile,
+
+		ReplicateInterval: DefaultReplicateInterval,
+		Lands:             lands,
+
+		HealthInterval: healthInterval,
+		HealthTimeout:  healthTimeout,
+		HealthFailures: healthFailures,
+		StallTimeout:   stallTimeout,
+	}
+
+	if peers != "" {
+		config.Peers = strings.Split(peers, ",")
+	}
+	if err := CheckWorld(config.Lands); err != nil {
+		log.Fatalf("bad world map: %s", err)
 	}
 
 	return &config
 }
 
+// landsFlag collects repeated -land flags.
+type landsFlag []LandSpec
+
+func (l *landsFlag) String() string {
+	var names []string
+	for _, spec := range *l {
+		names = append(names, spec.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *landsFlag) Set(value string) error {
+	spec, err := ParseLandSpec(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, spec)
+	return nil
+}
+
 func (c *Command) createStage(config *Config) *Stage {
 	stage := Create(config)
 	return stage
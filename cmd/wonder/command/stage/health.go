@@ -0,0 +1,180 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// health is what the checks found out about a member, guarded by
+// serverState.l like the rest of it.
+type health struct {
+	status   string
+	failures int
+	rtt      time.Duration
+
+	// id tells a restarted server apart, its tick starts over.
+	id     string
+	tick   uint64
+	tickAt time.Time
+
+	// stuck are the lands that did not answer the last probe in time, the
+	// home land is "".
+	stuck []string
+}
+
+func (a *Stage) checkHealth() {
+	for {
+		time.Sleep(a.config.HealthInterval)
+		a.probeServers()
+	}
+}
+
+// probeServers pings every alive or suspect server at once, and hangs up on
+// the others.
+func (a *Stage) probeServers() {
+	addrs := make(map[string]bool)
+
+	a.serverState.l.RLock()
+	for addr, m := range a.serverState.members {
+		if m.status == share.MemberAlive || m.status == share.MemberSuspect {
+			addrs[addr] = true
+		}
+	}
+	a.serverState.l.RUnlock()
+
+	var wg sync.WaitGroup
+	for addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			a.probe(addr)
+		}(addr)
+	}
+	wg.Wait()
+
+	a.healthLock.Lock()
+	for addr, cl := range a.healthClients {
+		if !addrs[addr] {
+			cl.Close()
+			delete(a.healthClients, addr)
+		}
+	}
+	a.healthLock.Unlock()
+}
+
+func (a *Stage) probe(addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.HealthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := a.ping(ctx, addr)
+	rtt := time.Since(start)
+
+	if err != nil {
+		log.Debug(fmt.Sprintf("can not ping server %s: %s", addr, err))
+		a.dropHealthClient(addr)
+	}
+	a.recordProbe(addr, resp, rtt, err, time.Now())
+}
+
+func (a *Stage) ping(ctx context.Context, addr string) (*share.PingResponse, error) {
+	a.healthLock.Lock()
+	cl, ok := a.healthClients[addr]
+	a.healthLock.Unlock()
+
+	if !ok {
+		config := client.Config{
+			Addr:    addr,
+			Timeout: a.config.HealthTimeout,
+		}
+		var err error
+		cl, err = client.ClientFromConfig(&config)
+		if err != nil {
+			return nil, err
+		}
+
+		a.healthLock.Lock()
+		a.healthClients[addr] = cl
+		a.healthLock.Unlock()
+	}
+
+	return cl.PingCtx(ctx)
+}
+
+func (a *Stage) dropHealthClient(addr string) {
+	a.healthLock.Lock()
+	defer a.healthLock.Unlock()
+
+	if cl, ok := a.healthClients[addr]; ok {
+		cl.Close()
+		delete(a.healthClients, addr)
+	}
+}
+
+func (a *Stage) recordProbe(addr string, resp *share.PingResponse, rtt time.Duration, err error, now time.Time) {
+	a.serverState.l.Lock()
+	defer a.serverState.l.Unlock()
+
+	m, ok := a.serverState.members[addr]
+	if !ok {
+		return
+	}
+	h := &m.health
+
+	stuck := h.stuck
+	if err != nil {
+		h.failures++
+	} else {
+		h.failures = 0
+		h.rtt = rtt
+		if resp.ID != h.id || resp.Tick != h.tick {
+			h.id = resp.ID
+			h.tick = resp.Tick
+			h.tickAt = now
+		}
+		stuck = nil
+		for _, l := range resp.Lands {
+			if l.Stuck {
+				stuck = append(stuck, l.Name)
+			}
+		}
+	}
+
+	status := share.MemberHealthy
+	if h.failures >= a.config.HealthFailures || len(stuck) > 0 {
+		status = share.MemberUnhealthy
+	} else if !h.tickAt.IsZero() && now.Sub(h.tickAt) > a.config.StallTimeout {
+		status = share.MemberUnhealthy
+	}
+	if h.tickAt.IsZero() && status == share.MemberHealthy {
+		// not answered once yet.
+		return
+	}
+
+	if status != h.status || !sameLands(stuck, h.stuck) {
+		log.Info(fmt.Sprintf("server %s: %s, tick %d, rtt %s, %d failed pings, stuck lands %q",
+			addr, status, h.tick, h.rtt, h.failures, stuck))
+		h.status = status
+		h.stuck = stuck
+		a.emitMember(share.MemberEventUpdate, addr, m)
+	}
+}
+
+func sameLands(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
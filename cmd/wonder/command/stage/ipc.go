@@ -6,6 +6,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/nickelchen/wonder/share"
 
@@ -21,10 +22,25 @@ type IPCClient struct {
 	writer *bufio.Writer
 	dec    *codec.Decoder
 	enc    *codec.Encoder
+	hooks  *Hooks
+
+	// member and event streams, only touched by handleClient.
+	streams map[uint64]responseStream
+
+	// streams write to the same connection from their own goroutines.
+	writeLock sync.Mutex
 }
 
 // send share.ResponseHeader and responseBody to client.
 func (c *IPCClient) send(header *share.ResponseHeader, obj interface{}) error {
+	if err := c.hookSend(header); err != nil {
+		c.conn.Close()
+		return err
+	}
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
 	if err := c.enc.Encode(header); err != nil {
 		log.Error(fmt.Sprintf("Error in encode header: %s", err))
 		log.Error(trace())
@@ -47,18 +63,31 @@ func (c *IPCClient) send(header *share.ResponseHeader, obj interface{}) error {
 	return nil
 }
 
+// responseStream is a long running stream to one client, it lives until the
+// client stops it or goes away.
+type responseStream interface {
+	stop()
+}
+
 type StageIPC struct {
-	stage    *Stage
-	listener net.Listener
-	clients  map[string]*IPCClient
-	stop     bool
+	stage       *Stage
+	listener    net.Listener
+	clients     map[string]*IPCClient
+	clientsLock sync.Mutex
+	stop        bool
+	hooks       *Hooks
 }
 
 func NewStageIPC(stage *Stage, listener net.Listener) *StageIPC {
+	return NewStageIPCWithHooks(stage, listener, nil)
+}
+
+func NewStageIPCWithHooks(stage *Stage, listener net.Listener, hooks *Hooks) *StageIPC {
 	ipc := &StageIPC{
 		stage:    stage,
 		listener: listener,
 		clients:  make(map[string]*IPCClient),
+		hooks:    hooks,
 	}
 
 	go ipc.listen()
@@ -73,6 +102,10 @@ func (i *StageIPC) Shutdown() {
 	i.stop = true
 
 	i.listener.Close()
+
+	i.clientsLock.Lock()
+	defer i.clientsLock.Unlock()
+
 	for _, c := range i.clients {
 		c.conn.Close()
 	}
@@ -89,13 +122,18 @@ func (i *StageIPC) listen() {
 			conn:   conn,
 			reader: bufio.NewReader(conn),
 			writer: bufio.NewWriter(conn),
+
+			streams: make(map[uint64]responseStream),
 		}
+		client.hooks = i.hooks
 		client.dec = codec.NewDecoder(client.reader,
 			&codec.MsgpackHandle{RawToString: true, WriteExt: true})
 		client.enc = codec.NewEncoder(client.writer,
 			&codec.MsgpackHandle{RawToString: true, WriteExt: true})
 
+		i.clientsLock.Lock()
 		i.clients[client.from] = client
+		i.clientsLock.Unlock()
 
 		go i.handleClient(client)
 	}
@@ -105,6 +143,8 @@ func (i *StageIPC) listen() {
 func (i *StageIPC) handleClient(client *IPCClient) {
 	log.Debug(fmt.Sprintf("Get client. %v", client))
 
+	defer i.stopStreams(client)
+
 	var reqHeader share.RequestHeader
 	for {
 		err := client.dec.Decode(&reqHeader)
@@ -125,11 +165,36 @@ func (i *StageIPC) handleClient(client *IPCClient) {
 		command := reqHeader.Command
 		log.Debug(fmt.Sprintf("reqHeader.Command: %v", command))
 
+		if respHeader, respBody = client.hookRequest(command, reqHeader.Seq); respHeader != nil {
+			client.send(respHeader, respBody)
+			continue
+		}
+
 		switch command {
+		case share.HandshakeCommand:
+			respHeader, respBody = i.handleHandshake(client, reqHeader.Seq)
 		case share.ListServersCommand:
 			respHeader, respBody = i.handleListServers(client, reqHeader.Seq)
 		case share.ServerAliveCommand:
 			respHeader, respBody = i.handleServerAlive(client, reqHeader.Seq)
+		case share.ServerLeaveCommand:
+			respHeader, respBody = i.handleServerLeave(client, reqHeader.Seq)
+		case share.ReplicateCommand:
+			respHeader, respBody = i.handleReplicate(client, reqHeader.Seq)
+		case share.SaveLandCommand:
+			respHeader, respBody = i.handleSaveLand(client, reqHeader.Seq)
+		case share.LocateLandCommand:
+			respHeader, respBody = i.handleLocateLand(client, reqHeader.Seq)
+		case share.WorldCommand:
+			respHeader, respBody = i.handleWorld(client, reqHeader.Seq)
+		case share.CreatePortalCommand:
+			respHeader, respBody = i.handleCreatePortal(client, reqHeader.Seq)
+		case share.SubscribeCommand:
+			respHeader, respBody = i.handleSubscribe(client, reqHeader.Seq)
+		case share.SubscribeMembersCommand:
+			respHeader, respBody = i.handleSubscribeMembers(client, reqHeader.Seq)
+		case share.StopCommand:
+			respHeader, respBody = i.handleStop(client, reqHeader.Seq)
 		}
 
 		log.Debug(fmt.Sprintf("respHeader is :%v", respHeader))
@@ -147,14 +212,14 @@ func (i *StageIPC) handleListServers(client *IPCClient, seq uint64) (*share.Resp
 		return nil, nil
 	}
 
-	servers, err := i.stage.ListServers()
+	members, err := i.stage.ListServers(req.All, req.Tags)
 	respHeader := share.ResponseHeader{
 		Seq:   seq,
 		Error: errorToString(err),
 	}
 
 	respBody := share.ListServersResponse{
-		Servers: servers,
+		Members: members,
 	}
 
 	return &respHeader, &respBody
@@ -166,7 +231,7 @@ func (i *StageIPC) handleServerAlive(client *IPCClient, seq uint64) (*share.Resp
 		return nil, nil
 	}
 
-	msg, err := i.stage.ServerAlive(req.ServerAddr)
+	msg, err := i.stage.ServerAlive(&req)
 
 	respHeader := share.ResponseHeader{
 		Seq:   seq,
@@ -180,6 +245,205 @@ func (i *StageIPC) handleServerAlive(client *IPCClient, seq uint64) (*share.Resp
 	return &respHeader, &respBody
 }
 
+func (i *StageIPC) handleServerLeave(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.ServerLeaveResponse) {
+	var req share.ServerLeaveRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.stage.ServerLeave(req.ServerAddr)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.ServerLeaveResponse{}
+}
+
+func (i *StageIPC) handleReplicate(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.ReplicateResponse) {
+	var req share.ReplicateRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	i.stage.Replicate(req.Stage, req.Members, req.Lands, req.Portals)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+
+	return &respHeader, &share.ReplicateResponse{}
+}
+
+func (i *StageIPC) handleSaveLand(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.SaveLandResponse) {
+	var req share.SaveLandRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.stage.SaveLand(req.Name, &req.Snapshot)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.SaveLandResponse{}
+}
+
+func (i *StageIPC) handleLocateLand(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.LocateLandResponse) {
+	var req share.LocateLandRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	placement, err := i.stage.LocateLand(req.Name)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.LocateLandResponse{Land: placement}
+}
+
+func (i *StageIPC) handleCreatePortal(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.CreatePortalResponse) {
+	var req share.CreatePortalRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	err := i.stage.CreatePortal(req.From, req.To)
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: errorToString(err),
+	}
+
+	return &respHeader, &share.CreatePortalResponse{}
+}
+
+func (i *StageIPC) handleWorld(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.WorldResponse) {
+	var req share.WorldRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+
+	return &respHeader, &share.WorldResponse{Lands: i.stage.World()}
+}
+
+func (i *StageIPC) handleSubscribeMembers(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.SubscribeMembersResponse) {
+	var req share.SubscribeMembersRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	if _, ok := client.streams[seq]; ok {
+		respHeader.Error = "stream with seq already exists"
+		return &respHeader, &share.SubscribeMembersResponse{}
+	}
+
+	// the stream goroutine sends from now on, the first response included.
+	s := newMemberResponseStream(client, seq)
+	client.streams[seq] = s
+	i.stage.SubscribeMembers(s)
+	client.send(&respHeader, &share.SubscribeMembersResponse{})
+
+	go func() {
+		s.stream()
+		i.stage.UnsubscribeMembers(s)
+	}()
+
+	return nil, nil
+}
+
+func (i *StageIPC) handleSubscribe(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.SubscribeResponse) {
+	var req share.SubscribeRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	if _, ok := client.streams[seq]; ok {
+		respHeader.Error = "stream with seq already exists"
+		return &respHeader, &share.SubscribeResponse{}
+	}
+
+	// the stream goroutine sends from now on, the first response included.
+	s := newEventResponseStream(client, seq, &req)
+	client.streams[seq] = s
+	i.stage.SubscribeEvents(s)
+	client.send(&respHeader, &share.SubscribeResponse{})
+
+	go func() {
+		s.stream()
+		i.stage.UnsubscribeEvents(s)
+	}()
+
+	return nil, nil
+}
+
+func (i *StageIPC) handleStop(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.StopResponse) {
+	var req share.StopRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	// stopping a stream that is already gone is fine. it unsubscribes when
+	// it is done.
+	if s, ok := client.streams[req.Seq]; ok {
+		delete(client.streams, req.Seq)
+		s.stop()
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+
+	return &respHeader, &share.StopResponse{}
+}
+
+// stopStreams ends the streams of a client that went away.
+func (i *StageIPC) stopStreams(client *IPCClient) {
+	for seq, s := range client.streams {
+		delete(client.streams, seq)
+		s.stop()
+	}
+}
+
+func (i *StageIPC) handleHandshake(client *IPCClient, seq uint64) (*share.ResponseHeader, *share.HandshakeResponse) {
+	var req share.HandshakeRequest
+	if err := client.dec.Decode(&req); err != nil {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: "",
+	}
+	if req.Version != share.ProtocolVersion {
+		respHeader.Error = fmt.Sprintf("unsupported protocol version %d, want %d",
+			req.Version, share.ProtocolVersion)
+	}
+
+	return &respHeader, &share.HandshakeResponse{}
+}
+
 func errorToString(err error) string {
 	if err == nil {
 		return ""
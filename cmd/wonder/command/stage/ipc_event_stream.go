@@ -0,0 +1,96 @@
+package stage
+
+import (
+	"sync"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// DefaultEventQueueSize is how many events of the bus wait for a slow
+// subscriber before its stream is closed.
+var DefaultEventQueueSize = 1024
+
+type eventResponseStream struct {
+	client *IPCClient
+	seq    uint64
+
+	// only the events of these lands and types, all if nil.
+	lands map[string]bool
+	types map[string]bool
+
+	eventCh  chan share.EventResponseObj
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	slow     bool
+}
+
+func newEventResponseStream(client *IPCClient, seq uint64, req *share.SubscribeRequest) *eventResponseStream {
+	s := eventResponseStream{
+		client:  client,
+		seq:     seq,
+		lands:   toSet(req.Lands),
+		types:   toSet(req.Types),
+		eventCh: make(chan share.EventResponseObj, DefaultEventQueueSize),
+		stopCh:  make(chan struct{}),
+	}
+	return &s
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// HandleEvent never blocks the bus, a subscriber that can not keep up is
+// dropped.
+func (s *eventResponseStream) HandleEvent(event share.EventResponseObj) {
+	if s.lands != nil && !s.lands[event.Land] {
+		return
+	}
+	if s.types != nil && !s.types[event.Type] {
+		return
+	}
+
+	select {
+	case s.eventCh <- event:
+	default:
+		s.stopOnce.Do(func() {
+			s.slow = true
+			close(s.stopCh)
+		})
+	}
+}
+
+func (s *eventResponseStream) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *eventResponseStream) stream() {
+	respHeader := share.ResponseHeader{
+		Seq:   s.seq,
+		Error: "",
+	}
+	for {
+		select {
+		case event := <-s.eventCh:
+			if err := s.client.send(&respHeader, &event); err != nil {
+				return
+			}
+		case <-s.stopCh:
+			// slow is written before stopCh is closed.
+			if s.slow {
+				respHeader.Error = errSlowConsumer.Error()
+				s.client.send(&respHeader, &share.SubscribeResponse{})
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package stage
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// DefaultMemberQueueSize is how many member events wait for a slow
+// subscriber before its stream is closed.
+var DefaultMemberQueueSize = 256
+
+var errSlowConsumer = errors.New(share.ErrSlowConsumer)
+
+type memberResponseStream struct {
+	client *IPCClient
+	seq    uint64
+
+	eventCh  chan share.MemberEvent
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	slow     bool
+}
+
+func newMemberResponseStream(client *IPCClient, seq uint64) *memberResponseStream {
+	s := memberResponseStream{
+		client:  client,
+		seq:     seq,
+		eventCh: make(chan share.MemberEvent, DefaultMemberQueueSize),
+		stopCh:  make(chan struct{}),
+	}
+	return &s
+}
+
+// HandleMember never blocks the stage, a subscriber that can not keep up
+// is dropped.
+func (s *memberResponseStream) HandleMember(event share.MemberEvent) {
+	select {
+	case s.eventCh <- event:
+	default:
+		s.stopOnce.Do(func() {
+			s.slow = true
+			close(s.stopCh)
+		})
+	}
+}
+
+func (s *memberResponseStream) stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *memberResponseStream) stream() {
+	respHeader := share.ResponseHeader{
+		Seq:   s.seq,
+		Error: "",
+	}
+	for {
+		select {
+		case event := <-s.eventCh:
+			if err := s.client.send(&respHeader, &event); err != nil {
+				return
+			}
+		case <-s.stopCh:
+			// slow is written before stopCh is closed.
+			if s.slow {
+				respHeader.Error = errSlowConsumer.Error()
+				s.client.send(&respHeader, &share.SubscribeMembersResponse{})
+			}
+			return
+		}
+	}
+}
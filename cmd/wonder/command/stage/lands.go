@@ -0,0 +1,429 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a server asked to host a land has DefaultPlaceTimeout to report it, then
+// the land is placed again.
+var DefaultPlaceTimeout = 10 * time.Second
+var PlaceLandsInterval = 1 * time.Second
+
+// LandSpec is a land of the catalogue. Width and Height of 0 leave it to the
+// server. a land of the world map is at region X, Y of it.
+type LandSpec struct {
+	Name   string
+	Width  int
+	Height int
+
+	World bool
+	X     int
+	Y     int
+}
+
+// ParseLandSpec reads name, name=WIDTHxHEIGHT or, for a land of the world
+// map, name=WIDTHxHEIGHT@X,Y.
+func ParseLandSpec(value string) (LandSpec, error) {
+	kv := strings.SplitN(value, "=", 2)
+	spec := LandSpec{Name: kv[0]}
+	if spec.Name == "" {
+		return spec, fmt.Errorf("land without a name: %s", value)
+	}
+	if len(kv) == 1 {
+		return spec, nil
+	}
+
+	size := kv[1]
+	if at := strings.Index(size, "@"); at >= 0 {
+		xy := strings.SplitN(size[at+1:], ",", 2)
+		size = size[:at]
+		if len(xy) != 2 {
+			return spec, fmt.Errorf("land place should be X,Y: %s", value)
+		}
+		var errX, errY error
+		spec.X, errX = strconv.Atoi(xy[0])
+		spec.Y, errY = strconv.Atoi(xy[1])
+		if errX != nil || errY != nil {
+			return spec, fmt.Errorf("bad land place: %s", value)
+		}
+		spec.World = true
+	}
+
+	wh := strings.SplitN(size, "x", 2)
+	if len(wh) != 2 {
+		return spec, fmt.Errorf("land size should be WIDTHxHEIGHT: %s", value)
+	}
+	var err error
+	if spec.Width, err = strconv.Atoi(wh[0]); err != nil || spec.Width <= 0 {
+		return spec, fmt.Errorf("bad land width: %s", value)
+	}
+	if spec.Height, err = strconv.Atoi(wh[1]); err != nil || spec.Height <= 0 {
+		return spec, fmt.Errorf("bad land height: %s", value)
+	}
+	return spec, nil
+}
+
+// CheckWorld makes sure the lands of the world map fit together: all of the
+// same size, one on each region.
+func CheckWorld(specs []LandSpec) error {
+	var first *LandSpec
+	taken := make(map[[2]int]string)
+	for i := range specs {
+		spec := &specs[i]
+		if !spec.World {
+			continue
+		}
+		if first == nil {
+			first = spec
+		}
+		if spec.Width != first.Width || spec.Height != first.Height {
+			return fmt.Errorf("lands %s and %s of the world have different sizes", first.Name, spec.Name)
+		}
+		xy := [2]int{spec.X, spec.Y}
+		if other, ok := taken[xy]; ok {
+			return fmt.Errorf("lands %s and %s are both at %d,%d", other, spec.Name, spec.X, spec.Y)
+		}
+		taken[xy] = spec.Name
+	}
+	return nil
+}
+
+// seed is the same on every stage, so a land that never ran looks the same
+// wherever it is first placed.
+func (spec LandSpec) seed() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(spec.Name))
+	return int64(h.Sum64() >> 1)
+}
+
+// catalogueLand is a land the stage keeps placed on some server.
+type catalogueLand struct {
+	spec LandSpec
+
+	// the latest snapshot a server saved, nil until the first one.
+	snapshot *share.LandSnapshot
+
+	// the server asked to host the land, until it reports it.
+	placing  string
+	placedAt time.Time
+}
+
+// neighbours are the lands of the world next to spec.
+func (a *Stage) neighbours(spec LandSpec) share.Neighbours {
+	var n share.Neighbours
+	if !spec.World {
+		return n
+	}
+	for _, cl := range a.lands {
+		other := cl.spec
+		if !other.World {
+			continue
+		}
+		switch {
+		case other.X == spec.X && other.Y == spec.Y-1:
+			n.Up = other.Name
+		case other.X == spec.X && other.Y == spec.Y+1:
+			n.Down = other.Name
+		case other.X == spec.X-1 && other.Y == spec.Y:
+			n.Left = other.Name
+		case other.X == spec.X+1 && other.Y == spec.Y:
+			n.Right = other.Name
+		}
+	}
+	return n
+}
+
+// firstOfWorld is where Alice starts, the top left land of the world. every
+// other land of it starts empty.
+func (a *Stage) firstOfWorld() string {
+	var first *LandSpec
+	for _, cl := range a.lands {
+		spec := cl.spec
+		if !spec.World {
+			continue
+		}
+		if first == nil || spec.Y < first.Y || (spec.Y == first.Y && spec.X < first.X) {
+			first = &spec
+		}
+	}
+	if first == nil {
+		return ""
+	}
+	return first.Name
+}
+
+func (cl *catalogueLand) placement() share.LandPlacement {
+	placement := share.LandPlacement{
+		Name:   cl.spec.Name,
+		Width:  cl.spec.Width,
+		Height: cl.spec.Height,
+		World:  cl.spec.World,
+		X:      cl.spec.X,
+		Y:      cl.spec.Y,
+	}
+	if cl.snapshot != nil {
+		placement.SavedTick = cl.snapshot.Tick
+		if tiles := cl.snapshot.Board.Tiles; placement.Width == 0 && len(tiles) > 0 {
+			placement.Width, placement.Height = len(tiles[0]), len(tiles)
+		}
+	}
+	return placement
+}
+
+// World is every land of the world map, where it is and which server has it.
+func (a *Stage) World() []share.LandPlacement {
+	hosts := a.landHosts()
+
+	a.landsLock.Lock()
+	defer a.landsLock.Unlock()
+
+	var lands []share.LandPlacement
+	for name, cl := range a.lands {
+		if !cl.spec.World {
+			continue
+		}
+		placement := cl.placement()
+		if addrs := hosts[name]; len(addrs) > 0 {
+			placement.ServerAddr = addrs[0]
+		}
+		lands = append(lands, placement)
+	}
+	sort.Slice(lands, func(i, j int) bool {
+		if lands[i].Y != lands[j].Y {
+			return lands[i].Y < lands[j].Y
+		}
+		return lands[i].X < lands[j].X
+	})
+	return lands
+}
+
+// SaveLand keeps the snapshot, unless the stage already has a later one.
+func (a *Stage) SaveLand(name string, snapshot *share.LandSnapshot) error {
+	a.landsLock.Lock()
+	defer a.landsLock.Unlock()
+
+	cl, ok := a.lands[name]
+	if !ok {
+		return fmt.Errorf("unknown land: %s", name)
+	}
+	if cl.snapshot != nil && cl.snapshot.Tick > snapshot.Tick {
+		return nil
+	}
+	cl.snapshot = snapshot
+	return nil
+}
+
+// LocateLand tells which server has the land. it is an error while the land
+// has none.
+func (a *Stage) LocateLand(name string) (share.LandPlacement, error) {
+	a.landsLock.Lock()
+	cl, ok := a.lands[name]
+	var placement share.LandPlacement
+	if ok {
+		placement = cl.placement()
+	}
+	a.landsLock.Unlock()
+
+	if !ok {
+		return placement, fmt.Errorf("unknown land: %s", name)
+	}
+
+	hosts := a.landHosts()[name]
+	if len(hosts) == 0 {
+		return placement, fmt.Errorf("land %s has no server yet", name)
+	}
+	placement.ServerAddr = hosts[0]
+	return placement, nil
+}
+
+// landHosts are the alive or suspect servers reporting each land, by address.
+func (a *Stage) landHosts() map[string][]string {
+	a.serverState.l.RLock()
+	defer a.serverState.l.RUnlock()
+
+	hosts := make(map[string][]string)
+	for addr, m := range a.serverState.members {
+		if m.status != share.MemberAlive && m.status != share.MemberSuspect {
+			continue
+		}
+		for _, name := range m.report.Lands {
+			hosts[name] = append(hosts[name], addr)
+		}
+	}
+	for _, addrs := range hosts {
+		sort.Strings(addrs)
+	}
+	return hosts
+}
+
+// landLoads is the load of every server a land may go to, the alive and
+// healthy ones. every land counts as one more subscriber.
+func (a *Stage) landLoads() map[string]int {
+	a.serverState.l.RLock()
+	defer a.serverState.l.RUnlock()
+
+	loads := make(map[string]int)
+	for addr, m := range a.serverState.members {
+		if m.status != share.MemberAlive || m.health.status == share.MemberUnhealthy {
+			continue
+		}
+		loads[addr] = m.report.Load + len(m.report.Lands)
+	}
+	return loads
+}
+
+func (a *Stage) placeLands() {
+	for {
+		time.Sleep(PlaceLandsInterval)
+		if a.isLeader() {
+			a.placeOnce(time.Now())
+		}
+	}
+}
+
+// placeOnce gives every land without a server to the least loaded one, and
+// takes a land from all but one server if several have it.
+func (a *Stage) placeOnce(now time.Time) {
+	hosts := a.landHosts()
+	loads := a.landLoads()
+
+	var hostOrders []share.HostLandRequest
+	var hostAddrs []string
+	dropOrders := make(map[string][]string)
+
+	a.landsLock.Lock()
+	first := a.firstOfWorld()
+	var names []string
+	for name, cl := range a.lands {
+		names = append(names, name)
+		if len(hosts[name]) == 0 && cl.placing != "" && now.Sub(cl.placedAt) < DefaultPlaceTimeout {
+			loads[cl.placing]++
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cl := a.lands[name]
+		switch addrs := hosts[name]; {
+		case len(addrs) > 1:
+			// the one we asked last keeps it, or else the first.
+			keep := addrs[0]
+			for _, addr := range addrs {
+				if addr == cl.placing {
+					keep = addr
+				}
+			}
+			for _, addr := range addrs {
+				if addr != keep {
+					dropOrders[addr] = append(dropOrders[addr], name)
+				}
+			}
+			cl.placing = ""
+		case len(addrs) == 1:
+			cl.placing = ""
+		default:
+			if cl.placing != "" && now.Sub(cl.placedAt) < DefaultPlaceTimeout {
+				continue
+			}
+			addr, ok := leastLoaded(loads)
+			if !ok {
+				continue
+			}
+			loads[addr]++
+			cl.placing = addr
+			cl.placedAt = now
+
+			hostOrders = append(hostOrders, share.HostLandRequest{
+				Name:       name,
+				Seed:       cl.spec.seed(),
+				Width:      cl.spec.Width,
+				Height:     cl.spec.Height,
+				Snapshot:   cl.snapshot,
+				Neighbours: a.neighbours(cl.spec),
+				Empty:      cl.spec.World && name != first,
+				Portals:    a.portalsIn(name),
+			})
+			hostAddrs = append(hostAddrs, addr)
+		}
+	}
+	a.landsLock.Unlock()
+
+	for i, req := range hostOrders {
+		addr := hostAddrs[i]
+		log.Info(fmt.Sprintf("placing land %s on server %s", req.Name, addr))
+		if err := a.hostLand(addr, &req); err != nil {
+			log.Error(fmt.Sprintf("can not place land %s on server %s: %s", req.Name, addr, err))
+
+			a.landsLock.Lock()
+			if cl, ok := a.lands[req.Name]; ok && cl.placing == addr {
+				cl.placing = ""
+			}
+			a.landsLock.Unlock()
+		}
+	}
+	for addr, names := range dropOrders {
+		for _, name := range names {
+			log.Info(fmt.Sprintf("dropping land %s from server %s", name, addr))
+			if err := a.dropLand(addr, name); err != nil {
+				log.Error(fmt.Sprintf("can not drop land %s from server %s: %s", name, addr, err))
+			}
+		}
+	}
+}
+
+func leastLoaded(loads map[string]int) (string, bool) {
+	best, found := "", false
+	for addr, load := range loads {
+		if !found || load < loads[best] || (load == loads[best] && addr < best) {
+			best, found = addr, true
+		}
+	}
+	return best, found
+}
+
+func (a *Stage) serverClient(addr string) (*client.RPCClient, error) {
+	config := client.Config{
+		Addr:    addr,
+		Timeout: DefaultPlaceTimeout,
+	}
+	return client.ClientFromConfig(&config)
+}
+
+func (a *Stage) hostLand(addr string, req *share.HostLandRequest) error {
+	cl, err := a.serverClient(addr)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPlaceTimeout)
+	defer cancel()
+
+	_, err = cl.HostLandCtx(ctx, req)
+	return err
+}
+
+func (a *Stage) dropLand(addr string, name string) error {
+	cl, err := a.serverClient(addr)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPlaceTimeout)
+	defer cancel()
+
+	_, err = cl.DropLandCtx(ctx, &share.DropLandRequest{Name: name})
+	return err
+}
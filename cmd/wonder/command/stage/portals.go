@@ -0,0 +1,119 @@
+package stage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CreatePortal links the place from to the place to, both in lands of the
+// catalogue. the server of from gets the portal right away, a server that
+// hosts the land later gets it with the land.
+func (a *Stage) CreatePortal(from, to share.PortalEnd) error {
+	if from == to {
+		return errors.New("a portal can not lead to itself")
+	}
+
+	a.landsLock.Lock()
+	for _, end := range []share.PortalEnd{from, to} {
+		cl, ok := a.lands[end.Land]
+		if !ok {
+			a.landsLock.Unlock()
+			return fmt.Errorf("unknown land: %s", end.Land)
+		}
+		// the size is not known before a land without one is saved once.
+		placement := cl.placement()
+		if end.X < 0 || end.Y < 0 ||
+			(placement.Width > 0 && (end.X >= placement.Width || end.Y >= placement.Height)) {
+			a.landsLock.Unlock()
+			return fmt.Errorf("%s is off the land", end)
+		}
+	}
+	a.portals[from] = to
+	a.landsLock.Unlock()
+
+	a.markDirty()
+	log.Info(fmt.Sprintf("portal from %s to %s", from, to))
+
+	for _, addr := range a.landHosts()[from.Land] {
+		if err := a.addPortal(addr, from.Land, toPortal(from, to)); err != nil {
+			log.Error(fmt.Sprintf("can not put portal at %s on server %s: %s", from, addr, err))
+		}
+	}
+	return nil
+}
+
+// learnPortals keeps the portals another stage knows of, and we not yet.
+func (a *Stage) learnPortals(links []share.PortalLink) {
+	learned := false
+
+	a.landsLock.Lock()
+	for _, link := range links {
+		if _, ok := a.portals[link.From]; !ok {
+			a.portals[link.From] = link.To
+			learned = true
+		}
+	}
+	a.landsLock.Unlock()
+
+	if learned {
+		a.markDirty()
+	}
+}
+
+// portalLinks are all the portals, by the place they are at.
+func (a *Stage) portalLinks() []share.PortalLink {
+	a.landsLock.Lock()
+	defer a.landsLock.Unlock()
+
+	var links []share.PortalLink
+	for from, to := range a.portals {
+		links = append(links, share.PortalLink{From: from, To: to})
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].From.String() < links[j].From.String()
+	})
+	return links
+}
+
+// portalsIn are the portals in the land name, landsLock must be held.
+func (a *Stage) portalsIn(name string) []share.Portal {
+	var portals []share.Portal
+	for from, to := range a.portals {
+		if from.Land == name {
+			portals = append(portals, toPortal(from, to))
+		}
+	}
+	return portals
+}
+
+func toPortal(from, to share.PortalEnd) share.Portal {
+	p := share.Portal{To: to.Land, ToX: to.X, ToY: to.Y}
+	p.PutPoint(share.Point{X: from.X, Y: from.Y})
+	return p
+}
+
+func (a *Stage) markDirty() {
+	a.serverState.l.Lock()
+	a.serverState.dirty = true
+	a.serverState.l.Unlock()
+}
+
+func (a *Stage) addPortal(addr string, name string, p share.Portal) error {
+	cl, err := a.serverClient(addr)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPlaceTimeout)
+	defer cancel()
+
+	_, err = cl.AddPortalCtx(ctx, &share.AddPortalRequest{Land: name, Portal: p})
+	return err
+}
@@ -0,0 +1,177 @@
+package stage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// replicate sends the whole membership table to every peer, over and over.
+// a server reports to one stage only, the others learn of it from here.
+func (a *Stage) replicate() {
+	interval := a.config.ReplicateInterval
+
+	for {
+		time.Sleep(interval)
+
+		request := share.ReplicateRequest{
+			Stage: a.name,
+		}
+		a.serverState.l.RLock()
+		for addr, m := range a.serverState.members {
+			request.Members = append(request.Members, toMember(addr, m))
+		}
+		a.serverState.l.RUnlock()
+
+		a.landsLock.Lock()
+		for name, cl := range a.lands {
+			if cl.snapshot != nil {
+				request.Lands = append(request.Lands, share.SaveLandRequest{
+					Name:     name,
+					Snapshot: *cl.snapshot,
+				})
+			}
+		}
+		a.landsLock.Unlock()
+		request.Portals = a.portalLinks()
+
+		var wg sync.WaitGroup
+		for _, peer := range a.config.Peers {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				defer cancel()
+
+				if err := a.sendPeer(ctx, peer, &request); err != nil {
+					log.Debug(fmt.Sprintf("can not replicate to stage %s: %s", peer, err))
+				}
+			}(peer)
+		}
+		wg.Wait()
+	}
+}
+
+// sendPeer runs for every peer at once, peerClients is guarded by peerLock.
+// only the goroutine of the peer dials it or drops its client.
+func (a *Stage) sendPeer(ctx context.Context, peer string, request *share.ReplicateRequest) error {
+	a.peerLock.Lock()
+	cl, ok := a.peerClients[peer]
+	a.peerLock.Unlock()
+
+	if !ok {
+		config := client.Config{
+			Addr:    peer,
+			Timeout: a.config.ReplicateInterval,
+		}
+		var err error
+		if cl, err = client.ClientFromConfig(&config); err != nil {
+			return err
+		}
+
+		a.peerLock.Lock()
+		a.peerClients[peer] = cl
+		a.peerLock.Unlock()
+	}
+
+	if _, err := cl.ReplicateCtx(ctx, request); err != nil {
+		cl.Close()
+
+		a.peerLock.Lock()
+		delete(a.peerClients, peer)
+		a.peerLock.Unlock()
+		return err
+	}
+
+	a.peerLock.Lock()
+	a.peerSeen[peer] = time.Now()
+	a.peerLock.Unlock()
+	return nil
+}
+
+// isLeader tells if this stage places the lands: the one with the lowest
+// address of those that replicated lately.
+func (a *Stage) isLeader() bool {
+	a.peerLock.Lock()
+	defer a.peerLock.Unlock()
+
+	for _, peer := range a.config.Peers {
+		seen, ok := a.peerSeen[peer]
+		if ok && time.Since(seen) < 3*a.config.ReplicateInterval && peer < a.config.RPCAddr {
+			return false
+		}
+	}
+	return true
+}
+
+// Replicate merges the membership table, the land snapshots and the portals
+// of another stage. the report seen last wins. alive, suspect and failed
+// follow from the reports, so only a server that left after its last report
+// is taken as left.
+func (a *Stage) Replicate(stage string, members []share.Member, lands []share.SaveLandRequest, portals []share.PortalLink) {
+	for i := range lands {
+		// lands that are not in our catalogue are not ours to keep.
+		a.SaveLand(lands[i].Name, &lands[i].Snapshot)
+	}
+	a.learnPortals(portals)
+
+	a.serverState.l.Lock()
+	defer a.serverState.l.Unlock()
+
+	now := time.Now()
+	for _, sm := range members {
+		report := share.ServerAliveRequest{
+			ServerAddr: sm.Addr,
+			Name:       sm.Name,
+			ID:         sm.ID,
+			Tags:       sm.Tags,
+			Width:      sm.Width,
+			Height:     sm.Height,
+			Population: sm.Population,
+			Load:       sm.Load,
+			Lands:      sm.Lands,
+		}
+
+		m, ok := a.serverState.members[sm.Addr]
+		if !ok {
+			if sm.Status != share.MemberAlive && sm.Status != share.MemberSuspect &&
+				now.Sub(time.Unix(sm.Updated, 0)) > DefaultServerReapTimeout {
+				continue
+			}
+			log.Debug(fmt.Sprintf("learned server %s from stage %s", sm.Addr, stage))
+			m = &member{
+				lastSeen: time.Unix(sm.LastSeen, 0),
+				report:   report,
+			}
+			a.serverState.members[sm.Addr] = m
+			a.setStatus(sm.Addr, m, sm.Status, now)
+			m.updated = time.Unix(sm.Updated, 0)
+			continue
+		}
+
+		// timestamps go over the wire in seconds.
+		if sm.LastSeen > m.lastSeen.Unix() {
+			changed := m.status == share.MemberAlive && !sameMetadata(&m.report, &report)
+			m.lastSeen = time.Unix(sm.LastSeen, 0)
+			m.report = report
+			if sm.Status == share.MemberAlive {
+				a.setStatus(sm.Addr, m, share.MemberAlive, now)
+			}
+			if changed {
+				a.emitMember(share.MemberEventUpdate, sm.Addr, m)
+			}
+		}
+
+		if sm.Status == share.MemberLeft && m.status != share.MemberLeft &&
+			sm.Updated >= m.lastSeen.Unix() {
+			a.setStatus(sm.Addr, m, share.MemberLeft, now)
+		}
+	}
+}
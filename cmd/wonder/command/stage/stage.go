@@ -1,26 +1,112 @@
 package stage
 
 import (
+	"fmt"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
 
 	log "github.com/sirupsen/logrus"
 )
 
-type EventHandler interface {
-	Handle(event land.Event)
+// MemberHandler gets every change of the membership table. HandleMember is
+// called with the table locked, it must not block.
+type MemberHandler interface {
+	HandleMember(event share.MemberEvent)
 }
 
-// if the one server didnot report in 10 seconds, then mark dead.
+// a server that did not report for DefaultServerSuspectTimeout is suspect,
+// after DefaultServerExpireTimeout it has failed. left and failed members are
+// forgotten after DefaultServerReapTimeout.
+var DefaultServerSuspectTimeout = 5 * time.Second
 var DefaultServerExpireTimeout = 10 * time.Second
-var CheckServerExpireInterval = 10 * time.Second
+var DefaultServerReapTimeout = 1 * time.Hour
+var CheckServerExpireInterval = 1 * time.Second
 
+type member struct {
+	status   string
+	updated  time.Time
+	lastSeen time.Time
+	report   share.ServerAliveRequest
+	health   health
+}
+
+// ServerState is the membership table, every server the stage knows of.
 type ServerState struct {
-	aliveServers map[string]time.Time
-	deadServers  map[string]time.Time
-	l            sync.RWMutex
+	members map[string]*member
+	l       sync.RWMutex
+
+	// dirty is set by every change, the state file is behind.
+	dirty bool
+}
+
+func (a *Stage) setStatus(addr string, m *member, status string, now time.Time) {
+	if m.status == status {
+		return
+	}
+	log.Info(fmt.Sprintf("server %s: %s -> %s", addr, m.status, status))
+	old := m.status
+	m.status = status
+	m.updated = now
+
+	eventType := share.MemberEventUpdate
+	switch status {
+	case share.MemberAlive:
+		if old != share.MemberSuspect {
+			eventType = share.MemberEventJoin
+		}
+	case share.MemberLeft:
+		eventType = share.MemberEventLeave
+	case share.MemberFailed:
+		eventType = share.MemberEventFailed
+	}
+	a.emitMember(eventType, addr, m)
+}
+
+func toMember(addr string, m *member) share.Member {
+	return share.Member{
+		Addr:       addr,
+		Status:     m.status,
+		Updated:    m.updated.Unix(),
+		LastSeen:   m.lastSeen.Unix(),
+		Name:       m.report.Name,
+		ID:         m.report.ID,
+		Tags:       m.report.Tags,
+		Width:      m.report.Width,
+		Height:     m.report.Height,
+		Population: m.report.Population,
+		Load:       m.report.Load,
+		Lands:      m.report.Lands,
+		Health:     m.health.status,
+		RTT:        int64(m.health.rtt / time.Microsecond),
+		Tick:       m.health.tick,
+		StuckLands: m.health.stuck,
+	}
+}
+
+// sameMetadata leaves out what changes all the time, population and load.
+// Lands come sorted.
+func sameMetadata(a, b *share.ServerAliveRequest) bool {
+	if a.Name != b.Name || a.ID != b.ID ||
+		a.Width != b.Width || a.Height != b.Height ||
+		len(a.Tags) != len(b.Tags) || len(a.Lands) != len(b.Lands) {
+		return false
+	}
+	for i := range a.Lands {
+		if a.Lands[i] != b.Lands[i] {
+			return false
+		}
+	}
+	for k, v := range a.Tags {
+		if w, ok := b.Tags[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
 }
 
 type Stage struct {
@@ -28,78 +114,253 @@ type Stage struct {
 	config      *Config
 	shutdownCh  chan struct{}
 	serverState *ServerState
+
+	memberHandlers    map[MemberHandler]struct{}
+	memberHandlerLock sync.Mutex
+
+	// clients of the health checks, by server address.
+	healthClients map[string]*client.RPCClient
+	healthLock    sync.Mutex
+
+	// clients of the other stages, by address, and when each last took our
+	// table.
+	peerClients map[string]*client.RPCClient
+	peerSeen    map[string]time.Time
+	peerLock    sync.Mutex
+
+	// the catalogue, every land the stage keeps placed, by name, and the
+	// portals between them, by where they are. both are guarded by
+	// landsLock.
+	lands     map[string]*catalogueLand
+	portals   map[share.PortalEnd]share.PortalEnd
+	landsLock sync.Mutex
+
+	// the event bus, its subscribers and the streams of the lands it is
+	// attached to.
+	eventHandlers map[EventHandler]struct{}
+	sources       map[busKey]*busSource
+	busLock       sync.Mutex
+	busKickCh     chan struct{}
 }
 
 func Create(config *Config) *Stage {
 	shutdownCh := make(chan struct{})
 
 	serverState := &ServerState{
-		aliveServers: make(map[string]time.Time),
-		deadServers:  make(map[string]time.Time),
+		members: make(map[string]*member),
 	}
 	stage := Stage{
 		name:        config.Name,
 		config:      config,
 		shutdownCh:  shutdownCh,
 		serverState: serverState,
+
+		memberHandlers: make(map[MemberHandler]struct{}),
+		healthClients:  make(map[string]*client.RPCClient),
+		peerClients:    make(map[string]*client.RPCClient),
+		peerSeen:       make(map[string]time.Time),
+		lands:          make(map[string]*catalogueLand),
+		portals:        make(map[share.PortalEnd]share.PortalEnd),
+		eventHandlers:  make(map[EventHandler]struct{}),
+		sources:        make(map[busKey]*busSource),
+		busKickCh:      make(chan struct{}, 1),
+	}
+	for _, spec := range config.Lands {
+		stage.lands[spec.Name] = &catalogueLand{spec: spec}
 	}
 	return &stage
 }
 
 func (a *Stage) Enter() {
 	log.Info("In command/stage/stage.go Enter()")
+	if a.config.StateFile != "" {
+		if err := a.loadState(); err != nil {
+			log.Error(fmt.Sprintf("can not load state: %s", err))
+		}
+	}
 	go a.cleanDeadServers()
+	if a.config.HealthInterval > 0 {
+		if a.config.HealthTimeout == 0 {
+			a.config.HealthTimeout = DefaultHealthTimeout
+		}
+		if a.config.HealthFailures == 0 {
+			a.config.HealthFailures = DefaultHealthFailures
+		}
+		if a.config.StallTimeout == 0 {
+			a.config.StallTimeout = DefaultStallTimeout
+		}
+		go a.checkHealth()
+	}
+	if len(a.config.Peers) > 0 {
+		if a.config.ReplicateInterval == 0 {
+			a.config.ReplicateInterval = DefaultReplicateInterval
+		}
+		go a.replicate()
+	}
+	if len(a.lands) > 0 {
+		go a.placeLands()
+	}
+	go a.busLoop()
 }
 
 func (a *Stage) Leave() error {
 	log.Info("In command/stage/stage.go Leave()")
+	if a.config.StateFile != "" {
+		if err := a.saveState(); err != nil {
+			log.Error(fmt.Sprintf("can not save state: %s", err))
+		}
+	}
 
 	// simulate leaving process
 	time.Sleep(2 * time.Second)
 	return nil
 }
 
-func (a *Stage) ListServers() ([]string, error) {
+// ListServers returns the alive and suspect members, or all of them, with
+// tags matching the regexps in tags. a suspect member may just not have
+// reported since the stage came back.
+func (a *Stage) ListServers(all bool, tags map[string]string) ([]share.Member, error) {
+	filters := make(map[string]*regexp.Regexp)
+	for k, v := range tags {
+		re, err := regexp.Compile("^(" + v + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad filter for tag %s: %s", k, err)
+		}
+		filters[k] = re
+	}
+
 	a.serverState.l.RLock()
 	defer a.serverState.l.RUnlock()
 
-	var servers []string
-	for s, _ := range a.serverState.aliveServers {
-		servers = append(servers, s)
+	var members []share.Member
+	for addr, m := range a.serverState.members {
+		if !all && m.status != share.MemberAlive && m.status != share.MemberSuspect {
+			continue
+		}
+		if !matchTags(m.report.Tags, filters) {
+			continue
+		}
+		members = append(members, toMember(addr, m))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr < members[j].Addr
+	})
+	return members, nil
+}
+
+// a missing tag matches nothing.
+func matchTags(tags map[string]string, filters map[string]*regexp.Regexp) bool {
+	for k, re := range filters {
+		v, ok := tags[k]
+		if !ok || !re.MatchString(v) {
+			return false
+		}
 	}
-	return servers, nil
+	return true
 }
 
-func (a *Stage) ServerAlive(serverAddr string) (string, error) {
+func (a *Stage) ServerAlive(report *share.ServerAliveRequest) (string, error) {
 	a.serverState.l.Lock()
 	defer a.serverState.l.Unlock()
 
-	a.serverState.aliveServers[serverAddr] = time.Now()
-	delete(a.serverState.deadServers, serverAddr)
+	serverAddr := report.ServerAddr
+	now := time.Now()
+	m, ok := a.serverState.members[serverAddr]
+	if !ok {
+		m = &member{}
+		a.serverState.members[serverAddr] = m
+	}
+	changed := ok && m.status == share.MemberAlive && !sameMetadata(&m.report, report)
+	m.lastSeen = now
+	m.report = *report
+	a.setStatus(serverAddr, m, share.MemberAlive, now)
 
-	// TODO for debug only.
-	var ks []string
-	for k, _ := range a.serverState.aliveServers {
-		ks = append(ks, k)
+	if changed {
+		a.emitMember(share.MemberEventUpdate, serverAddr, m)
 	}
-	log.Debug("Alive servers: ", ks)
 
 	return "i know u are alive. good job! ", nil
 }
 
+// ServerLeave marks the server left, it is not suspected when it stops
+// reporting.
+func (a *Stage) ServerLeave(serverAddr string) error {
+	a.serverState.l.Lock()
+	defer a.serverState.l.Unlock()
+
+	m, ok := a.serverState.members[serverAddr]
+	if !ok {
+		return fmt.Errorf("unknown server: %s", serverAddr)
+	}
+	a.setStatus(serverAddr, m, share.MemberLeft, time.Now())
+	return nil
+}
+
+func (a *Stage) SubscribeMembers(h MemberHandler) {
+	a.memberHandlerLock.Lock()
+	defer a.memberHandlerLock.Unlock()
+
+	a.memberHandlers[h] = struct{}{}
+}
+
+func (a *Stage) UnsubscribeMembers(h MemberHandler) {
+	a.memberHandlerLock.Lock()
+	defer a.memberHandlerLock.Unlock()
+
+	delete(a.memberHandlers, h)
+}
+
+// emitMember is called with serverState.l held, so events come in the order
+// of the changes.
+func (a *Stage) emitMember(eventType string, addr string, m *member) {
+	event := share.MemberEvent{
+		Type:   eventType,
+		Member: toMember(addr, m),
+	}
+	a.serverState.dirty = true
+
+	a.memberHandlerLock.Lock()
+	defer a.memberHandlerLock.Unlock()
+
+	for h := range a.memberHandlers {
+		h.HandleMember(event)
+	}
+	a.kickBus()
+}
+
 func (a *Stage) cleanDeadServers() {
 	for {
 		select {
 		case <-time.After(CheckServerExpireInterval):
-			a.serverState.l.Lock()
-			for s, updated := range a.serverState.aliveServers {
-				// if the updated time is too old, move it to dead servers map.
-				if time.Since(updated) > DefaultServerExpireTimeout {
-					delete(a.serverState.aliveServers, s)
-					a.serverState.deadServers[s] = updated
+			a.checkMembers(time.Now())
+			if a.config.StateFile != "" {
+				if err := a.saveState(); err != nil {
+					log.Error(fmt.Sprintf("can not save state: %s", err))
 				}
 			}
-			a.serverState.l.Unlock()
+		}
+	}
+}
+
+func (a *Stage) checkMembers(now time.Time) {
+	a.serverState.l.Lock()
+	defer a.serverState.l.Unlock()
+
+	for addr, m := range a.serverState.members {
+		switch m.status {
+		case share.MemberAlive, share.MemberSuspect:
+			silent := now.Sub(m.lastSeen)
+			if silent > DefaultServerExpireTimeout {
+				a.setStatus(addr, m, share.MemberFailed, now)
+			} else if silent > DefaultServerSuspectTimeout {
+				a.setStatus(addr, m, share.MemberSuspect, now)
+			}
+
+		case share.MemberLeft, share.MemberFailed:
+			if now.Sub(m.updated) > DefaultServerReapTimeout {
+				delete(a.serverState.members, addr)
+				a.serverState.dirty = true
+			}
 		}
 	}
 }
@@ -0,0 +1,139 @@
+package stage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nickelchen/wonder/share"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const stateFileVersion = 1
+
+// stateFile is what the stage keeps on disk of its membership table, and the
+// portals made at run time.
+type stateFile struct {
+	Version int
+	Name    string
+	Saved   int64
+	Members []share.Member
+	Portals []share.PortalLink
+}
+
+// loadState fills the membership table from the state file. alive members
+// come back suspect, they are alive again with their next report, or fail
+// like any other silent server.
+func (a *Stage) loadState() error {
+	bs, err := ioutil.ReadFile(a.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(bs, &state); err != nil {
+		return fmt.Errorf("bad state file %s: %s", a.config.StateFile, err)
+	}
+	if state.Version != stateFileVersion {
+		return fmt.Errorf("state file %s has version %d, want %d",
+			a.config.StateFile, state.Version, stateFileVersion)
+	}
+
+	a.learnPortals(state.Portals)
+
+	a.serverState.l.Lock()
+	defer a.serverState.l.Unlock()
+
+	now := time.Now()
+	for _, sm := range state.Members {
+		m := &member{
+			status:   sm.Status,
+			updated:  time.Unix(sm.Updated, 0),
+			lastSeen: time.Unix(sm.LastSeen, 0),
+			report: share.ServerAliveRequest{
+				ServerAddr: sm.Addr,
+				Name:       sm.Name,
+				ID:         sm.ID,
+				Tags:       sm.Tags,
+				Width:      sm.Width,
+				Height:     sm.Height,
+				Population: sm.Population,
+				Load:       sm.Load,
+				Lands:      sm.Lands,
+			},
+		}
+		if m.status == share.MemberAlive || m.status == share.MemberSuspect {
+			// the clock starts over, they could not report while we were down.
+			m.status = share.MemberSuspect
+			m.updated = now
+			m.lastSeen = now
+		}
+		a.serverState.members[sm.Addr] = m
+	}
+	log.Info(fmt.Sprintf("loaded %d servers from %s", len(state.Members), a.config.StateFile))
+	return nil
+}
+
+// saveState writes the state file if the table changed since the last time.
+func (a *Stage) saveState() error {
+	portals := a.portalLinks()
+
+	a.serverState.l.Lock()
+	if !a.serverState.dirty {
+		a.serverState.l.Unlock()
+		return nil
+	}
+	state := stateFile{
+		Version: stateFileVersion,
+		Name:    a.name,
+		Saved:   time.Now().Unix(),
+		Portals: portals,
+	}
+	for addr, m := range a.serverState.members {
+		state.Members = append(state.Members, toMember(addr, m))
+	}
+	a.serverState.dirty = false
+	a.serverState.l.Unlock()
+
+	bs, err := json.MarshalIndent(state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(a.config.StateFile, bs)
+	}
+	if err != nil {
+		// try again next time.
+		a.serverState.l.Lock()
+		a.serverState.dirty = true
+		a.serverState.l.Unlock()
+	}
+	return err
+}
+
+// writeFileAtomic writes a temporary file next to path and renames it over
+// path, readers see the old file or the new one, never half of it.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,53 @@
+package stage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+func TestListReloadedMembers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stage.json")
+	state := stateFile{
+		Version: stateFileVersion,
+		Name:    "test",
+		Members: []share.Member{
+			{Addr: "127.0.0.1:1", Status: share.MemberAlive, Name: "alive"},
+			{Addr: "127.0.0.1:2", Status: share.MemberSuspect, Name: "suspect"},
+			{Addr: "127.0.0.1:3", Status: share.MemberFailed, Name: "failed"},
+		},
+	}
+	bs, err := json.Marshal(&state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, bs, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Create(&Config{Name: "test", StateFile: path})
+	if err := a.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := a.ListServers(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stage was down, the alive ones could not report to it.
+	if len(members) != 2 {
+		t.Fatalf("listed %d members, want the 2 that were not failed", len(members))
+	}
+	for _, m := range members {
+		if m.Status != share.MemberSuspect {
+			t.Errorf("%s is listed %s, want %s", m.Name, m.Status, share.MemberSuspect)
+		}
+	}
+
+	if members, err = a.ListServers(true, nil); err != nil || len(members) != 3 {
+		t.Fatalf("listed %d of all 3 members: %v", len(members), err)
+	}
+}
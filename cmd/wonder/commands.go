@@ -39,6 +39,24 @@ func init() {
 			}, nil
 		},
 
+		"members": func() (cli.Command, error) {
+			return &command.MembersCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"events": func() (cli.Command, error) {
+			return &command.EventsCommand{
+				Ui: ui,
+			}, nil
+		},
+
+		"portal create": func() (cli.Command, error) {
+			return &command.PortalCreateCommand{
+				Ui: ui,
+			}, nil
+		},
+
 		"info": func() (cli.Command, error) {
 			fh, _ := os.OpenFile("./logs/info.log",
 				os.O_RDWR|os.O_APPEND|os.O_CREATE, os.FileMode(0755))
@@ -48,6 +66,20 @@ func init() {
 				Ui: fl,
 			}, nil
 		},
+		"play": func() (cli.Command, error) {
+			fh, _ := os.OpenFile("./logs/play.log",
+				os.O_RDWR|os.O_APPEND|os.O_CREATE, os.FileMode(0755))
+			fl := &cli.BasicUi{Writer: fh}
+
+			return &command.PlayCommand{
+				Ui: fl,
+			}, nil
+		},
+		"render": func() (cli.Command, error) {
+			return &command.RenderCommand{
+				Ui: ui,
+			}, nil
+		},
 		"version": func() (cli.Command, error) {
 			return &command.VersionCommand{
 				Revision:          GitCommit,
@@ -22,25 +22,90 @@ func init() {
 	gCol, _ = strconv.Atoi(os.Getenv("COL"))
 }
 
-func init() {
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-}
-
 type Land struct {
 	tiles       [][]share.Tile
 	sprites     []share.Sprite
 	spritesLock sync.RWMutex
 	config      *Config
+
+	// tick counts simulation steps, seq counts emitted events.
+	// both are guarded by spritesLock.
+	tick uint64
+	seq  uint64
+
+	// events wait here for pumpEvents, so the simulation never blocks on a
+	// full EventCh.
+	pendingEvents []Event
+	pendingLock   sync.Mutex
+	pendingCh     chan struct{}
+
+	// closed by Shrink, the land stops moving.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// the rabbit jumps into the land once, after that it only jumps around
+	// while it is here. exit is where it left. both are guarded by
+	// spritesLock.
+	rabbitBorn bool
+	exit       *exit
+
+	// possessed characters are moved by players, not by the land. guarded
+	// by spritesLock.
+	possessed map[string]bool
+
+	// entered are the ids of the last handoffs taken in, oldest first.
+	// guarded by spritesLock.
+	entered []string
+
+	row int
+	col int
+	rnd *rand.Rand
+	// rnd is not safe for concurrent use.
+	rndLock sync.Mutex
 }
 
 type Config struct {
 	EventCh chan Event
+
+	// Seed makes the land the same on every run, 0 means a random one. Row
+	// and Col default to the ROW and COL env.
+	Seed int64
+	Row  int
+	Col  int
+
+	// with ManualTick the land only moves on Step, for tests.
+	ManualTick bool
+
+	// Restore starts the land from a snapshot, taken on another server,
+	// instead of a new one. Row and Col are taken from it.
+	Restore *Snapshot
+
+	// Name is the land on the world map, with Neighbours on its sides.
+	// sprites that walk over a side to a neighbour go to HandoffCh. an Empty
+	// land starts without anybody in it.
+	Name       string
+	Neighbours share.Neighbours
+	HandoffCh  chan share.HandoffRequest
+	Empty      bool
+
+	// Portals are put in the land when it starts, new or restored.
+	Portals []share.Portal
 }
 
 type Event struct {
 	Type string
 	Item interface{}
+	Seq  uint64
+	Tick uint64
+}
+
+// Snapshot is a consistent copy of the land. Seq is the last event that is
+// already reflected in it, so a subscriber only needs events after Seq.
+type Snapshot struct {
+	Seq     uint64
+	Tick    uint64
+	Tiles   [][]share.Tile
+	Sprites []share.Sprite
 }
 
 type PlantParams struct {
@@ -75,19 +140,59 @@ func DefaultConfig() *Config {
 }
 
 func Create(config *Config) *Land {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	row, col := config.Row, config.Col
+	if config.Restore != nil && len(config.Restore.Tiles) > 0 {
+		row, col = len(config.Restore.Tiles), len(config.Restore.Tiles[0])
+	}
+	if row == 0 {
+		row = gRow
+	}
+	if col == 0 {
+		col = gCol
+	}
+
 	var land Land = Land{
-		config: config,
+		config:    config,
+		pendingCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		possessed: make(map[string]bool),
+		row:       row,
+		col:       col,
+		rnd:       rand.New(rand.NewSource(seed)),
 	}
 	return &land
 }
 
 func (l *Land) Spread() int {
-	l.tiles = initTiles()
-	l.sprites = initSprites()
-
-	l.aliceEnter()
+	if restore := l.config.Restore; restore != nil {
+		l.tiles = restore.Tiles
+		l.sprites = append([]share.Sprite(nil), restore.Sprites...)
+		l.tick = restore.Tick
+		l.seq = restore.Seq
+		// past its first jump the rabbit is here, or somewhere else.
+		l.rabbitBorn = l.tick >= 25
+	} else {
+		l.tiles = l.initTiles()
+		l.sprites = initSprites()
+
+		if l.config.Empty {
+			l.rabbitBorn = true
+		} else {
+			l.aliceEnter()
+		}
+	}
+	for _, p := range l.config.Portals {
+		l.putPortal(p)
+	}
 
-	go l.spawnFakeEvents()
+	go l.pumpEvents()
+	if !l.config.ManualTick {
+		go l.spawnFakeEvents()
+	}
 
 	log.Info("land/land.go Spread()")
 	return 0
@@ -95,11 +200,15 @@ func (l *Land) Spread() int {
 
 func (l *Land) Shrink() {
 	log.Info("land/land.go Shrink()")
+	l.stopOnce.Do(func() { close(l.stopCh) })
 }
 
 func (l *Land) Plant(params *PlantParams) (*PlantResult, error) {
 	log.Info("land/land.go Plant()")
 
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
 	var s share.Sprite
 	for i := 0; i < params.Number; i++ {
 		point := l.randPoint()
@@ -110,7 +219,7 @@ func (l *Land) Plant(params *PlantParams) (*PlantResult, error) {
 			s = o
 
 		case share.PlantFlower:
-			o := share.Flower{}
+			o := share.Flower{Color: params.Color}
 			o.PutPoint(point)
 			s = o
 
@@ -121,6 +230,12 @@ func (l *Land) Plant(params *PlantParams) (*PlantResult, error) {
 		}
 
 		l.sprites = append(l.sprites, s)
+		// so the mirrors of the land see it too.
+		add := share.SpriteAdd{Kind: InfoItemType(s), X: point.X, Y: point.Y}
+		if f, ok := s.(share.Flower); ok {
+			add.Color = f.Color
+		}
+		l.sendEvent(share.EventTypeAdd, add)
 	}
 
 	result := PlantResult{
@@ -132,13 +247,12 @@ func (l *Land) Plant(params *PlantParams) (*PlantResult, error) {
 }
 
 func (l *Land) Info(params *InfoParams) (*InfoResult, error) {
-	l.spritesLock.Lock()
-	defer l.spritesLock.Unlock()
+	snapshot := l.Snapshot()
 
-	resultCh := make(chan InfoResultItem, 1+len(l.sprites))
+	resultCh := make(chan InfoResultItem, 2+len(snapshot.Sprites))
 
 	defer func() {
-		go l.sendResultItem(resultCh)
+		go l.sendResultItem(resultCh, snapshot)
 	}()
 
 	result := InfoResult{
@@ -148,26 +262,57 @@ func (l *Land) Info(params *InfoParams) (*InfoResult, error) {
 	return &result, nil
 }
 
-func (l *Land) sendResultItem(resultCh chan InfoResultItem) {
+func (l *Land) Snapshot() *Snapshot {
+	l.spritesLock.RLock()
+	defer l.spritesLock.RUnlock()
+
+	tiles := make([][]share.Tile, len(l.tiles))
+	for i, row := range l.tiles {
+		tiles[i] = append([]share.Tile(nil), row...)
+	}
+
+	snapshot := Snapshot{
+		Seq:     l.seq,
+		Tick:    l.tick,
+		Tiles:   tiles,
+		Sprites: append([]share.Sprite(nil), l.sprites...),
+	}
+	return &snapshot
+}
+
+func (l *Land) Tick() uint64 {
+	l.spritesLock.RLock()
+	defer l.spritesLock.RUnlock()
+
+	return l.tick
+}
+
+// Size is the width and height of the land, in tiles.
+func (l *Land) Size() (int, int) {
+	return l.col, l.row
+}
+
+// Population counts the sprites by InfoItemType.
+func (l *Land) Population() map[string]int {
+	l.spritesLock.RLock()
+	defer l.spritesLock.RUnlock()
+
+	population := make(map[string]int)
+	for _, sprite := range l.sprites {
+		population[InfoItemType(sprite)]++
+	}
+	return population
+}
+
+func (l *Land) sendResultItem(resultCh chan InfoResultItem, snapshot *Snapshot) {
 	resultCh <- InfoResultItem{
 		Type: share.InfoItemTypeTile,
-		Item: l.tiles,
+		Item: snapshot.Tiles,
 	}
-	for _, sprite := range l.sprites {
-		var st string
-		switch sprite.(type) {
-		case share.Tree:
-			st = share.InfoItemTypeTree
-		case share.Flower:
-			st = share.InfoItemTypeFlower
-		case share.Grass:
-			st = share.InfoItemTypeGrass
-		case share.Human:
-			st = share.InfoItemTypeHuman
-		}
+	for _, sprite := range snapshot.Sprites {
 		log.Debug(fmt.Sprintf("sendResultItem: %v", sprite))
 		resultCh <- InfoResultItem{
-			Type: st,
+			Type: InfoItemType(sprite),
 			Item: sprite,
 		}
 	}
@@ -177,72 +322,156 @@ func (l *Land) sendResultItem(resultCh chan InfoResultItem) {
 	}
 }
 
+// InfoItemType tells which info item type a sprite is streamed as.
+func InfoItemType(sprite share.Sprite) string {
+	var st string
+	switch sprite.(type) {
+	case share.Tree:
+		st = share.InfoItemTypeTree
+	case share.Flower:
+		st = share.InfoItemTypeFlower
+	case share.Grass:
+		st = share.InfoItemTypeGrass
+	case share.Human:
+		st = share.InfoItemTypeHuman
+	case share.Animal:
+		st = share.InfoItemTypeAnimal
+	case share.Portal:
+		st = share.InfoItemTypePortal
+	}
+	return st
+}
+
 // random spawn some events
 func (l *Land) spawnFakeEvents() {
-	tick := time.Tick(200 * time.Millisecond)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Step()
+		case <-l.stopCh:
+			return
+		}
+	}
+}
 
-	loop := 0
+// Step moves the land one tick on. it is called by the land itself, unless
+// Config.ManualTick is set.
+func (l *Land) Step() {
+	tick := l.nextTick()
 
-	for _ = range tick {
-		loop++
-		// every 25 loops, rabbit jump once.
-		if loop%25 == 0 {
-			newPoint := l.rabbitJump()
-			l.sendEvent(
-				share.EventTypeJump,
-				share.SpriteJump{Name: "Rabbit", X: newPoint.X, Y: newPoint.Y})
+	// every 25 ticks, rabbit jump once.
+	if tick%25 == 0 && !l.isPossessed("Rabbit") {
+		l.rabbitJump()
+		l.stepOn("Rabbit")
+	}
 
+	// for now, only spwan 0 type event: EventTypeMove
+	choice := l.randInt() % 1
+
+	switch choice {
+	case 0:
+		a, err := l.aliceInfo()
+		log.Debug(fmt.Sprintf("l.aliceInfo: %v", a))
+		if err != nil || l.isPossessed(a.Name) {
+			return
 		}
 
-		// for now, only spwan 0 type event: EventTypeMove
-		choice := rand.Int() % 1
+		r, err := l.rabbitInfo()
+		log.Debug(fmt.Sprintf("l.rabbitInfo: %v", r))
+		if err != nil {
+			// the rabbit may have gone to the next land.
+			l.followTrail(a)
+			l.stepOn(a.Name)
+			return
+		}
 
-		// type of event
-		var t string
-		// the item associated with the event
-		var i interface{}
+		dir, err := l.moveDirection(a.P.X, a.P.Y, r.P.X, r.P.Y)
+		if err != nil {
+			return
+		}
 
-		switch choice {
-		case 0:
-			t = share.EventTypeMove
+		l.aliceMove(dir)
+		l.stepOn(a.Name)
+
+	case 1:
+		l.spritesLock.Lock()
+		l.sendEvent(share.EventTypeAdd, share.SpriteAdd{})
+		l.spritesLock.Unlock()
+	case 2:
+		l.spritesLock.Lock()
+		l.sendEvent(share.EventTypeDelete, share.SpriteDelete{})
+		l.spritesLock.Unlock()
+	}
+}
 
-			a, err := l.aliceInfo()
-			log.Debug(fmt.Sprintf("l.aliceInfo: %v", a))
-			if err != nil {
-				continue
-			}
+func (l *Land) nextTick() uint64 {
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
 
-			r, err := l.rabbitInfo()
-			log.Debug(fmt.Sprintf("l.rabbitInfo: %v", r))
-			if err != nil {
-				continue
-			}
+	l.tick++
+	return l.tick
+}
 
-			dir, err := moveDirection(a.P.X, a.P.Y, r.P.X, r.P.Y)
-			if err != nil {
-				continue
-			}
+func (l *Land) randInt() int {
+	l.rndLock.Lock()
+	defer l.rndLock.Unlock()
 
-			l.aliceMove(dir)
+	return l.rnd.Int()
+}
 
-			i = share.SpriteMove{Name: "Alice", Direction: dir}
+// sendEvent must be called with spritesLock held, so the seq of the event
+// and the sprites it changed are always seen together by Snapshot.
+func (l *Land) sendEvent(eventType string, eventItem interface{}) {
+	l.seq++
+	event := Event{
+		Type: eventType,
+		Item: eventItem,
+		Seq:  l.seq,
+		Tick: l.tick,
+	}
+	if l.config.EventCh == nil {
+		return
+	}
 
-		case 1:
-			t = share.EventTypeAdd
-			i = share.SpriteAdd{}
-		case 2:
-			t = share.EventTypeDelete
-			i = share.SpriteDelete{}
-		}
+	l.pendingLock.Lock()
+	l.pendingEvents = append(l.pendingEvents, event)
+	l.pendingLock.Unlock()
 
-		l.sendEvent(t, i)
+	select {
+	case l.pendingCh <- struct{}{}:
+	default:
 	}
 }
 
-func (l *Land) sendEvent(eventType string, eventItem interface{}) {
-	event := Event{Type: eventType, Item: eventItem}
-	// send to channel, on the other end, alice eventLoop is waiting.
-	if l.config.EventCh != nil {
-		l.config.EventCh <- event
+// pumpEvents moves pending events to EventCh in seq order, it is the only one
+// that may block on a slow reader.
+func (l *Land) pumpEvents() {
+	if l.config.EventCh == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-l.pendingCh:
+		case <-l.stopCh:
+			return
+		}
+
+		l.pendingLock.Lock()
+		events := l.pendingEvents
+		l.pendingEvents = nil
+		l.pendingLock.Unlock()
+
+		for _, event := range events {
+			// send to channel, on the other end, alice eventLoop is waiting.
+			select {
+			case l.config.EventCh <- event:
+			case <-l.stopCh:
+				return
+			}
+		}
 	}
 }
@@ -0,0 +1,78 @@
+package land
+
+import (
+	"fmt"
+
+	"github.com/nickelchen/wonder/share"
+	log "github.com/sirupsen/logrus"
+)
+
+// AddPortal puts a portal in the land, in place of one already there.
+func (l *Land) AddPortal(p share.Portal) {
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	l.putPortal(p)
+	l.sendEvent(share.EventTypePortal, p)
+}
+
+// putPortal must be called with spritesLock held, or before Spread returns.
+func (l *Land) putPortal(p share.Portal) {
+	for i, s := range l.sprites {
+		if o, ok := s.(share.Portal); ok && o.P == p.P {
+			l.sprites[i] = p
+			return
+		}
+	}
+	l.sprites = append(l.sprites, p)
+}
+
+// stepOn sends the human or animal called name through the portal it just
+// stepped on, if there is one, and tells the land it went to. it is only
+// called after a move, so whoever comes out on a portal does not go right
+// back.
+func (l *Land) stepOn(name string) string {
+	var portal *share.Portal
+
+	l.spritesLock.RLock()
+	var at *share.Point
+	for _, s := range l.sprites {
+		switch o := s.(type) {
+		case share.Human:
+			if o.Name == name {
+				at = &o.P
+			}
+		case share.Animal:
+			if o.Name == name {
+				at = &o.P
+			}
+		}
+	}
+	if at != nil {
+		for _, s := range l.sprites {
+			if o, ok := s.(share.Portal); ok && o.P == *at {
+				portal = &o
+			}
+		}
+	}
+	l.spritesLock.RUnlock()
+
+	if portal == nil {
+		return ""
+	}
+
+	req := share.HandoffRequest{
+		To: portal.To,
+		At: &share.Point{X: portal.ToX, Y: portal.ToY},
+	}
+	log.Debug(fmt.Sprintf("%s falls down the rabbit hole at %v of %s", name, portal.P, l.config.Name))
+	if !l.handoff(name, req) {
+		return ""
+	}
+	if name == "Rabbit" {
+		l.spritesLock.Lock()
+		l.exit = &exit{p: portal.P, req: req}
+		l.spritesLock.Unlock()
+	}
+	return portal.To
+}
@@ -0,0 +1,135 @@
+package land
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nickelchen/wonder/share"
+)
+
+// Possess takes the human or animal called name away from the land, it only
+// moves with Move from now on, until Release.
+func (l *Land) Possess(name string) error {
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	if _, ok := l.character(name); !ok {
+		return fmt.Errorf("%s is not in %s", name, l.config.Name)
+	}
+	if l.possessed[name] {
+		return fmt.Errorf("%s is possessed by another player", name)
+	}
+	l.possessed[name] = true
+	return nil
+}
+
+// Release gives the character back to the land.
+func (l *Land) Release(name string) {
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	delete(l.possessed, name)
+}
+
+func (l *Land) isPossessed(name string) bool {
+	l.spritesLock.RLock()
+	defer l.spritesLock.RUnlock()
+
+	return l.possessed[name]
+}
+
+// Move walks a possessed character one step, if the way is free: not into a
+// tree or somebody else. like any other character it goes over the edge into
+// the neighbour there, and down the portal it steps on. to is the land it
+// went to then, it is no longer possessed.
+func (l *Land) Move(name string, dir share.MoveDirection) (share.Point, string, error) {
+	p, over, err := l.walk(name, dir)
+	if err != nil {
+		return share.Point{}, "", err
+	}
+	if over {
+		if !l.leave(name, dir) {
+			return share.Point{}, "", errors.New("the edge of the land is in the way")
+		}
+		return p, l.config.Neighbours.Of(dir), nil
+	}
+	return p, l.stepOn(name), nil
+}
+
+// walk moves the character one step to dir, unless that is over the edge,
+// then it stays at p.
+func (l *Land) walk(name string, dir share.MoveDirection) (p share.Point, over bool, err error) {
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	if !l.possessed[name] {
+		return share.Point{}, false, errors.New(share.ErrNotPossessed)
+	}
+	i, ok := l.character(name)
+	if !ok {
+		return share.Point{}, false, fmt.Errorf("%s is not in %s", name, l.config.Name)
+	}
+
+	at := l.sprites[i].GetPoint()
+	p = at
+	switch dir {
+	case share.MoveUp:
+		p.Y--
+	case share.MoveDown:
+		p.Y++
+	case share.MoveLeft:
+		p.X--
+	case share.MoveRight:
+		p.X++
+	default:
+		return share.Point{}, false, fmt.Errorf("unknown direction: %d", dir)
+	}
+
+	if p.X < 0 || p.Y < 0 || p.X >= l.col || p.Y >= l.row {
+		return at, true, nil
+	}
+	for _, s := range l.sprites {
+		if s.GetPoint() != p {
+			continue
+		}
+		switch o := s.(type) {
+		case share.Tree:
+			return share.Point{}, false, errors.New("a tree is in the way")
+		case share.Human:
+			return share.Point{}, false, fmt.Errorf("%s is in the way", o.Name)
+		case share.Animal:
+			return share.Point{}, false, fmt.Errorf("%s is in the way", o.Name)
+		}
+	}
+
+	switch o := l.sprites[i].(type) {
+	case share.Human:
+		o.PutPoint(p)
+		l.sprites[i] = o
+	case share.Animal:
+		o.PutPoint(p)
+		l.sprites[i] = o
+	}
+	l.sendEvent(
+		share.EventTypeMove,
+		share.SpriteMove{Name: name, Direction: dir, X: p.X, Y: p.Y})
+	return p, false, nil
+}
+
+// character is the index of the human or animal called name in sprites,
+// spritesLock must be held.
+func (l *Land) character(name string) (int, bool) {
+	for i, s := range l.sprites {
+		switch o := s.(type) {
+		case share.Human:
+			if o.Name == name {
+				return i, true
+			}
+		case share.Animal:
+			if o.Name == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
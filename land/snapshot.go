@@ -0,0 +1,62 @@
+package land
+
+import (
+	"github.com/nickelchen/wonder/share"
+)
+
+// Share turns the snapshot into one that can be sent to another server.
+func (s *Snapshot) Share() *share.LandSnapshot {
+	snapshot := share.LandSnapshot{
+		Seq:  s.Seq,
+		Tick: s.Tick,
+		Board: share.GameBoard{
+			Tiles: s.Tiles,
+		},
+	}
+	board := &snapshot.Board
+	for _, sprite := range s.Sprites {
+		switch o := sprite.(type) {
+		case share.Tree:
+			board.Trees = append(board.Trees, o)
+		case share.Flower:
+			board.Flowers = append(board.Flowers, o)
+		case share.Grass:
+			board.Grasses = append(board.Grasses, o)
+		case share.Human:
+			board.Humans = append(board.Humans, o)
+		case share.Animal:
+			board.Animals = append(board.Animals, o)
+		case share.Portal:
+			board.Portals = append(board.Portals, o)
+		}
+	}
+	return &snapshot
+}
+
+// SnapshotFromShare is the other way round of Share.
+func SnapshotFromShare(s *share.LandSnapshot) *Snapshot {
+	snapshot := Snapshot{
+		Seq:   s.Seq,
+		Tick:  s.Tick,
+		Tiles: s.Board.Tiles,
+	}
+	for _, o := range s.Board.Trees {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	for _, o := range s.Board.Flowers {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	for _, o := range s.Board.Grasses {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	for _, o := range s.Board.Humans {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	for _, o := range s.Board.Animals {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	for _, o := range s.Board.Portals {
+		snapshot.Sprites = append(snapshot.Sprites, o)
+	}
+	return &snapshot
+}
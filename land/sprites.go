@@ -3,23 +3,22 @@ package land
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 
 	"github.com/nickelchen/wonder/share"
 	log "github.com/sirupsen/logrus"
 )
 
 func (l *Land) randPoint() share.Point {
-	return share.Point{X: rand.Int() % gCol, Y: rand.Int() % gRow}
+	return share.Point{X: l.randInt() % l.col, Y: l.randInt() % l.row}
 }
 
-func initTiles() [][]share.Tile {
+func (l *Land) initTiles() [][]share.Tile {
 	var tiles [][]share.Tile
 
-	for i := 0; i < gRow; i++ {
+	for i := 0; i < l.row; i++ {
 		var row []share.Tile
-		for j := 0; j < gCol; j++ {
-			row = append(row, share.Tile{Gradient: rand.Int() % 2})
+		for j := 0; j < l.col; j++ {
+			row = append(row, share.Tile{Gradient: l.randInt() % 2})
 		}
 		tiles = append(tiles, row)
 	}
@@ -88,6 +87,10 @@ func (l *Land) aliceMove(dir share.MoveDirection) {
 			a.PutPoint(p)
 			sprites = append(sprites, a)
 
+			l.sendEvent(
+				share.EventTypeMove,
+				share.SpriteMove{Name: a.Name, Direction: dir, X: p.X, Y: p.Y})
+
 		} else {
 			sprites = append(sprites, s)
 
@@ -109,7 +112,15 @@ func (l *Land) rabbitInfo() (share.Animal, error) {
 	return share.Animal{}, errors.New("can not find rabbit")
 }
 
-func (l *Land) rabbitJump() share.Point {
+// rabbitJump jumps the rabbit somewhere else in the land, or now and then
+// over the edge into a neighbour.
+func (l *Land) rabbitJump() {
+	if dirs := l.neighbourDirs(); len(dirs) > 0 && l.randInt()%3 == 0 {
+		if l.rabbitHop(dirs[l.randInt()%len(dirs)]) {
+			return
+		}
+	}
+
 	l.spritesLock.Lock()
 	defer l.spritesLock.Unlock()
 
@@ -119,23 +130,67 @@ func (l *Land) rabbitJump() share.Point {
 	point := l.randPoint()
 	rabbit := share.Animal{Name: "Rabbit"}
 
+	found := false
 	for _, s := range l.sprites {
 		if isRabbit(s) {
-			point.X = rand.Int() % gCol
-			point.Y = rand.Int() % gRow
+			found = true
+			point.X = l.randInt() % l.col
+			point.Y = l.randInt() % l.row
 		} else {
 			sprites = append(sprites, s)
 		}
 	}
+	if !found && l.rabbitBorn {
+		// it is in another land.
+		return
+	}
+	l.rabbitBorn = true
 
 	rabbit.PutPoint(point)
 	sprites = append(sprites, rabbit)
 
 	l.sprites = sprites
-	return point
+
+	l.sendEvent(
+		share.EventTypeJump,
+		share.SpriteJump{Name: rabbit.Name, X: point.X, Y: point.Y})
+}
+
+// rabbitHop jumps the rabbit to the side dir of the land and over it, and
+// leaves the trail for Alice.
+func (l *Land) rabbitHop(dir share.MoveDirection) bool {
+	if _, err := l.rabbitInfo(); err != nil {
+		return false
+	}
+	point := l.edgePoint(dir)
+
+	l.spritesLock.Lock()
+	for i, s := range l.sprites {
+		if isRabbit(s) {
+			rabbit := s.(share.Animal)
+			rabbit.PutPoint(point)
+			l.sprites[i] = rabbit
+		}
+	}
+	l.sendEvent(
+		share.EventTypeJump,
+		share.SpriteJump{Name: "Rabbit", X: point.X, Y: point.Y})
+	l.exit = &exit{
+		p:   point,
+		req: share.HandoffRequest{To: l.config.Neighbours.Of(dir), Direction: dir},
+	}
+	l.spritesLock.Unlock()
+
+	if !l.leave("Rabbit", dir) {
+		l.spritesLock.Lock()
+		l.exit = nil
+		l.spritesLock.Unlock()
+		return false
+	}
+	return true
 }
 
-func moveDirection(srcX, srcY, dstX, dstY int) (dir share.MoveDirection, err error) {
+func (l *Land) moveDirection(srcX, srcY, dstX, dstY int) (dir share.MoveDirection, err error) {
 
 	var dirs []share.MoveDirection
 
@@ -154,7 +209,7 @@ func moveDirection(srcX, srcY, dstX, dstY int) (dir share.MoveDirection, err err
 	if len(dirs) == 0 {
 		err = errors.New("no need to move")
 	} else {
-		dir = dirs[rand.Int()%len(dirs)]
+		dir = dirs[l.randInt()%len(dirs)]
 	}
 
 	return dir, err
@@ -0,0 +1,252 @@
+package land
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nickelchen/wonder/share"
+	log "github.com/sirupsen/logrus"
+)
+
+// exit is where the rabbit left the land, over the edge or down a portal,
+// and how. Alice follows it there.
+type exit struct {
+	p   share.Point
+	req share.HandoffRequest
+}
+
+func (l *Land) trail() *exit {
+	l.spritesLock.RLock()
+	defer l.spritesLock.RUnlock()
+
+	return l.exit
+}
+
+// followTrail walks Alice to where the rabbit left, and after it.
+func (l *Land) followTrail(alice share.Human) {
+	e := l.trail()
+	if e == nil {
+		return
+	}
+
+	if alice.P == e.p {
+		l.handoff(alice.Name, e.req)
+		return
+	}
+
+	dir, err := l.moveDirection(alice.P.X, alice.P.Y, e.p.X, e.p.Y)
+	if err != nil {
+		return
+	}
+	l.aliceMove(dir)
+}
+
+// edgePoint is a random point on the side dir of the land.
+func (l *Land) edgePoint(dir share.MoveDirection) share.Point {
+	p := l.randPoint()
+	switch dir {
+	case share.MoveUp:
+		p.Y = 0
+	case share.MoveDown:
+		p.Y = l.row - 1
+	case share.MoveLeft:
+		p.X = 0
+	case share.MoveRight:
+		p.X = l.col - 1
+	}
+	return p
+}
+
+// neighbourDirs are the sides of the land with another land behind them.
+func (l *Land) neighbourDirs() []share.MoveDirection {
+	var dirs []share.MoveDirection
+	for _, dir := range []share.MoveDirection{share.MoveUp, share.MoveDown, share.MoveLeft, share.MoveRight} {
+		if l.config.Neighbours.Of(dir) != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// leave hands the sprite called name over to the neighbour on the side dir.
+// it stays if the server can not take the handoff right now.
+func (l *Land) leave(name string, dir share.MoveDirection) bool {
+	to := l.config.Neighbours.Of(dir)
+	if to == "" {
+		return false
+	}
+	return l.handoff(name, share.HandoffRequest{To: to, Direction: dir})
+}
+
+// handoff takes the sprite called name out of the land and sends it on with
+// req.
+func (l *Land) handoff(name string, req share.HandoffRequest) bool {
+	if l.config.HandoffCh == nil {
+		return false
+	}
+
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	req.From = l.config.Name
+	var sprites []share.Sprite
+	var p share.Point
+	for _, s := range l.sprites {
+		switch o := s.(type) {
+		case share.Human:
+			if o.Name == name {
+				req.Human, p = &o, o.P
+				continue
+			}
+		case share.Animal:
+			if o.Name == name {
+				req.Animal, p = &o, o.P
+				continue
+			}
+		}
+		sprites = append(sprites, s)
+	}
+	if req.Human == nil && req.Animal == nil {
+		return false
+	}
+
+	select {
+	case l.config.HandoffCh <- req:
+	default:
+		log.Warn(fmt.Sprintf("handoffs are full, %s stays in %s", name, l.config.Name))
+		return false
+	}
+	l.sprites = sprites
+	// the player steering it stays behind.
+	delete(l.possessed, name)
+
+	log.Debug(fmt.Sprintf("%s leaves %s for %s", name, l.config.Name, req.To))
+	l.sendEvent(
+		share.EventTypeLeave,
+		share.SpriteLeave{Name: name, To: req.To, X: p.X, Y: p.Y})
+	return true
+}
+
+// Enter takes in a sprite from a neighbour, on the side it came over, or
+// from a portal, where it leads.
+func (l *Land) Enter(req *share.HandoffRequest) error {
+	if req.At != nil {
+		return l.put(req, *req.At, req.From)
+	}
+
+	var p share.Point
+	if req.Human != nil {
+		p = req.Human.P
+	} else if req.Animal != nil {
+		p = req.Animal.P
+	}
+
+	switch req.Direction {
+	case share.MoveUp:
+		p.Y = l.row - 1
+	case share.MoveDown:
+		p.Y = 0
+	case share.MoveLeft:
+		p.X = l.col - 1
+	case share.MoveRight:
+		p.X = 0
+	}
+	return l.put(req, p, req.From)
+}
+
+// Return puts back a sprite whose handoff failed, where it left.
+func (l *Land) Return(req *share.HandoffRequest) error {
+	var p share.Point
+	if req.Human != nil {
+		p = req.Human.P
+	} else if req.Animal != nil {
+		p = req.Animal.P
+	}
+	return l.put(req, p, req.To)
+}
+
+func (l *Land) put(req *share.HandoffRequest, p share.Point, from string) error {
+	p.X = clamp(p.X, 0, l.col-1)
+	p.Y = clamp(p.Y, 0, l.row-1)
+
+	l.spritesLock.Lock()
+	defer l.spritesLock.Unlock()
+
+	// the answer to the first try was lost, the sprite is here already.
+	if l.tookIn(req.ID) {
+		return nil
+	}
+
+	var name, kind string
+	var sprite share.Sprite
+	switch {
+	case req.Human != nil:
+		h := *req.Human
+		h.PutPoint(p)
+		name, kind, sprite = h.Name, share.InfoItemTypeHuman, h
+	case req.Animal != nil:
+		a := *req.Animal
+		a.PutPoint(p)
+		name, kind, sprite = a.Name, share.InfoItemTypeAnimal, a
+		if isRabbit(a) {
+			l.rabbitBorn = true
+			l.exit = nil
+		}
+	default:
+		return errors.New("handoff without a sprite")
+	}
+
+	for _, s := range l.sprites {
+		if h, ok := s.(share.Human); ok && h.Name == name {
+			return fmt.Errorf("%s is already in %s", name, l.config.Name)
+		}
+		if a, ok := s.(share.Animal); ok && a.Name == name {
+			return fmt.Errorf("%s is already in %s", name, l.config.Name)
+		}
+	}
+	l.sprites = append(l.sprites, sprite)
+	l.rememberHandoff(req.ID)
+
+	log.Debug(fmt.Sprintf("%s enters %s from %s", name, l.config.Name, from))
+	l.sendEvent(
+		share.EventTypeEnter,
+		share.SpriteEnter{Name: name, Kind: kind, From: from, X: p.X, Y: p.Y})
+	return nil
+}
+
+// rememberedHandoffs is how many handoff ids a land keeps, far more than
+// can be retried at once.
+const rememberedHandoffs = 64
+
+// tookIn and rememberHandoff must be called with spritesLock held.
+func (l *Land) tookIn(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, e := range l.entered {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Land) rememberHandoff(id string) {
+	if id == "" {
+		return
+	}
+	l.entered = append(l.entered, id)
+	if len(l.entered) > rememberedHandoffs {
+		l.entered = l.entered[1:]
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
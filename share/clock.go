@@ -0,0 +1,26 @@
+package share
+
+import "fmt"
+
+// TicksPerDay is how long a day of the land is, ten minutes at a tick every
+// 200ms. a land starts at six in the morning.
+const TicksPerDay = 3000
+
+// TimeOfDay tells the clock and the part of the day at tick, like
+// "07:30 morning".
+func TimeOfDay(tick uint64) string {
+	minutes := int(tick%TicksPerDay*24*60/TicksPerDay) + 6*60
+	minutes %= 24 * 60
+	hour := minutes / 60
+
+	part := "night"
+	switch {
+	case hour >= 6 && hour < 12:
+		part = "morning"
+	case hour >= 12 && hour < 18:
+		part = "afternoon"
+	case hour >= 18 && hour < 22:
+		part = "evening"
+	}
+	return fmt.Sprintf("%02d:%02d %s", hour, minutes%60, part)
+}
@@ -1,5 +1,11 @@
 package share
 
+import "fmt"
+
+// ProtocolVersion is checked by the handshake, the first request on every
+// connection.
+const ProtocolVersion = 1
+
 type RequestHeader struct {
 	Seq     uint64
 	Command string
@@ -10,6 +16,16 @@ type ResponseHeader struct {
 	Error string
 }
 
+//
+// Handshake command
+//
+type HandshakeRequest struct {
+	Version int
+}
+
+type HandshakeResponse struct {
+}
+
 //
 // Plant command
 //
@@ -21,7 +37,10 @@ const (
 	PlantGrass  = "grass"
 )
 
+// Land names one of the lands the server hosts for the stage, empty is the
+// land of the server itself. it is the same for every request on a land.
 type PlantRequest struct {
+	Land   string
 	What   PlantType
 	Color  string
 	Number int
@@ -36,6 +55,7 @@ type PlantResponse struct {
 // Info command
 //
 type InfoRequest struct {
+	Land string
 }
 
 type InfoResponse struct {
@@ -48,7 +68,12 @@ const (
 	InfoItemTypeGrass  = "grass"
 	InfoItemTypeHuman  = "human"
 	InfoItemTypeAnimal = "animal"
+	InfoItemTypePortal = "portal"
 	InfoItemTypeDone   = "done"
+
+	// InfoItemTypeTileRegion is sent instead of InfoItemTypeTile when a sync
+	// asks for a region.
+	InfoItemTypeTileRegion = "tile-region"
 )
 
 type InfoResponseObj struct {
@@ -59,52 +84,501 @@ type InfoResponseObj struct {
 //
 // Subscribe Event command
 //
+
+// Policy tells the server what to do when the subscriber can not keep up.
+const (
+	// drop the oldest queued event, the subscriber sees a gap in Seq.
+	PolicyDropOldest = "drop-oldest"
+	// end the stream with ErrSlowConsumer.
+	PolicyDisconnect = "disconnect"
+	// fold queued moves and jumps of a sprite into one EventTypePosition.
+	PolicyCoalesce = "coalesce"
+)
+
+// With Resume set, the stream starts right after LastSeq instead of with the
+// next live event. If those events are no longer kept the request fails with
+// ErrSeqTooOld, the client has to Sync again. LandID is the one the stream
+// was opened with, the seqs of a land that started over, on a restarted
+// server or another one, are not those of the last, so a resume with another
+// LandID fails the same way.
+//
+// The stage merges the events of every land of every server into one
+// stream, of the Lands and Types asked for, all of them when empty. it keeps
+// no events, a resumed stream goes on with the next one, and it ends the
+// stream of a subscriber that can not keep up whatever the Policy.
 type SubscribeRequest struct {
+	Land    string
+	Resume  bool
+	LastSeq uint64
+	LandID  string
+	Policy  string
+	Lands   []string
+	Types   []string
 }
+
+// LandID tells this run of the land from any other, for a resume. it is
+// empty from the stage, which keeps no events to resume from.
 type SubscribeResponse struct {
+	LandID string
 }
 
 const (
-	EventTypeMove   = "move"
-	EventTypeJump   = "jump"
-	EventTypeAdd    = "add"
-	EventTypeDelete = "delete"
+	EventTypeMove     = "move"
+	EventTypeJump     = "jump"
+	EventTypePosition = "position"
+	EventTypeLeave    = "leave"
+	EventTypeEnter    = "enter"
+	EventTypePortal   = "portal"
+	EventTypeAdd      = "add"
+	EventTypeDelete   = "delete"
 )
 
+// Seq is the land wide sequence number of the event, it increases by one for
+// every event, so a receiver can tell when some events are missing.
+// Coalesced is set when events right before this one were folded into later
+// ones, so the jump in Seq is expected.
+//
+// From the stage, Server and Land tell where the event happened, Land is
+// empty for the land of the server itself. Seq is that of the land.
 type EventResponseObj struct {
-	Type    string
-	Payload []byte
+	Type      string
+	Seq       uint64
+	Tick      uint64
+	Coalesced bool
+	Payload   []byte
+	Server    string
+	Land      string
+}
+
+//
+// Sync command
+//
+// Region, if set, scopes the snapshot and the events to the tiles and the
+// sprites in it. a human or animal moving out of the region comes as a leave
+// event with an empty To, one moving in as an enter event with an empty From.
+// the event after those left out is Coalesced. such a stream is not resumed
+// after a reconnect, it starts over with a new snapshot.
+type SyncRequest struct {
+	Land   string
+	Policy string
+	Region *Region
+}
+
+// LandID is as of SubscribeResponse.
+type SyncResponse struct {
+	LandID string
+}
+
+// A sync stream starts with the snapshot items, all carrying the Seq and Tick
+// the snapshot was taken at, terminated by an InfoItemTypeDone item. After
+// that every event with a greater Seq follows on the same stream.
+type SyncResponseObj struct {
+	Type      string
+	Seq       uint64
+	Tick      uint64
+	Coalesced bool
+	Payload   []byte
+}
+
+//
+// Stop command, ends the stream that was opened with Seq
+//
+type StopRequest struct {
+	Seq uint64
+}
+
+type StopResponse struct {
 }
 
 //
 // List Servers command
 //
+// All asks for every member the stage knows of, not only the alive and
+// suspect ones.
+// Tags keeps the members whose tag matches the regexp, for every key.
 type ListServersRequest struct {
+	All  bool
+	Tags map[string]string
 }
 
 type ListServersResponse struct {
-	Servers []string
+	Members []Member
+}
+
+const (
+	MemberAlive   = "alive"
+	MemberSuspect = "suspect"
+	MemberLeft    = "left"
+	MemberFailed  = "failed"
+)
+
+// Member is a server as the stage sees it, from its last report. Updated is
+// when Status last changed and LastSeen when the server last reported, both
+// in unix seconds.
+type Member struct {
+	Addr     string
+	Status   string
+	Updated  int64
+	LastSeen int64
+
+	Name       string
+	ID         string
+	Tags       map[string]string
+	Width      int
+	Height     int
+	Population map[string]int
+	Load       int
+	Lands      []string
+
+	// from the health checks of the stage, if it runs them. RTT is in
+	// microseconds, Tick is the last one the server answered with.
+	Health string
+	RTT    int64
+	Tick   uint64
+	// StuckLands did not answer the last health check in time.
+	StuckLands []string
+}
+
+const (
+	MemberHealthy   = "healthy"
+	MemberUnhealthy = "unhealthy"
+)
+
+//
+// Subscribe Members command
+//
+type SubscribeMembersRequest struct {
+}
+
+type SubscribeMembersResponse struct {
+}
+
+const (
+	MemberEventJoin   = "member-join"
+	MemberEventLeave  = "member-leave"
+	MemberEventFailed = "member-failed"
+	MemberEventUpdate = "member-update"
+)
+
+// MemberEvent is streamed after SubscribeMembersResponse for every change of
+// a member: joining (again), leaving, failing, or changing status or
+// metadata otherwise.
+type MemberEvent struct {
+	Type   string
+	Member Member
 }
 
 //
 // Report Server Alive command
 //
 
+// the report of a server about itself. Population counts the sprites by
+// InfoItemType, Load is the number of open event streams. Lands are the
+// names of the lands it hosts for the stage.
 type ServerAliveRequest struct {
 	ServerAddr string
+	Name       string
+	ID         string
+	Tags       map[string]string
+	Width      int
+	Height     int
+	Population map[string]int
+	Load       int
+	Lands      []string
 }
 
 type ServerAliveResponse struct {
 	Message string
 }
 
+//
+// Ping command
+//
+type PingRequest struct {
+}
+
+// Tick tells if the land still moves, ID which server answered. Lands has
+// every land the server hosts, the home land first with an empty Name.
+type PingResponse struct {
+	Tick  uint64
+	ID    string
+	Lands []LandHealth
+}
+
+// LandHealth is how one land answered the probe. Stuck is set when it did not
+// in time, Tick is then the last one it answered with.
+type LandHealth struct {
+	Name  string
+	Tick  uint64
+	Stuck bool
+}
+
+//
+// Server Leave command
+//
+type ServerLeaveRequest struct {
+	ServerAddr string
+}
+
+type ServerLeaveResponse struct {
+}
+
+//
+// Host Land command, from stage to server
+//
+
+// LandSnapshot is a land on its way to another server.
+type LandSnapshot struct {
+	Seq   uint64
+	Tick  uint64
+	Board GameBoard
+}
+
+// Neighbours are the lands next to a land on the world map, by the side they
+// are on. empty where the world ends.
+type Neighbours struct {
+	Up    string
+	Down  string
+	Left  string
+	Right string
+}
+
+// Of is the neighbour on the side of dir.
+func (n Neighbours) Of(dir MoveDirection) string {
+	switch dir {
+	case MoveUp:
+		return n.Up
+	case MoveDown:
+		return n.Down
+	case MoveLeft:
+		return n.Left
+	case MoveRight:
+		return n.Right
+	}
+	return ""
+}
+
+// the server starts the land from Snapshot if there is one, otherwise a new
+// one from Seed. an Empty one starts without anybody in it. Portals are put
+// in it either way.
+type HostLandRequest struct {
+	Name       string
+	Seed       int64
+	Width      int
+	Height     int
+	Snapshot   *LandSnapshot
+	Neighbours Neighbours
+	Empty      bool
+	Portals    []Portal
+}
+
+type HostLandResponse struct {
+}
+
+//
+// Handoff command, from server to server
+//
+
+// a sprite walking from the land From into To, over its side Direction. P of
+// the sprite is where it was in From. one of Human and Animal is set. a
+// sprite that went through a portal comes out At instead. ID is the same for
+// every try of one handoff, a land takes in a retry that already arrived as
+// done.
+type HandoffRequest struct {
+	ID        string
+	From      string
+	To        string
+	Direction MoveDirection
+	At        *Point
+	Human     *Human
+	Animal    *Animal
+}
+
+type HandoffResponse struct {
+}
+
+//
+// Drop Land command, from stage to server
+//
+type DropLandRequest struct {
+	Name string
+}
+
+type DropLandResponse struct {
+}
+
+//
+// Save Land command, from server to stage
+//
+type SaveLandRequest struct {
+	Name     string
+	Snapshot LandSnapshot
+}
+
+type SaveLandResponse struct {
+}
+
+//
+// Locate Land command, asks the stage where a land is
+//
+type LocateLandRequest struct {
+	Name string
+}
+
+// ServerAddr is empty while the land has no server. SavedTick is the tick of
+// the latest snapshot the stage has, 0 for none. a land of the world map is
+// at region X, Y of it.
+type LandPlacement struct {
+	Name       string
+	ServerAddr string
+	Width      int
+	Height     int
+	SavedTick  uint64
+	World      bool
+	X          int
+	Y          int
+}
+
+type LocateLandResponse struct {
+	Land LandPlacement
+}
+
+//
+// World command, asks the stage for the world map
+//
+type WorldRequest struct {
+}
+
+// every land of the world map, the ones without a server too.
+type WorldResponse struct {
+	Lands []LandPlacement
+}
+
+//
+// Possess, Release and Move commands, a player steering a character
+//
+
+// a possessed character is only moved by the client that possessed it, until
+// it releases the character or goes away.
+type PossessRequest struct {
+	Land string
+	Name string
+}
+
+type PossessResponse struct {
+}
+
+type ReleaseRequest struct {
+	Land string
+	Name string
+}
+
+type ReleaseResponse struct {
+}
+
+type MoveRequest struct {
+	Land      string
+	Name      string
+	Direction MoveDirection
+}
+
+// X and Y is where the character is after the move. To is set when it went
+// over the edge or down a portal into that land, it is no longer possessed
+// then.
+type MoveResponse struct {
+	X  int
+	Y  int
+	To string
+}
+
+//
+// Create Portal command, from the cli to stage
+//
+
+// PortalEnd is a place in a land, land:x,y on the command line.
+type PortalEnd struct {
+	Land string
+	X    int
+	Y    int
+}
+
+func (e PortalEnd) String() string {
+	return fmt.Sprintf("%s:%d,%d", e.Land, e.X, e.Y)
+}
+
+// PortalLink is a portal as the stage knows it, from one place to another.
+type PortalLink struct {
+	From PortalEnd
+	To   PortalEnd
+}
+
+type CreatePortalRequest struct {
+	From PortalEnd
+	To   PortalEnd
+}
+
+type CreatePortalResponse struct {
+}
+
+//
+// Add Portal command, from stage to server
+//
+type AddPortalRequest struct {
+	Land   string
+	Portal Portal
+}
+
+type AddPortalResponse struct {
+}
+
+//
+// Replicate command, from stage to stage
+//
+type ReplicateRequest struct {
+	Stage   string
+	Members []Member
+	Lands   []SaveLandRequest
+	Portals []PortalLink
+}
+
+type ReplicateResponse struct {
+}
+
+//
+// errors that a client may want to act on
+//
+const (
+	ErrSeqTooOld    = "event seq too old, sync again"
+	ErrSlowConsumer = "consumer too slow, stream closed"
+	ErrNotPossessed = "character not possessed, possess it first"
+)
+
 //
 // all available command list
 //
 const (
+	HandshakeCommand   = "HandshakeCommand"
 	PlantCommand       = "PlantCommand"
 	InfoCommand        = "InfoCommand"
 	SubscribeCommand   = "SubscribeCommand"
+	SyncCommand        = "SyncCommand"
+	StopCommand        = "StopCommand"
 	ListServersCommand = "ListServersCommand"
 	ServerAliveCommand = "ServerAliveCommand"
+	ServerLeaveCommand = "ServerLeaveCommand"
+
+	SubscribeMembersCommand = "SubscribeMembersCommand"
+	PingCommand             = "PingCommand"
+	ReplicateCommand        = "ReplicateCommand"
+	HostLandCommand         = "HostLandCommand"
+	DropLandCommand         = "DropLandCommand"
+	SaveLandCommand         = "SaveLandCommand"
+	LocateLandCommand       = "LocateLandCommand"
+	WorldCommand            = "WorldCommand"
+	HandoffCommand          = "HandoffCommand"
+	CreatePortalCommand     = "CreatePortalCommand"
+	AddPortalCommand        = "AddPortalCommand"
+	PossessCommand          = "PossessCommand"
+	ReleaseCommand          = "ReleaseCommand"
+	MoveCommand             = "MoveCommand"
 )
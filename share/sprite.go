@@ -9,6 +9,32 @@ type Tile struct {
 	Gradient int
 }
 
+// Region is Width by Height tiles from X, Y.
+type Region struct {
+	X      int
+	Y      int
+	Width  int
+	Height int
+}
+
+func (r Region) Contains(p Point) bool {
+	return p.X >= r.X && p.X < r.X+r.Width && p.Y >= r.Y && p.Y < r.Y+r.Height
+}
+
+// Covers tells if all of o is in r.
+func (r Region) Covers(o Region) bool {
+	return o.X >= r.X && o.X+o.Width <= r.X+r.Width &&
+		o.Y >= r.Y && o.Y+o.Height <= r.Y+r.Height
+}
+
+// TileRegion is the tiles of Region out of a land of Width by Height.
+type TileRegion struct {
+	Width  int
+	Height int
+	Region Region
+	Tiles  [][]Tile
+}
+
 type Sprite interface {
 	GetPoint() Point
 	MovesToPoint(dstPoint Point) []SpriteMove
@@ -46,9 +72,12 @@ const (
 	MoveRight
 )
 
+// X and Y is where the sprite ends up after the move.
 type SpriteMove struct {
 	Direction MoveDirection
 	Name      string
+	X         int
+	Y         int
 }
 
 type SpriteJump struct {
@@ -57,7 +86,41 @@ type SpriteJump struct {
 	Name string
 }
 
+// SpritePosition puts a sprite at an absolute place. it stands for any number
+// of moves and jumps of the same sprite that were coalesced.
+type SpritePosition struct {
+	X    int
+	Y    int
+	Name string
+}
+
+// SpriteLeave is a sprite walking off the edge of the land, into the land
+// To. X and Y is where it was last.
+type SpriteLeave struct {
+	Name string
+	To   string
+	X    int
+	Y    int
+}
+
+// SpriteEnter is a sprite coming in from the land From, Kind is its
+// InfoItemType.
+type SpriteEnter struct {
+	Name string
+	Kind string
+	From string
+	X    int
+	Y    int
+}
+
+// SpriteAdd is a tree, flower or grass planted at X, Y. Kind is its
+// InfoItemType, Color that of a flower. the idle ticks of a land send one
+// without a Kind, it adds nothing.
 type SpriteAdd struct {
+	Kind  string
+	Color string
+	X     int
+	Y     int
 }
 
 type SpriteDelete struct {
@@ -86,6 +149,17 @@ type Flower struct {
 	Color string
 }
 
+// Portal is a rabbit hole. whoever steps on it comes out in the land To, at
+// ToX, ToY.
+type Portal struct {
+	SpriteBase
+	To  string
+	ToX int
+	ToY int
+}
+
+// GameBoard is the land as a client sees it. it has no lock of its own,
+// client.Mirror keeps one up to date and guards it.
 type GameBoard struct {
 	Tiles   [][]Tile
 	Trees   []Tree
@@ -93,91 +167,153 @@ type GameBoard struct {
 	Grasses []Grass
 	Humans  []Human
 	Animals []Animal
-
-	moveEventsCh   chan SpriteMove
-	jumpEventsCh   chan SpriteJump
-	addEventsCh    chan SpriteAdd
-	deleteEventsCh chan SpriteDelete
+	Portals []Portal
 }
 
 func NewGameBoard() *GameBoard {
-	board := GameBoard{
-		moveEventsCh:   make(chan SpriteMove, 255),
-		jumpEventsCh:   make(chan SpriteJump, 255),
-		addEventsCh:    make(chan SpriteAdd, 255),
-		deleteEventsCh: make(chan SpriteDelete, 255),
+	return &GameBoard{}
+}
+
+// Clone copies the board, so it can be read while the original changes.
+func (board *GameBoard) Clone() *GameBoard {
+	clone := GameBoard{
+		Trees:   append([]Tree(nil), board.Trees...),
+		Flowers: append([]Flower(nil), board.Flowers...),
+		Grasses: append([]Grass(nil), board.Grasses...),
+		Humans:  append([]Human(nil), board.Humans...),
+		Animals: append([]Animal(nil), board.Animals...),
+		Portals: append([]Portal(nil), board.Portals...),
+	}
+	for _, row := range board.Tiles {
+		clone.Tiles = append(clone.Tiles, append([]Tile(nil), row...))
+	}
+	return &clone
+}
+
+// CopyTo copies the board into dst, reusing the room dst has, so boards that
+// are copied over and over do not cost new ones.
+func (board *GameBoard) CopyTo(dst *GameBoard) {
+	dst.Trees = append(dst.Trees[:0], board.Trees...)
+	dst.Flowers = append(dst.Flowers[:0], board.Flowers...)
+	dst.Grasses = append(dst.Grasses[:0], board.Grasses...)
+	dst.Humans = append(dst.Humans[:0], board.Humans...)
+	dst.Animals = append(dst.Animals[:0], board.Animals...)
+	dst.Portals = append(dst.Portals[:0], board.Portals...)
+
+	if len(dst.Tiles) != len(board.Tiles) {
+		dst.Tiles = make([][]Tile, len(board.Tiles))
 	}
+	for y, row := range board.Tiles {
+		dst.Tiles[y] = append(dst.Tiles[y][:0], row...)
+	}
+}
+
+func (board *GameBoard) Reset() {
+	*board = GameBoard{}
+}
+
+func (board *GameBoard) ApplyMove(event SpriteMove) {
+	board.putSprite(event.Name, Point{X: event.X, Y: event.Y})
+}
+
+// an animal not seen before jumps into the board.
+func (board *GameBoard) ApplyJump(event SpriteJump) {
+	p := Point{X: event.X, Y: event.Y}
+	for i := range board.Animals {
+		if board.Animals[i].Name == event.Name {
+			board.Animals[i].PutPoint(p)
+			return
+		}
+	}
+
+	this := Animal{Name: event.Name}
+	this.PutPoint(p)
+	board.Animals = append(board.Animals, this)
+}
+
+func (board *GameBoard) ApplyPosition(event SpritePosition) {
+	board.putSprite(event.Name, Point{X: event.X, Y: event.Y})
+}
+
+func (board *GameBoard) ApplyLeave(event SpriteLeave) {
+	board.removeSprite(event.Name)
+}
+
+// a sprite that is already on the board only moves.
+func (board *GameBoard) ApplyEnter(event SpriteEnter) {
+	board.removeSprite(event.Name)
+
+	p := Point{X: event.X, Y: event.Y}
+	switch event.Kind {
+	case InfoItemTypeHuman:
+		this := Human{Name: event.Name}
+		this.PutPoint(p)
+		board.Humans = append(board.Humans, this)
+	case InfoItemTypeAnimal:
+		this := Animal{Name: event.Name}
+		this.PutPoint(p)
+		board.Animals = append(board.Animals, this)
+	}
+}
 
-	go board.pollingEvents()
-
-	return &board
-}
-
-func (board GameBoard) MoveEventsCh() chan SpriteMove {
-	return board.moveEventsCh
-}
-func (board GameBoard) JumpEventsCh() chan SpriteJump {
-	return board.jumpEventsCh
-}
-func (board GameBoard) AddEventsCh() chan SpriteAdd {
-	return board.addEventsCh
-}
-func (board GameBoard) DeleteEventsCh() chan SpriteDelete {
-	return board.deleteEventsCh
-}
-
-func (board *GameBoard) pollingEvents() {
-	for {
-		select {
-		case event := <-board.moveEventsCh:
-			// for now, only human can move.
-			var humans []Human
-			for _, h := range board.Humans {
-				if h.Name == event.Name {
-					p := h.P
-					switch event.Direction {
-					case MoveUp:
-						p.Y -= 1
-					case MoveDown:
-						p.Y += 1
-					case MoveRight:
-						p.X += 1
-					case MoveLeft:
-						p.X -= 1
-					}
-					h.PutPoint(p)
-				}
-				humans = append(humans, h)
-			}
-
-			board.Humans = humans
-
-		case event := <-board.jumpEventsCh:
-			// for now only animal can jump
-			var animals []Animal
-			var this Animal
-			for _, a := range board.Animals {
-				if a.Name == event.Name {
-					this = a
-					continue
-				}
-				animals = append(animals, a)
-			}
-
-			this.Name = event.Name
-			p := this.P
-			p.X = event.X
-			p.Y = event.Y
-
-			this.PutPoint(p)
-
-			animals = append(animals, this)
-			board.Animals = animals
-
-		case <-board.addEventsCh:
-			//
-		case <-board.deleteEventsCh:
-			//
+func (board *GameBoard) ApplyAdd(event SpriteAdd) {
+	p := Point{X: event.X, Y: event.Y}
+	switch event.Kind {
+	case InfoItemTypeTree:
+		this := Tree{}
+		this.PutPoint(p)
+		board.Trees = append(board.Trees, this)
+	case InfoItemTypeFlower:
+		this := Flower{Color: event.Color}
+		this.PutPoint(p)
+		board.Flowers = append(board.Flowers, this)
+	case InfoItemTypeGrass:
+		this := Grass{}
+		this.PutPoint(p)
+		board.Grasses = append(board.Grasses, this)
+	}
+}
+
+// a portal replaces the one on the same place.
+func (board *GameBoard) ApplyPortal(event Portal) {
+	for i := range board.Portals {
+		if board.Portals[i].P == event.P {
+			board.Portals[i] = event
+			return
+		}
+	}
+	board.Portals = append(board.Portals, event)
+}
+
+// removeSprite takes the human or animal called name off the board.
+func (board *GameBoard) removeSprite(name string) {
+	var humans []Human
+	for _, h := range board.Humans {
+		if h.Name != name {
+			humans = append(humans, h)
+		}
+	}
+	board.Humans = humans
+
+	var animals []Animal
+	for _, a := range board.Animals {
+		if a.Name != name {
+			animals = append(animals, a)
+		}
+	}
+	board.Animals = animals
+}
+
+// putSprite moves the human or animal called name to p.
+func (board *GameBoard) putSprite(name string, p Point) {
+	for i := range board.Humans {
+		if board.Humans[i].Name == name {
+			board.Humans[i].PutPoint(p)
+		}
+	}
+	for i := range board.Animals {
+		if board.Animals[i].Name == name {
+			board.Animals[i].PutPoint(p)
 		}
 	}
 }
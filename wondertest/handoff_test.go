@@ -0,0 +1,69 @@
+package wondertest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func humans(t *testing.T, cl *client.RPCClient, land string) int {
+	t.Helper()
+
+	stream, err := cl.InfoCtx(context.Background(), &share.InfoRequest{Land: land})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	n := 0
+	for {
+		var item *share.InfoResponseObj
+		within(t, "info item", func() { item, err = stream.Next() })
+		if err == io.EOF {
+			return n
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.Type == share.InfoItemTypeHuman {
+			n++
+		}
+	}
+}
+
+func TestRetriedHandoffIsTakenInOnce(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	north := share.HostLandRequest{Name: "north", Width: 10, Height: 10, Empty: true}
+	if err := cluster.Server().HostLand(&north); err != nil {
+		t.Fatal(err)
+	}
+
+	req := share.HandoffRequest{
+		ID:        "first",
+		From:      "south",
+		To:        "north",
+		Direction: share.MoveUp,
+		Human:     &share.Human{Name: "Bob"},
+	}
+	// the answer to the first one was lost.
+	for i := 0; i < 3; i++ {
+		if err := cluster.Server().Handoff(&req); err != nil {
+			t.Fatalf("try %d: %s", i, err)
+		}
+	}
+	if n := humans(t, cl, "north"); n != 1 {
+		t.Fatalf("north has %d humans after one handoff", n)
+	}
+
+	// another handoff of the same sprite is not a retry.
+	req.ID = "second"
+	if err := cluster.Server().Handoff(&req); err == nil {
+		t.Fatal("Bob was taken in twice")
+	}
+}
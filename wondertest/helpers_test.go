@@ -0,0 +1,82 @@
+package wondertest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+// waitTime is how long a test waits for anything to arrive.
+const waitTime = 5 * time.Second
+
+// rabbitJumps is how many steps it takes the rabbit to show up, Alice only
+// moves after it.
+const rabbitJumps = 25
+
+func start(t *testing.T, config *wondertest.Config) *wondertest.Cluster {
+	t.Helper()
+
+	cluster, err := wondertest.Start(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+	return cluster
+}
+
+func dial(t *testing.T, cluster *wondertest.Cluster, config *client.Config) *client.RPCClient {
+	t.Helper()
+
+	if config.Timeout == 0 {
+		config.Timeout = waitTime
+	}
+	cl, err := cluster.Client(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cl.Close)
+	return cl
+}
+
+// within runs f, and fails the test if it does not return in waitTime.
+func within(t *testing.T, what string, f func()) {
+	t.Helper()
+
+	doneCh := make(chan struct{})
+	go func() {
+		f()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(waitTime):
+		t.Fatalf("no %s in %s", what, waitTime)
+	}
+}
+
+func nextEvent(t *testing.T, stream *client.EventStream) *share.EventResponseObj {
+	t.Helper()
+
+	var event *share.EventResponseObj
+	var err error
+	within(t, "event", func() { event, err = stream.Next() })
+	if err != nil {
+		t.Fatalf("event stream ended: %s", err)
+	}
+	return event
+}
+
+func nextSync(t *testing.T, stream *client.SyncStream) *share.SyncResponseObj {
+	t.Helper()
+
+	var item *share.SyncResponseObj
+	var err error
+	within(t, "sync item", func() { item, err = stream.Next() })
+	if err != nil {
+		t.Fatalf("sync stream ended: %s", err)
+	}
+	return item
+}
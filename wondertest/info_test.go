@@ -0,0 +1,58 @@
+package wondertest_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func TestInfoStreamsTheWholeLand(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+	// the items race the header of the stream to the connection, unless the
+	// header goes first.
+	cluster.SlowStreams(time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		stream, err := cl.InfoCtx(context.Background(), &share.InfoRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var types []string
+		for {
+			var item *share.InfoResponseObj
+			within(t, "info item", func() { item, err = stream.Next() })
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("info stream ended: %s", err)
+			}
+			types = append(types, item.Type)
+		}
+		stream.Close()
+
+		if len(types) < 2 || types[0] != share.InfoItemTypeTile || types[len(types)-1] != share.InfoItemTypeDone {
+			t.Fatalf("info stream %d came as %v", i, types)
+		}
+	}
+}
+
+func TestInfoOfAnUnknownLand(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	if _, err := cl.InfoCtx(context.Background(), &share.InfoRequest{Land: "nowhere"}); err == nil {
+		t.Fatal("info of an unknown land did not fail")
+	}
+	// the connection is still good.
+	if _, err := cl.PingCtx(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
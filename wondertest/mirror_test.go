@@ -0,0 +1,95 @@
+package wondertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func TestMirrorSeesPlantedTrees(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	field := share.HostLandRequest{Name: "field", Width: 10, Height: 10, Empty: true}
+	if err := cluster.Server().HostLand(&field); err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := cl.MirrorCtx(context.Background(), &share.SyncRequest{Land: "field"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+
+	req := share.PlantRequest{Land: "field", What: share.PlantTree, Number: 2}
+	if _, err := cl.PlantCtx(context.Background(), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(waitTime)
+	for {
+		if trees := len(mirror.Board().Trees); trees == 2 {
+			break
+		}
+		select {
+		case <-mirror.Changes():
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("the mirror has trees %v, want the 2 planted", mirror.Board().Trees)
+		}
+	}
+}
+
+func TestMirrorSeesTheColourOfAPlantedFlower(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	field := share.HostLandRequest{Name: "field", Width: 10, Height: 10, Empty: true}
+	if err := cluster.Server().HostLand(&field); err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := cl.MirrorCtx(context.Background(), &share.SyncRequest{Land: "field"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mirror.Close()
+
+	req := share.PlantRequest{Land: "field", What: share.PlantFlower, Color: "red", Number: 1}
+	if _, err := cl.PlantCtx(context.Background(), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(waitTime)
+	for len(mirror.Board().Flowers) == 0 {
+		select {
+		case <-mirror.Changes():
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the mirror has no flower")
+		}
+	}
+	if color := mirror.Board().Flowers[0].Color; color != "red" {
+		t.Fatalf("the flower is %q, want red", color)
+	}
+
+	// and so has a new snapshot.
+	synced, err := cl.MirrorCtx(context.Background(), &share.SyncRequest{Land: "field"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer synced.Close()
+	for !synced.Synced() {
+		select {
+		case <-synced.Changes():
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("the mirror did not sync")
+		}
+	}
+	if flowers := synced.Board().Flowers; len(flowers) != 1 || flowers[0].Color != "red" {
+		t.Fatalf("the snapshot has flowers %v, want one red", flowers)
+	}
+}
@@ -0,0 +1,91 @@
+package wondertest_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func move(t *testing.T, cl *client.RPCClient, land string, dir share.MoveDirection) (*share.MoveResponse, error) {
+	t.Helper()
+
+	return cl.MoveCtx(context.Background(), &share.MoveRequest{Land: land, Name: "Bob", Direction: dir})
+}
+
+// arrive waits for n humans in land, the handoffs go on in the background.
+func arrive(t *testing.T, cl *client.RPCClient, land string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTime)
+	for humans(t, cl, land) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has no %d humans in %s", land, n, waitTime)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPossessedWalksLikeAnybody(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	lands := []share.HostLandRequest{
+		{Name: "west", Width: 5, Height: 5, Empty: true, Neighbours: share.Neighbours{Right: "east"}},
+		{Name: "east", Width: 5, Height: 5, Empty: true, Neighbours: share.Neighbours{Left: "west"}},
+	}
+	for i := range lands {
+		if err := cluster.Server().HostLand(&lands[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob := share.HandoffRequest{
+		To:    "west",
+		At:    &share.Point{X: 4, Y: 0},
+		Human: &share.Human{Name: "Bob"},
+	}
+	if err := cluster.Server().Handoff(&bob); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cl.PossessCtx(context.Background(), &share.PossessRequest{Land: "west", Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	// nobody is up there.
+	if _, err := move(t, cl, "west", share.MoveUp); err == nil || !strings.Contains(err.Error(), "edge") {
+		t.Fatalf("move over the edge without a neighbour got %v", err)
+	}
+
+	resp, err := move(t, cl, "west", share.MoveRight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.To != "east" {
+		t.Fatalf("Bob went to %q, want east", resp.To)
+	}
+	arrive(t, cl, "east", 1)
+	if _, err := move(t, cl, "west", share.MoveLeft); err != client.ErrNotPossessed {
+		t.Fatalf("move after leaving got %v, want ErrNotPossessed", err)
+	}
+
+	// Bob came in on the left side, a step right is the portal.
+	portal := share.Portal{To: "west", ToX: 2, ToY: 2}
+	portal.PutPoint(share.Point{X: 1, Y: 0})
+	if err := cluster.Server().AddPortal("east", portal); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.PossessCtx(context.Background(), &share.PossessRequest{Land: "east", Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = move(t, cl, "east", share.MoveRight); err != nil {
+		t.Fatal(err)
+	}
+	if resp.To != "west" {
+		t.Fatalf("Bob fell into %q, want west", resp.To)
+	}
+	arrive(t, cl, "west", 1)
+}
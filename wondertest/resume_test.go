@@ -0,0 +1,67 @@
+package wondertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func TestResumeOfAnotherLandIsTooOld(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	stream, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	cluster.Step(rabbitJumps)
+	event := nextEvent(t, stream)
+
+	// the buffer has the seq, but a land of another run would have it too.
+	for _, landID := range []string{"", "another-run"} {
+		req := share.SubscribeRequest{Resume: true, LastSeq: event.Seq - 1, LandID: landID}
+		if _, err := cl.SubscribeCtx(context.Background(), &req); err != client.ErrSeqTooOld {
+			t.Errorf("resume with land id %q: got %v, want ErrSeqTooOld", landID, err)
+		}
+	}
+}
+
+func TestSyncResumesWithItsLand(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{Reconnect: true, MinBackoff: 10 * time.Millisecond})
+
+	stream, err := cl.SyncCtx(context.Background(), &share.SyncRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	for {
+		if item := nextSync(t, stream); item.Type == share.InfoItemTypeDone {
+			break
+		}
+	}
+	cluster.Step(rabbitJumps)
+	lastSeq := nextSync(t, stream).Seq
+
+	// the connection drops on the next event, the stream picks up after the
+	// last one, without starting over.
+	cluster.FailSends(1)
+	cluster.Step(5)
+	for i := 0; i < 5; i++ {
+		item := nextSync(t, stream)
+		if item.Type == share.InfoItemTypeTile {
+			t.Fatal("stream started over with a new snapshot")
+		}
+		if item.Seq != lastSeq+1 {
+			t.Fatalf("got seq %d after %d", item.Seq, lastSeq)
+		}
+		lastSeq = item.Seq
+	}
+}
@@ -0,0 +1,104 @@
+package wondertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+// listed waits for stage i to list the server of the cluster.
+func listed(t *testing.T, cluster *wondertest.Cluster, i int) share.Member {
+	t.Helper()
+
+	cl, err := cluster.StageClientOf(i, &client.Config{Timeout: waitTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	deadline := time.Now().Add(waitTime)
+	for time.Now().Before(deadline) {
+		resp, err := cl.ListServersCtx(context.Background(), &share.ListServersRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range resp.Members {
+			if m.Addr == cluster.ServerAddr {
+				return m
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stage %d does not list the server", i)
+	return share.Member{}
+}
+
+func TestStagesReplicate(t *testing.T) {
+	cluster := start(t, &wondertest.Config{Stages: 3})
+
+	// the server reported to the first one only.
+	for i := range cluster.StageAddrs {
+		if m := listed(t, cluster, i); m.Status != share.MemberAlive {
+			t.Errorf("stage %d has the server %s", i, m.Status)
+		}
+	}
+}
+
+func TestServerFailsOverToTheNextStage(t *testing.T) {
+	cluster := start(t, &wondertest.Config{Stages: 2})
+	listed(t, cluster, 1)
+
+	cluster.StopStage(0)
+
+	var err error
+	deadline := time.Now().Add(waitTime)
+	for err = cluster.Report(); err != nil && time.Now().Before(deadline); err = cluster.Report() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the first stage is down, the report went to the second.
+	if err != nil {
+		t.Fatalf("the server can not report to the second stage: %s", err)
+	}
+	if m := listed(t, cluster, 1); m.Status != share.MemberAlive {
+		t.Fatalf("the second stage has the server %s", m.Status)
+	}
+}
+
+func TestStreamsEndWithTheConnection(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	if _, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.SyncCtx(context.Background(), &share.SyncRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.Report(); err != nil {
+		t.Fatal(err)
+	}
+	if m := listed(t, cluster, 0); m.Load != 2 {
+		t.Fatalf("the server has load %d, want the 2 streams", m.Load)
+	}
+
+	// nothing happens in the land, no send fails to tell.
+	cl.Close()
+	deadline := time.Now().Add(waitTime)
+	for {
+		if err := cluster.Report(); err != nil {
+			t.Fatal(err)
+		}
+		m := listed(t, cluster, 0)
+		if m.Load == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server still has load %d after the client went away", m.Load)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
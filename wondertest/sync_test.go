@@ -0,0 +1,135 @@
+package wondertest_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func TestSyncOfARegion(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	field := share.HostLandRequest{Name: "field", Width: 10, Height: 10, Empty: true}
+	if err := cluster.Server().HostLand(&field); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []share.HandoffRequest{
+		{To: "field", At: &share.Point{X: 1, Y: 1}, Human: &share.Human{Name: "Bob"}},
+		{To: "field", At: &share.Point{X: 8, Y: 8}, Human: &share.Human{Name: "Carol"}},
+	} {
+		if err := cluster.Server().Handoff(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cl.PossessCtx(context.Background(), &share.PossessRequest{Land: "field", Name: "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := share.SyncRequest{Land: "field", Region: &share.Region{X: 0, Y: 0, Width: 3, Height: 3}}
+	stream, err := cl.SyncCtx(context.Background(), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var humans []string
+	for {
+		item := nextSync(t, stream)
+		if item.Type == share.InfoItemTypeDone {
+			break
+		}
+		if item.Type == share.InfoItemTypeHuman {
+			var h share.Human
+			json.Unmarshal(item.Payload, &h)
+			humans = append(humans, h.Name)
+		}
+	}
+	if len(humans) != 1 || humans[0] != "Bob" {
+		t.Fatalf("the region has humans %v, want only Bob", humans)
+	}
+
+	// out of the region and back in, the moves outside are left out.
+	walk := []share.MoveDirection{share.MoveRight, share.MoveRight, share.MoveRight, share.MoveLeft, share.MoveLeft}
+	for _, dir := range walk {
+		if _, err := move(t, cl, "field", dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lastSeq uint64
+	for _, want := range []string{share.EventTypeMove, share.EventTypeLeave, share.EventTypeEnter} {
+		item := nextSync(t, stream)
+		if item.Type != want {
+			t.Fatalf("got a %s event, want %s", item.Type, want)
+		}
+		if lastSeq > 0 && item.Seq != lastSeq+1 && !item.Coalesced {
+			t.Fatalf("seq %d after %d is not marked coalesced", item.Seq, lastSeq)
+		}
+		lastSeq = item.Seq
+
+		if item.Type == share.EventTypeEnter {
+			var enter share.SpriteEnter
+			json.Unmarshal(item.Payload, &enter)
+			if enter.Name != "Bob" || enter.Kind != share.InfoItemTypeHuman || enter.X != 2 {
+				t.Fatalf("Bob came back as %+v", enter)
+			}
+		}
+	}
+}
+
+func TestSyncOfARegionStartsOverAfterAReconnect(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{Reconnect: true, MinBackoff: 10 * time.Millisecond})
+
+	field := share.HostLandRequest{Name: "field", Width: 10, Height: 10, Empty: true}
+	if err := cluster.Server().HostLand(&field); err != nil {
+		t.Fatal(err)
+	}
+	bob := share.HandoffRequest{To: "field", At: &share.Point{X: 1, Y: 1}, Human: &share.Human{Name: "Bob"}}
+	if err := cluster.Server().Handoff(&bob); err != nil {
+		t.Fatal(err)
+	}
+
+	req := share.SyncRequest{Land: "field", Region: &share.Region{X: 0, Y: 0, Width: 3, Height: 3}}
+	stream, err := cl.SyncCtx(context.Background(), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for {
+		if item := nextSync(t, stream); item.Type == share.InfoItemTypeDone {
+			break
+		}
+	}
+
+	cluster.Disconnect()
+	if item := nextSync(t, stream); item.Type != share.InfoItemTypeTileRegion {
+		t.Fatalf("the stream went on with a %s item, want a new snapshot", item.Type)
+	}
+	for {
+		if item := nextSync(t, stream); item.Type == share.InfoItemTypeDone {
+			break
+		}
+	}
+
+	// the new stream is scoped to the region as well.
+	for _, h := range []share.HandoffRequest{
+		{To: "field", At: &share.Point{X: 8, Y: 1}, Human: &share.Human{Name: "Erin"}},
+		{To: "field", At: &share.Point{X: 1, Y: 2}, Human: &share.Human{Name: "Dave"}},
+	} {
+		if err := cluster.Server().Handoff(&h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	item := nextSync(t, stream)
+	var enter share.SpriteEnter
+	json.Unmarshal(item.Payload, &enter)
+	if item.Type != share.EventTypeEnter || enter.Name != "Dave" || !item.Coalesced {
+		t.Fatalf("got a %s event of %+v, want Dave entering", item.Type, enter)
+	}
+}
@@ -0,0 +1,348 @@
+// Package wondertest runs stages and a server inside the test process, on
+// ephemeral ports, so client code and commands can be tested end to end:
+//
+//	cluster, err := wondertest.Start(&wondertest.Config{})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer cluster.Close()
+//
+//	cl, err := cluster.Client(&client.Config{})
+//	...
+//	cluster.Step(25) // the rabbit jumps
+//
+// The land is made from a fixed seed and only moves on Step. Responses can be
+// canned, failed or slowed down with Respond, Fail and SlowStreams.
+package wondertest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/cmd/wonder/command/server"
+	"github.com/nickelchen/wonder/cmd/wonder/command/stage"
+	"github.com/nickelchen/wonder/land"
+	"github.com/nickelchen/wonder/share"
+)
+
+var DefaultSeed int64 = 1
+var DefaultRow = 10
+var DefaultCol = 20
+
+type Config struct {
+	// the land, zero values mean the defaults above.
+	Seed int64
+	Row  int
+	Col  int
+
+	// AutoTick lets the land move by itself, as a real server does.
+	AutoTick bool
+
+	EventBufferSize int
+	EventQueueSize  int
+
+	// HealthInterval turns on the health checks of the stages.
+	HealthInterval time.Duration
+
+	// Stages is how many stages there are, 0 means 1. they replicate to each
+	// other every ReplicateInterval, DefaultReplicateInterval if 0, and the
+	// server fails over from the first to the others.
+	Stages            int
+	ReplicateInterval time.Duration
+}
+
+var DefaultReplicateInterval = 50 * time.Millisecond
+
+// Cluster is one or more stages with one server reporting to the first.
+type Cluster struct {
+	// StageAddr is the first of StageAddrs.
+	StageAddr  string
+	StageAddrs []string
+	ServerAddr string
+
+	stages    []*stage.Stage
+	stageIPCs []*stage.StageIPC
+	server    *server.Server
+	serverIPC *server.ServerIPC
+
+	lock      sync.Mutex
+	canned    map[string]cannedResponse
+	delay     time.Duration
+	failSends int
+}
+
+type cannedResponse struct {
+	err  string
+	body interface{}
+}
+
+// ErrInjected is what a connection broken by FailSends dies of.
+var ErrInjected = errors.New("wondertest: injected send failure")
+
+func Start(config *Config) (*Cluster, error) {
+	c := Cluster{
+		canned: make(map[string]cannedResponse),
+	}
+
+	n := config.Stages
+	if n <= 0 {
+		n = 1
+	}
+	replicateInterval := config.ReplicateInterval
+	if replicateInterval == 0 {
+		replicateInterval = DefaultReplicateInterval
+	}
+
+	// every stage has to know the addresses of the others up front.
+	var stageLns []net.Listener
+	for i := 0; i < n; i++ {
+		stageLn, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			for _, ln := range stageLns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		stageLns = append(stageLns, stageLn)
+		c.StageAddrs = append(c.StageAddrs, stageLn.Addr().String())
+	}
+	c.StageAddr = c.StageAddrs[0]
+
+	for i, stageLn := range stageLns {
+		var peers []string
+		for k, addr := range c.StageAddrs {
+			if k != i {
+				peers = append(peers, addr)
+			}
+		}
+		stageConfig := stage.Config{
+			Name:              fmt.Sprintf("wondertest-%d", i),
+			RPCAddr:           c.StageAddrs[i],
+			Peers:             peers,
+			ReplicateInterval: replicateInterval,
+			HealthInterval:    config.HealthInterval,
+		}
+		s := stage.Create(&stageConfig)
+		s.Enter()
+
+		stageHooks := stage.Hooks{
+			Request: c.request,
+			Send:    c.send,
+		}
+		c.stages = append(c.stages, s)
+		c.stageIPCs = append(c.stageIPCs, stage.NewStageIPCWithHooks(s, stageLn, &stageHooks))
+	}
+
+	landConfig := land.Config{
+		Seed:       config.Seed,
+		Row:        config.Row,
+		Col:        config.Col,
+		ManualTick: !config.AutoTick,
+	}
+	if landConfig.Seed == 0 {
+		landConfig.Seed = DefaultSeed
+	}
+	if landConfig.Row == 0 {
+		landConfig.Row = DefaultRow
+	}
+	if landConfig.Col == 0 {
+		landConfig.Col = DefaultCol
+	}
+
+	serverConfig := server.Config{
+		StageAddrs:      c.StageAddrs,
+		StageTimeout:    server.DefaultStageTimeout,
+		EventBufferSize: config.EventBufferSize,
+		EventQueueSize:  config.EventQueueSize,
+		LandConfig:      &landConfig,
+	}
+	c.server = server.Create(&serverConfig)
+	c.server.Enter()
+
+	serverLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.shutdownStages()
+		return nil, err
+	}
+	serverConfig.ServerAddr = serverLn.Addr().String()
+	serverHooks := server.Hooks{
+		Request: c.request,
+		Send:    c.send,
+	}
+	c.serverIPC = server.NewServerIPCWithHooks(c.server, serverLn, &serverHooks)
+	c.ServerAddr = serverConfig.ServerAddr
+
+	if ok := c.server.ConnectStage(); !ok {
+		c.Close()
+		return nil, fmt.Errorf("wondertest: server can not connect to stage %s", c.StageAddr)
+	}
+	if err := c.server.ReportStage(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Close stops the server and the stages, and drops their connections.
+func (c *Cluster) Close() {
+	c.serverIPC.Shutdown()
+	c.server.DisconnectStage()
+	c.shutdownStages()
+}
+
+func (c *Cluster) shutdownStages() {
+	for _, ipc := range c.stageIPCs {
+		ipc.Shutdown()
+	}
+}
+
+// StopStage takes stage i down, its clients lose their connection and can
+// not dial it again. the server fails over to the next stage.
+func (c *Cluster) StopStage(i int) {
+	c.stageIPCs[i].Shutdown()
+}
+
+func (c *Cluster) Server() *server.Server {
+	return c.server
+}
+
+// Stage is the first stage.
+func (c *Cluster) Stage() *stage.Stage {
+	return c.stages[0]
+}
+
+func (c *Cluster) Stages() []*stage.Stage {
+	return c.stages
+}
+
+func (c *Cluster) Land() *land.Land {
+	return c.server.Land()
+}
+
+// Step moves the land n ticks on. The events of the ticks reach subscribers
+// shortly after.
+func (c *Cluster) Step(n int) {
+	for i := 0; i < n; i++ {
+		c.server.Land().Step()
+	}
+}
+
+// Report makes the server report to the stage now, instead of waiting for
+// its report interval.
+func (c *Cluster) Report() error {
+	return c.server.ReportStage()
+}
+
+// Client dials the server. config.Addr is filled in.
+func (c *Cluster) Client(config *client.Config) (*client.RPCClient, error) {
+	clientConfig := *config
+	clientConfig.Addr = c.ServerAddr
+	return client.ClientFromConfig(&clientConfig)
+}
+
+// StageClient dials the first stage. config.Addr is filled in.
+func (c *Cluster) StageClient(config *client.Config) (*client.RPCClient, error) {
+	return c.StageClientOf(0, config)
+}
+
+// StageClientOf dials stage i. config.Addr is filled in.
+func (c *Cluster) StageClientOf(i int, config *client.Config) (*client.RPCClient, error) {
+	clientConfig := *config
+	clientConfig.Addr = c.StageAddrs[i]
+	return client.ClientFromConfig(&clientConfig)
+}
+
+// Respond answers every later request of command with body, by the server or
+// the stage, without calling the handler. For a stream command body is the
+// first response, no items follow.
+func (c *Cluster) Respond(command string, body interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.canned[command] = cannedResponse{body: body}
+}
+
+// Fail answers every later request of command with the error err.
+func (c *Cluster) Fail(command string, err string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.canned[command] = cannedResponse{err: err}
+}
+
+// SlowStreams delays every response by d, stream items included.
+func (c *Cluster) SlowStreams(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.delay = d
+}
+
+// FailSends makes the next n responses fail, each one drops the connection
+// it was sent on.
+func (c *Cluster) FailSends(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.failSends = n
+}
+
+// Disconnect drops every connection to the server, as a failing network
+// would. the clients may dial it again.
+func (c *Cluster) Disconnect() {
+	c.serverIPC.DropClients()
+}
+
+// Reset takes back Respond, Fail, SlowStreams and FailSends.
+func (c *Cluster) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.canned = make(map[string]cannedResponse)
+	c.delay = 0
+	c.failSends = 0
+}
+
+func (c *Cluster) request(command string, seq uint64) (*share.ResponseHeader, interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	canned, ok := c.canned[command]
+	if !ok {
+		return nil, nil
+	}
+
+	respHeader := share.ResponseHeader{
+		Seq:   seq,
+		Error: canned.err,
+	}
+	// every header has a body.
+	body := canned.body
+	if body == nil {
+		body = struct{}{}
+	}
+	return &respHeader, body
+}
+
+func (c *Cluster) send(header *share.ResponseHeader) error {
+	c.lock.Lock()
+	delay := c.delay
+	fail := c.failSends > 0
+	if fail {
+		c.failSends--
+	}
+	c.lock.Unlock()
+
+	if fail {
+		return ErrInjected
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package wondertest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickelchen/wonder/client"
+	"github.com/nickelchen/wonder/share"
+	"github.com/nickelchen/wonder/wondertest"
+)
+
+func TestRespond(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	cluster.Respond(share.PingCommand, &share.PingResponse{Tick: 42})
+	resp, err := cl.PingCtx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Tick != 42 {
+		t.Fatalf("ping answered tick %d, want the canned 42", resp.Tick)
+	}
+
+	cluster.Reset()
+	if resp, err = cl.PingCtx(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Tick == 42 {
+		t.Fatal("ping still canned after Reset")
+	}
+}
+
+func TestFail(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	req := share.PlantRequest{What: share.PlantFlower, Color: "red", Number: 1}
+	cluster.Fail(share.PlantCommand, "no room")
+	if _, err := cl.PlantCtx(context.Background(), &req); err == nil || err.Error() != "no room" {
+		t.Fatalf("plant got %v, want no room", err)
+	}
+
+	cluster.Reset()
+	if _, err := cl.PlantCtx(context.Background(), &req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSlowStreamsBoundsTheCall(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	cluster.SlowStreams(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cl.PingCtx(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("slow ping got %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	stateCh := make(chan client.ConnState, 8)
+	cl := dial(t, cluster, &client.Config{
+		Reconnect:  true,
+		MinBackoff: 10 * time.Millisecond,
+		StateCh:    stateCh,
+	})
+
+	if state := nextState(t, stateCh); state != client.StateConnected {
+		t.Fatalf("dialed client is %s", state)
+	}
+
+	cluster.Disconnect()
+	if state := nextState(t, stateCh); state != client.StateDisconnected {
+		t.Fatalf("client is %s after the disconnect", state)
+	}
+	if state := nextState(t, stateCh); state != client.StateConnected {
+		t.Fatalf("client is %s after the reconnect", state)
+	}
+	if _, err := cl.PingCtx(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribeResumesFromTheEventBuffer(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{Reconnect: true, MinBackoff: 10 * time.Millisecond})
+
+	stream, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	cluster.Step(rabbitJumps)
+	lastSeq := nextEvent(t, stream).Seq
+	last := cluster.Land().Snapshot().Seq
+	for lastSeq < last {
+		lastSeq = nextEvent(t, stream).Seq
+	}
+
+	// the events of these steps wait in the buffer while the client is away.
+	cluster.FailSends(1)
+	cluster.Step(5)
+	last = cluster.Land().Snapshot().Seq
+	for lastSeq < last {
+		event := nextEvent(t, stream)
+		if event.Seq != lastSeq+1 {
+			t.Fatalf("got seq %d after %d", event.Seq, lastSeq)
+		}
+		lastSeq = event.Seq
+	}
+}
+
+func TestResumeTooOld(t *testing.T) {
+	cluster := start(t, &wondertest.Config{EventBufferSize: 4})
+	// slow enough to come back after the steps below.
+	cl := dial(t, cluster, &client.Config{Reconnect: true, MinBackoff: 300 * time.Millisecond})
+
+	events, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+	synced, err := cl.SyncCtx(context.Background(), &share.SyncRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer synced.Close()
+
+	for {
+		if item := nextSync(t, synced); item.Type == share.InfoItemTypeDone {
+			break
+		}
+	}
+	// both streams have a seq to resume from.
+	cluster.Step(rabbitJumps)
+	nextEvent(t, events)
+	nextSync(t, synced)
+
+	cluster.Disconnect()
+	cluster.Step(20)
+
+	// the events from before the disconnect come first.
+	within(t, "end of the event stream", func() {
+		for err == nil {
+			_, err = events.Next()
+		}
+	})
+	if err != client.ErrSeqTooOld {
+		t.Fatalf("event stream ended with %v, want ErrSeqTooOld", err)
+	}
+
+	// the sync stream starts over with a new snapshot instead.
+	for {
+		if item := nextSync(t, synced); item.Type == share.InfoItemTypeTile {
+			break
+		}
+	}
+}
+
+func TestDropOldestPolicy(t *testing.T) {
+	cluster := start(t, &wondertest.Config{EventQueueSize: 4})
+	cl := dial(t, cluster, &client.Config{})
+
+	stream, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{Policy: share.PolicyDropOldest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	lastSeq := cluster.Land().Snapshot().Seq
+	cluster.SlowStreams(5 * time.Millisecond)
+	cluster.Step(rabbitJumps + 50)
+	last := cluster.Land().Snapshot().Seq
+
+	gaps := 0
+	for lastSeq < last {
+		event := nextEvent(t, stream)
+		if event.Seq != lastSeq+1 {
+			gaps++
+		}
+		if event.Coalesced {
+			t.Fatalf("event %d is coalesced by the drop-oldest policy", event.Seq)
+		}
+		lastSeq = event.Seq
+	}
+	if gaps == 0 {
+		t.Fatal("the slow stream dropped no events")
+	}
+}
+
+func TestCoalescePolicy(t *testing.T) {
+	cluster := start(t, &wondertest.Config{EventQueueSize: 16})
+	cl := dial(t, cluster, &client.Config{})
+
+	stream, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{Policy: share.PolicyCoalesce})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	lastSeq := cluster.Land().Snapshot().Seq
+	cluster.SlowStreams(5 * time.Millisecond)
+	cluster.Step(rabbitJumps + 50)
+	last := cluster.Land().Snapshot().Seq
+
+	positions := 0
+	for lastSeq < last {
+		event := nextEvent(t, stream)
+		if event.Seq != lastSeq+1 && !event.Coalesced {
+			t.Fatalf("seq %d after %d is not marked coalesced", event.Seq, lastSeq)
+		}
+		if event.Type == share.EventTypePosition {
+			positions++
+		}
+		lastSeq = event.Seq
+	}
+	if positions == 0 {
+		t.Fatal("the slow stream coalesced no moves")
+	}
+}
+
+func TestDisconnectPolicy(t *testing.T) {
+	cluster := start(t, &wondertest.Config{EventQueueSize: 4})
+	cl := dial(t, cluster, &client.Config{})
+
+	stream, err := cl.SubscribeCtx(context.Background(), &share.SubscribeRequest{Policy: share.PolicyDisconnect})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	cluster.SlowStreams(5 * time.Millisecond)
+	cluster.Step(rabbitJumps + 50)
+
+	within(t, "end of the event stream", func() {
+		for err == nil {
+			_, err = stream.Next()
+		}
+	})
+	if err != client.ErrSlowConsumer {
+		t.Fatalf("event stream ended with %v, want ErrSlowConsumer", err)
+	}
+}
+
+func nextState(t *testing.T, stateCh <-chan client.ConnState) client.ConnState {
+	t.Helper()
+
+	select {
+	case state := <-stateCh:
+		return state
+	case <-time.After(waitTime):
+		t.Fatalf("no connection state in %s", waitTime)
+	}
+	return client.StateClosed
+}
+
+func TestPingProbesEveryLand(t *testing.T) {
+	cluster := start(t, &wondertest.Config{})
+	cl := dial(t, cluster, &client.Config{})
+
+	if err := cluster.Server().HostLand(&share.HostLandRequest{Name: "north", Width: 10, Height: 10}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cl.PingCtx(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Lands) != 2 || resp.Lands[0].Name != "" || resp.Lands[1].Name != "north" {
+		t.Fatalf("ping answered for lands %+v", resp.Lands)
+	}
+	for _, l := range resp.Lands {
+		if l.Stuck {
+			t.Errorf("land %q is stuck", l.Name)
+		}
+	}
+}